@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"github.com/gato/lana/merchandise"
 	"github.com/google/uuid"
-	"sync"
+	"time"
 )
 
+// MaxItemCount - units of a product a basket can hold, with merchandise.MaxPrice keeps counts
+// and amounts far from overflowing
+const MaxItemCount = 10000
+
 type item struct {
 	Product merchandise.Product
 	Count   int64
@@ -16,32 +20,56 @@ type item struct {
 type ProductItem struct {
 	Product string `json:"product"`
 	Count   int64  `json:"count"`
+	// Unavailable - only set on basket lines of products removed from the catalog
+	Unavailable bool `json:"unavailable,omitempty"`
 }
 
-type basket struct {
-	id         string
-	items      map[string]item
-	promotions []Promotion
-	lock       *sync.RWMutex
-}
-
-func (basket *basket) getItems() []ProductItem {
-	basket.lock.RLock()
-	defer basket.lock.RUnlock()
-	items := make([]ProductItem, len(basket.items))
+func (data BasketData) getItems() []ProductItem {
+	items := make([]ProductItem, len(data.Items))
 	i := 0
-	for _, item := range basket.items {
-		items[i] = ProductItem{Product: item.Product.Code, Count: item.Count}
+	for code, count := range data.Items {
+		items[i] = ProductItem{Product: code, Count: count, Unavailable: !merchandise.IsValidProduct(code)}
 		i++
 	}
 	return items
 }
 
-// Mutex to syncronize accest to fake datastore (a simple map)
-var basketLock = sync.RWMutex{}
+// checked out baskets are frozen
+func (data BasketData) editable() error {
+	if data.CheckedOut {
+		return fmt.Errorf("Basket is checked out")
+	}
+	return nil
+}
 
-// poor man's datatstore
-var basketMap = make(map[string]basket)
+// ErrProductUnavailable - the basket holds products removed from the catalog, it can't be
+// priced nor checked out until they are removed from it
+var ErrProductUnavailable = fmt.Errorf("Basket holds unavailable products")
+
+// resolve products so promotions can work with prices, Price is the unit price
+// for the quantity in the basket (volume price tiers). Products removed from the
+// catalog can't be priced so baskets holding them fail with ErrProductUnavailable
+func (data BasketData) items() (map[string]item, error) {
+	items := make(map[string]item, len(data.Items))
+	for code, count := range data.Items {
+		if !merchandise.IsValidProduct(code) {
+			return nil, ErrProductUnavailable
+		}
+		product := merchandise.GetProduct(code)
+		product.Price = product.UnitPrice(count)
+		items[code] = item{Product: product, Count: count}
+	}
+	return items, nil
+}
+
+// basket promotions plus the ones unlocked by its coupons
+func (data BasketData) promotions() []Promotion {
+	promotions := data.promotionSet()
+	if len(data.Coupons) == 0 {
+		return promotions
+	}
+	return append(append([]Promotion(nil), promotions...), couponPromotions(data.Coupons)...)
+}
 
 // Basket - interface to access minimum needed basket functionanlity without exporting
 // internal implementation
@@ -49,31 +77,53 @@ type Basket interface {
 	GetID() string
 	GetItems() ([]ProductItem, error)
 	AddItem(ProductItem) (int64, error)
-	GetTotal() (float64, error)
+	SetQuantity(ProductItem) (int64, error)
+	RemoveItem(product string) error
+	Clear() error
+	GetTotal() (merchandise.Money, error)
+	GetReceipt() (Receipt, error)
+	Checkout() (Order, error)
+	ApplyCoupon(code string) ([]string, error)
+	RemoveCoupon(code string) ([]string, error)
+	GetCoupons() ([]string, error)
+	GetPromotions() (BasketPromotions, error)
+	GetTimes() (BasketTimes, error)
+	GetOwner() (string, error)
+	GetVersion() (int64, error)
 }
 
-func createBasket() (basket basket) {
+func createBasket(ttl time.Duration, owner string) (basket BasketData) {
 	uuid := uuid.Must(uuid.NewRandom())
 
-	basket.id = uuid.String()
-	basket.items = make(map[string]item)
-	basket.promotions = make([]Promotion, 2)
-	basket.lock = &sync.RWMutex{}
-	basket.promotions[0] = PenBuy2Get1
-	basket.promotions[1] = TshirtBuy3Get25OFF
-	return
-}
-
-func getBasket(id string) (basket basket, ok bool) {
-	basketLock.RLock()
-	defer basketLock.RUnlock()
-	basket, ok = basketMap[id]
+	basket.ID = uuid.String()
+	basket.Version = 1
+	basket.Items = make(map[string]int64)
+	basket.PromotionPolicy = defaultPromotionPolicy()
+	var rules []PromotionRule
+	basket.Promotions, rules, basket.PromotionsVersion = currentPromotions()
+	if basket.PromotionPolicy == PinnedPromotions {
+		basket.PromotionRules = rules
+	}
+	t := now()
+	basket.CreatedAt = t.UTC()
+	basket.UpdatedAt = basket.CreatedAt
+	basket.TTL = ttl
+	basket.Owner = owner
+	basket.extend(t)
 	return
 }
 
 // BasketWrapper - Implements Basket Interface
 type BasketWrapper struct {
 	id string
+	// ifMatch - versions the basket must be at to be changed, nil means any
+	ifMatch []int64
+	// version - left by the last change made through the wrapper
+	version *int64
+}
+
+func newBasketWrapper(id string, ifMatch []int64) BasketWrapper {
+	return BasketWrapper{id: id, ifMatch: ifMatch, version: new(int64)}
 }
 
 // GetID - Get Basket identifier for future reference
@@ -83,9 +133,9 @@ func (b BasketWrapper) GetID() string {
 
 // GetItems - Get Basket's item count
 func (b BasketWrapper) GetItems() ([]ProductItem, error) {
-	basket, ok := getBasket(b.id)
-	if !ok {
-		return nil, fmt.Errorf("Basket not found")
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return nil, err
 	}
 	return basket.getItems(), nil
 }
@@ -93,91 +143,169 @@ func (b BasketWrapper) GetItems() ([]ProductItem, error) {
 // AddItem - add "amount" items to basket
 // if product exist it will add the amount
 // if not will set
-func (b BasketWrapper) AddItem(_item ProductItem) (int64, error) {
-	basket, ok := getBasket(b.id)
-	if !ok {
-		return 0, fmt.Errorf("Basket not found")
+func (b BasketWrapper) AddItem(_item ProductItem) (count int64, err error) {
+	if _item.Count <= 0 || _item.Count > MaxItemCount {
+		return 0, fmt.Errorf("Invalid count")
 	}
-	// ADD item
-	basket.lock.Lock()
-	defer basket.lock.Unlock()
-	i, ok := basket.items[_item.Product]
-	if !ok {
-		i = item{Product: merchandise.GetProduct(_item.Product), Count: 0}
-	}
-	i.Count = i.Count + _item.Count
-	basket.items[_item.Product] = i
+	err = b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		count = basket.Items[_item.Product] + _item.Count
+		if count > MaxItemCount {
+			return fmt.Errorf("Invalid count")
+		}
+		basket.Items[_item.Product] = count
+		return nil
+	})
+	return
+}
 
-	return i.Count, nil
+// SetQuantity - set the absolute amount of items of a product
+// a count of 0 removes the product from basket
+func (b BasketWrapper) SetQuantity(_item ProductItem) (int64, error) {
+	if _item.Count < 0 || _item.Count > MaxItemCount {
+		return 0, fmt.Errorf("Invalid count")
+	}
+	err := b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		if _item.Count == 0 {
+			delete(basket.Items, _item.Product)
+			return nil
+		}
+		basket.Items[_item.Product] = _item.Count
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return _item.Count, nil
 }
 
-// GetTotal - calculate amount to be paid for the basket
-func (b BasketWrapper) GetTotal() (float64, error) {
-	// TODO GET basket
-	basket, ok := getBasket(b.id)
-	if !ok {
-		return 0, fmt.Errorf("Basket not found")
-	}
-	var total float64 = 0
-	basket.lock.RLock()
-	defer basket.lock.RUnlock()
-	// sumarize products
-	for _, item := range basket.items {
-		total += (item.Product.Price * float64(item.Count))
-	}
-	// calculate discounts
-	for _, promo := range basket.promotions {
-		discounts, err := promo.Apply(basket.items)
-		if err != nil {
-			return 0, err
+// RemoveItem - remove a product line from basket
+func (b BasketWrapper) RemoveItem(product string) error {
+	return b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
 		}
-		for _, discount := range discounts {
-			total -= discount.Amount
+		if _, ok := basket.Items[product]; !ok {
+			return fmt.Errorf("Product not in basket")
 		}
+		delete(basket.Items, product)
+		return nil
+	})
+}
+
+// Clear - remove every item from basket
+func (b BasketWrapper) Clear() error {
+	return b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		basket.Items = make(map[string]int64)
+		return nil
+	})
+}
+
+// GetOwner - who the basket belongs to, empty for anonymous baskets
+func (b BasketWrapper) GetOwner() (string, error) {
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return "", err
+	}
+	return basket.Owner, nil
+}
+
+// GetTotal - calculate amount to be paid for the basket
+func (b BasketWrapper) GetTotal() (merchandise.Money, error) {
+	receipt, err := b.GetReceipt()
+	if err != nil {
+		return merchandise.Cents(0), err
+	}
+	return receipt.Total, nil
+}
+
+// GetReceipt - itemized lines, applied discounts and totals of the basket
+func (b BasketWrapper) GetReceipt() (Receipt, error) {
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return Receipt{}, err
+	}
+	return basket.receipt()
+}
+
+func (data BasketData) receipt() (Receipt, error) {
+	items, err := data.items()
+	if err != nil {
+		return Receipt{}, err
 	}
-	// RETURN total
-	return total, nil
+	return computeReceipt(items, data.promotions())
 }
 
 // NewBasket - creates a new basket and returns a BasketWrapper to it
-func NewBasket() Basket {
-	basket := createBasket()
-	// Get write lock (no need to check for existance as we asume uuids are unique)
-	basketLock.Lock()
-	defer basketLock.Unlock()
-	// ADD to map
-	basketMap[basket.id] = basket
-	return BasketWrapper{id: basket.id}
+func NewBasket() (Basket, error) {
+	return NewBasketWithOptions(BasketOptions{})
 }
 
-// GetBasket - Get basket by id
+// NewBasketWithOptions - creates a new basket with options (i.e. its own ttl)
+func NewBasketWithOptions(options BasketOptions) (Basket, error) {
+	ttl := currentBasketExpiry().TTL
+	if options.TTLSeconds != nil {
+		if *options.TTLSeconds < 0 {
+			return nil, fmt.Errorf("Invalid ttl")
+		}
+		ttl = time.Duration(*options.TTLSeconds) * time.Second
+	}
+	basket := createBasket(ttl, options.Owner)
+	// no need to check for existance as we asume uuids are unique
+	if err := store.Put(basket); err != nil {
+		return nil, err
+	}
+	return newBasketWrapper(basket.ID, nil), nil
+}
+
+// GetBasket - Get basket by id, reading it extends its life when baskets are touched on read
 func GetBasket(id string) (Basket, error) {
-	basket, ok := getBasket(id)
-	if !ok {
-		return nil, fmt.Errorf("Basket not found")
+	if err := touchBasket(id); err != nil {
+		return nil, err
 	}
-	return BasketWrapper{id: basket.id}, nil
+	return newBasketWrapper(id, nil), nil
 }
 
-// ListBaskets - Get Baskets ids with item count
-func ListBaskets() []Basket {
-	basketLock.RLock()
-	defer basketLock.RUnlock()
-	list := make([]Basket, 0)
-	for _, basket := range basketMap {
-		list = append(list, BasketWrapper{id: basket.id})
+// ListBaskets - Get every Basket sorted by creation time, see QueryBaskets to get them by pages
+func ListBaskets() ([]Basket, error) {
+	baskets, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	sortBaskets(baskets)
+	t := now()
+	list := make([]Basket, 0, len(baskets))
+	for _, basket := range baskets {
+		if !basket.expired(t) {
+			list = append(list, newBasketWrapper(basket.ID, nil))
+		}
 	}
-	return list
+	return list, nil
 }
 
 // DeleteBasket - Remove a Basket from storage
 func DeleteBasket(id string) error {
-	basketLock.RLock()
-	defer basketLock.RUnlock()
-	_, ok := basketMap[id]
-	if !ok {
-		return fmt.Errorf("Basket not found")
-	}
-	delete(basketMap, id)
-	return nil
+	return deleteBasket(id, nil)
+}
+
+// remove a basket, when versions is not nil only if it is at one of them
+func deleteBasket(id string, versions []int64) error {
+	b := newBasketWrapper(id, versions)
+	return store.DeleteIf(id, func(basket BasketData) error {
+		if basket.expired(now()) {
+			return ErrBasketNotFound
+		}
+		if !b.matches(basket.Version) {
+			return ErrBasketModified
+		}
+		return nil
+	})
 }
@@ -7,8 +7,8 @@ import (
 )
 
 func TestNewBasket(t *testing.T) {
-	basketMap = make(map[string]basket)
-	basket := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	basket, _ := NewBasket()
 	if basket.GetID() == "" {
 		t.Errorf("Basket ID should not be empty")
 		return
@@ -22,13 +22,13 @@ func TestNewBasket(t *testing.T) {
 		t.Errorf("Initial Get Items should have no items")
 		return
 	}
-	if len(basketMap) != 1 {
-		t.Errorf("Basket was not added to map")
+	if baskets, _ := ListBaskets(); len(baskets) != 1 {
+		t.Errorf("Basket was not added to store")
 		return
 	}
-	basket2 := NewBasket()
-	if len(basketMap) != 2 {
-		t.Errorf("Basket2 was not added to map")
+	basket2, _ := NewBasket()
+	if baskets, _ := ListBaskets(); len(baskets) != 2 {
+		t.Errorf("Basket2 was not added to store")
 		return
 	}
 	if basket2.GetID() == basket.GetID() {
@@ -37,8 +37,8 @@ func TestNewBasket(t *testing.T) {
 }
 
 func TestGetBasket(t *testing.T) {
-	basketMap = make(map[string]basket)
-	basket := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	basket, _ := NewBasket()
 	basket2, err := GetBasket(basket.GetID())
 	if err != nil {
 		t.Errorf("GetBasket returned an error %s", err.Error())
@@ -50,9 +50,9 @@ func TestGetBasket(t *testing.T) {
 }
 
 func TestGetBasketNotFound(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
-	basketMap = make(map[string]basket)
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
 	_, err := GetBasket(b.GetID())
 	if err == nil {
 		t.Errorf("GetBasket should have returned an error")
@@ -65,8 +65,8 @@ func TestGetBasketNotFound(t *testing.T) {
 }
 
 func TestAddItem(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	count, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
 	if err != nil {
 		t.Errorf("AddItem returned an error %s", err.Error())
@@ -88,8 +88,8 @@ func TestAddItem(t *testing.T) {
 }
 
 func TestMixedAddItem(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	count, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
 	if err != nil {
 		t.Errorf("AddItem returned an error %s", err.Error())
@@ -111,8 +111,8 @@ func TestMixedAddItem(t *testing.T) {
 }
 
 func TestAddItemError(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	count, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
 	if err != nil {
 		t.Errorf("AddItem returned an error %s", err.Error())
@@ -122,8 +122,8 @@ func TestAddItemError(t *testing.T) {
 		t.Errorf("wrong number of items expected 2 got %d", count)
 		return
 	}
-	// Clear merchandise map to force an error
-	basketMap = make(map[string]basket)
+	// Clear basket store to force an error
+	SetBasketStore(NewMemoryBasketStore())
 	_, err = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
 	if err == nil {
 		t.Errorf("An error was expected")
@@ -142,8 +142,8 @@ func findItem(items []ProductItem, code string) (item ProductItem, err error) {
 }
 
 func TestGetItems(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
 	items, err := b.GetItems()
 	if err != nil {
@@ -182,8 +182,8 @@ func TestGetItems(t *testing.T) {
 }
 
 func TestGetItemsError(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
 	items, err := b.GetItems()
 	if err != nil {
@@ -194,8 +194,8 @@ func TestGetItemsError(t *testing.T) {
 		t.Errorf("wrong number of items expected 1 got %d", len(items))
 		return
 	}
-	// Clear merchandise map to force an error
-	basketMap = make(map[string]basket)
+	// Clear basket store to force an error
+	SetBasketStore(NewMemoryBasketStore())
 	_, err = b.GetItems()
 	if err == nil {
 		t.Errorf("An error was expected")
@@ -213,18 +213,18 @@ func findBasket(baskets []Basket, id string) (Basket, error) {
 }
 
 func TestListBaskets(t *testing.T) {
-	basketMap = make(map[string]basket)
-	baskets := ListBaskets()
+	SetBasketStore(NewMemoryBasketStore())
+	baskets, _ := ListBaskets()
 	if len(baskets) != 0 {
 		t.Errorf("wrong number of baskets expected 0 got %d", len(baskets))
 		return
 	}
-	b1 := NewBasket()
+	b1, _ := NewBasket()
 	_, _ = b1.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
-	b2 := NewBasket()
+	b2, _ := NewBasket()
 	_, _ = b2.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 3})
 
-	baskets = ListBaskets()
+	baskets, _ = ListBaskets()
 	if len(baskets) != 2 {
 		t.Errorf("wrong number of baskets expected 2 got %d", len(baskets))
 		return
@@ -245,7 +245,7 @@ func TestListBaskets(t *testing.T) {
 func TestGetTotal(t *testing.T) {
 	// Items: PEN, TSHIRT, MUG
 	// Total: 32.50€
-	b := NewBasket()
+	b, _ := NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
@@ -254,42 +254,42 @@ func TestGetTotal(t *testing.T) {
 		t.Errorf("GetTotal returned an error %s", err.Error())
 		return
 	}
-	expected := 32.5
+	expected := merchandise.Cents(3250)
 	if total != expected {
-		t.Errorf("invalid total expected %.2f got %.2f", expected, total)
+		t.Errorf("invalid total expected %s got %s", expected, total)
 		return
 	}
 	// Items: PEN, TSHIRT, PEN
 	// Total: 25.00€
-	b = NewBasket()
+	b, _ = NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	total, _ = b.GetTotal()
-	expected = 25
+	expected = merchandise.Cents(2500)
 	if total != expected {
-		t.Errorf("invalid total expected %.2f got %.2f", expected, total)
+		t.Errorf("invalid total expected %s got %s", expected, total)
 		return
 	}
 
 	// Items: TSHIRT, TSHIRT, TSHIRT, PEN, TSHIRT
 	// Total: 65.00€
-	b = NewBasket()
+	b, _ = NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	total, _ = b.GetTotal()
-	expected = 65
+	expected = merchandise.Cents(6500)
 	if total != expected {
-		t.Errorf("invalid total expected %.2f got %.2f", expected, total)
+		t.Errorf("invalid total expected %s got %s", expected, total)
 		return
 	}
 
 	// Items: PEN, TSHIRT, PEN, PEN, MUG, TSHIRT, TSHIRT
 	// Total: 62.50€
-	b = NewBasket()
+	b, _ = NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
@@ -298,18 +298,18 @@ func TestGetTotal(t *testing.T) {
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
 	total, _ = b.GetTotal()
-	expected = 62.50
+	expected = merchandise.Cents(6250)
 	if total != expected {
-		t.Errorf("invalid total expected %.2f got %.2f", expected, total)
+		t.Errorf("invalid total expected %s got %s", expected, total)
 		return
 	}
 }
 
 func TestGetTotalBasketNotFoundError(t *testing.T) {
-	b := NewBasket()
+	b, _ := NewBasket()
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	// force error
-	basketMap = make(map[string]basket)
+	SetBasketStore(NewMemoryBasketStore())
 	_, err := b.GetTotal()
 	if err == nil {
 		t.Errorf("GetBasket should have returned an error")
@@ -329,18 +329,16 @@ func (promotion FailingPromo) Apply(Items map[string]item) (discounts []Discount
 }
 
 func TestGetTotalPromotionError(t *testing.T) {
-	basketMap = make(map[string]basket)
-	b := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
 	// Here I'm adding a failing promotion into basket internals
 	// This can't be done from the outside as users will only see
 	// the public interfase
 	// Only for the sake of test coverage. no real value here
-	// get the internal basket representation (in a not thread safe way)
-	internalBasket, _ := basketMap[b.GetID()]
-	// add new failing promotion
-	internalBasket.promotions = append(basketMap[b.GetID()].promotions, FailingPromo{})
-	// replace basket
-	basketMap[b.GetID()] = internalBasket
+	_ = store.Update(b.GetID(), func(basket *BasketData) error {
+		basket.Promotions = append(basket.Promotions, FailingPromo{})
+		return nil
+	})
 	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
 	_, err := b.GetTotal()
 	if err == nil {
@@ -354,9 +352,9 @@ func TestGetTotalPromotionError(t *testing.T) {
 }
 
 func TestDeleteBasket(t *testing.T) {
-	basketMap = make(map[string]basket)
-	basket := NewBasket()
-	baskets := ListBaskets()
+	SetBasketStore(NewMemoryBasketStore())
+	basket, _ := NewBasket()
+	baskets, _ := ListBaskets()
 	if len(baskets) != 1 {
 		t.Errorf("wrong number of baskets expected 1 got %d", len(baskets))
 		return
@@ -366,7 +364,7 @@ func TestDeleteBasket(t *testing.T) {
 		t.Errorf("DeleteBasket returned an error %s", err.Error())
 		return
 	}
-	baskets = ListBaskets()
+	baskets, _ = ListBaskets()
 	if len(baskets) != 0 {
 		t.Errorf("wrong number of baskets expected 0 got %d", len(baskets))
 		return
@@ -383,7 +381,7 @@ func TestDeleteBasket(t *testing.T) {
 }
 
 func TestDeleteBasketNotFoundError(t *testing.T) {
-	basketMap = make(map[string]basket)
+	SetBasketStore(NewMemoryBasketStore())
 	err := DeleteBasket("1234")
 	if err == nil {
 		t.Errorf("DeleteBasket should have returned an error")
@@ -394,3 +392,191 @@ func TestDeleteBasketNotFoundError(t *testing.T) {
 		t.Errorf("Wrong error expected %s but got %s", expected, err.Error())
 	}
 }
+
+func TestAddItemInvalidCount(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	for _, count := range []int64{0, -1, MaxItemCount + 1, 1<<63 - 1} {
+		_, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: count})
+		if err == nil {
+			t.Errorf("AddItem should have returned an error for count %d", count)
+			return
+		}
+		expected := "Invalid count"
+		if err.Error() != expected {
+			t.Errorf("Wrong error expected %s but got %s", expected, err.Error())
+		}
+	}
+}
+
+func TestAddItemOverflow(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	if _, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: MaxItemCount}); err != nil {
+		t.Errorf("AddItem returned an error %s", err.Error())
+		return
+	}
+	if _, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1}); err == nil || err.Error() != "Invalid count" {
+		t.Errorf("AddItem should not go over the maximum count got %v", err)
+		return
+	}
+	if items, _ := b.GetItems(); items[0].Count != MaxItemCount {
+		t.Errorf("rejected count should not change the basket got %v", items)
+		return
+	}
+}
+
+func TestDeletedProduct(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	if err := merchandise.CreateProduct(merchandise.Product{Code: "CAP", Name: "Lana Cap", Price: merchandise.Cents(900)}); err != nil {
+		t.Errorf("CreateProduct returned an error %s", err.Error())
+		return
+	}
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = b.AddItem(ProductItem{Product: "CAP", Count: 1})
+	_ = merchandise.DeleteProduct("CAP")
+	if _, err := b.GetTotal(); err != ErrProductUnavailable {
+		t.Errorf("GetTotal should fail with %v got %v", ErrProductUnavailable, err)
+		return
+	}
+	if _, err := b.Checkout(); err != ErrProductUnavailable {
+		t.Errorf("Checkout should fail with %v got %v", ErrProductUnavailable, err)
+		return
+	}
+	items, _ := b.GetItems()
+	for _, item := range items {
+		if item.Unavailable != (item.Product == "CAP") {
+			t.Errorf("wrong availability of %s", item.Product)
+			return
+		}
+	}
+	page, err := QueryBaskets(BasketQuery{Summary: true})
+	if err != nil || len(page.Baskets) != 1 || page.Baskets[0].Total != nil {
+		t.Errorf("listing should leave the total out got %v %v", page, err)
+		return
+	}
+	_ = b.RemoveItem("CAP")
+	if total, err := b.GetTotal(); err != nil || total != merchandise.Cents(500) {
+		t.Errorf("expected total 5.00 got %v %v", total, err)
+		return
+	}
+}
+
+func TestSetQuantity(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 5})
+	count, err := b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: 2})
+	if err != nil {
+		t.Errorf("SetQuantity returned an error %s", err.Error())
+		return
+	}
+	if count != 2 {
+		t.Errorf("wrong number of items expected 2 got %d", count)
+		return
+	}
+	count, err = b.SetQuantity(ProductItem{Product: merchandise.MUG, Count: 3})
+	if err != nil {
+		t.Errorf("SetQuantity returned an error %s", err.Error())
+		return
+	}
+	if count != 3 {
+		t.Errorf("wrong number of items expected 3 got %d", count)
+		return
+	}
+	items, _ := b.GetItems()
+	if len(items) != 2 {
+		t.Errorf("wrong number of items expected 2 got %d", len(items))
+		return
+	}
+	// setting 0 removes the line
+	count, err = b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: 0})
+	if err != nil {
+		t.Errorf("SetQuantity returned an error %s", err.Error())
+		return
+	}
+	if count != 0 {
+		t.Errorf("wrong number of items expected 0 got %d", count)
+		return
+	}
+	items, _ = b.GetItems()
+	if len(items) != 1 {
+		t.Errorf("wrong number of items expected 1 got %d", len(items))
+		return
+	}
+}
+
+func TestSetQuantityErrors(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	for _, count := range []int64{-1, MaxItemCount + 1} {
+		_, err := b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: count})
+		if err == nil || err.Error() != "Invalid count" {
+			t.Errorf("SetQuantity should have returned Invalid count error for %d", count)
+			return
+		}
+	}
+	// force error
+	SetBasketStore(NewMemoryBasketStore())
+	_, err := b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: 1})
+	if err == nil || err.Error() != "Basket not found" {
+		t.Errorf("SetQuantity should have returned Basket not found error")
+	}
+}
+
+func TestRemoveItem(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+	err := b.RemoveItem(merchandise.PEN)
+	if err != nil {
+		t.Errorf("RemoveItem returned an error %s", err.Error())
+		return
+	}
+	items, _ := b.GetItems()
+	if len(items) != 1 || items[0].Product != merchandise.MUG {
+		t.Errorf("only MUG should remain in basket got %v", items)
+		return
+	}
+	err = b.RemoveItem(merchandise.PEN)
+	if err == nil || err.Error() != "Product not in basket" {
+		t.Errorf("RemoveItem should have returned Product not in basket error")
+		return
+	}
+	// force error
+	SetBasketStore(NewMemoryBasketStore())
+	err = b.RemoveItem(merchandise.MUG)
+	if err == nil || err.Error() != "Basket not found" {
+		t.Errorf("RemoveItem should have returned Basket not found error")
+	}
+}
+
+func TestClear(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+	err := b.Clear()
+	if err != nil {
+		t.Errorf("Clear returned an error %s", err.Error())
+		return
+	}
+	items, _ := b.GetItems()
+	if len(items) != 0 {
+		t.Errorf("wrong number of items expected 0 got %d", len(items))
+		return
+	}
+	total, _ := b.GetTotal()
+	if !total.IsZero() {
+		t.Errorf("invalid total expected 0 got %s", total)
+		return
+	}
+	// force error
+	SetBasketStore(NewMemoryBasketStore())
+	err = b.Clear()
+	if err == nil || err.Error() != "Basket not found" {
+		t.Errorf("Clear should have returned Basket not found error")
+	}
+}
@@ -0,0 +1,183 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"sort"
+	"strings"
+)
+
+// Bundle - buy every product of Items (code and quantity) together for a fixed Price
+type Bundle struct {
+	ID    string
+	Items map[string]int64
+	Price merchandise.Money
+}
+
+// MixAndMatch - any Quantity units from the products in Codes for a fixed Price
+// the most expensive units are grouped first so the customer saves the most
+type MixAndMatch struct {
+	ID       string
+	Codes    []string
+	Quantity int64
+	Price    merchandise.Money
+}
+
+// CrossProduct - buy BuyQuantity of BuyCode and get DiscountPercentage off GetQuantity of GetCode
+type CrossProduct struct {
+	ID                 string
+	BuyCode            string
+	BuyQuantity        int64
+	GetCode            string
+	GetQuantity        int64
+	DiscountPercentage int64
+}
+
+// Products - products used by the promotion
+func (promotion Bundle) Products() []string {
+	codes := make([]string, 0, len(promotion.Items))
+	for code := range promotion.Items {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Apply - Bundle, every complete set of items gets the difference between its regular and bundle price
+func (promotion Bundle) Apply(Items map[string]item) (discounts []Discount, err error) {
+	sets := int64(-1)
+	regular := merchandise.Cents(0)
+	names := make([]string, 0, len(promotion.Items))
+	for _, code := range promotion.Products() {
+		item, ok := Items[code]
+		quantity := promotion.Items[code]
+		if !ok || item.Count < quantity {
+			// bundle is not complete
+			return
+		}
+		if n := item.Count / quantity; sets < 0 || n < sets {
+			sets = n
+		}
+		regular = regular.Add(item.Product.Price.Mul(quantity))
+		name := item.Product.Name
+		if quantity > 1 {
+			name = fmt.Sprintf("%d %s", quantity, name)
+		}
+		names = append(names, name)
+	}
+	saving := regular.Sub(promotion.Price)
+	if sets <= 0 || !saving.IsPositive() {
+		return
+	}
+	units := make(map[string]int64, len(promotion.Items))
+	for code, quantity := range promotion.Items {
+		units[code] = quantity * sets
+	}
+	discounts = append(discounts, Discount{
+		Description: fmt.Sprintf("%s for %s", strings.Join(names, " + "), promotion.Price),
+		Amount:      saving.Mul(sets),
+		Promotion:   promotion.ID,
+		Units:       units,
+	})
+	return
+}
+
+// Products - products used by the promotion
+func (promotion MixAndMatch) Products() []string {
+	return promotion.Codes
+}
+
+// Apply - Mix and match, groups of Quantity units from the set are charged Price
+func (promotion MixAndMatch) Apply(Items map[string]item) (discounts []Discount, err error) {
+	type line struct {
+		code string
+		item
+	}
+	lines := make([]line, 0, len(promotion.Codes))
+	total := int64(0)
+	for _, code := range promotion.Codes {
+		if item, ok := Items[code]; ok && item.Count > 0 {
+			lines = append(lines, line{code: code, item: item})
+			total += item.Count
+		}
+	}
+	groups := total / promotion.Quantity
+	if groups == 0 {
+		return
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Product.Price.Amount != lines[j].Product.Price.Amount {
+			return lines[i].Product.Price.Amount > lines[j].Product.Price.Amount
+		}
+		return lines[i].code < lines[j].code
+	})
+	left := groups * promotion.Quantity
+	regular := merchandise.Cents(0)
+	units := make(map[string]int64)
+	for _, line := range lines {
+		n := line.Count
+		if n > left {
+			n = left
+		}
+		if n == 0 {
+			break
+		}
+		units[line.code] = n
+		regular = regular.Add(line.Product.Price.Mul(n))
+		left -= n
+	}
+	saving := regular.Sub(promotion.Price.Mul(groups))
+	if !saving.IsPositive() {
+		return
+	}
+	names := make([]string, len(promotion.Codes))
+	for i, code := range promotion.Codes {
+		names[i] = merchandise.GetProduct(code).Name
+	}
+	discounts = append(discounts, Discount{
+		Description: fmt.Sprintf("Any %d of %s for %s", promotion.Quantity, strings.Join(names, ", "), promotion.Price),
+		Amount:      saving,
+		Promotion:   promotion.ID,
+		Units:       units,
+	})
+	return
+}
+
+// Products - products used by the promotion
+func (promotion CrossProduct) Products() []string {
+	return []string{promotion.BuyCode, promotion.GetCode}
+}
+
+// Apply - Buy X of a product get Y of another one discounted
+func (promotion CrossProduct) Apply(Items map[string]item) (discounts []Discount, err error) {
+	bought, ok := Items[promotion.BuyCode]
+	if !ok {
+		return
+	}
+	got, ok := Items[promotion.GetCode]
+	if !ok {
+		return
+	}
+	times := bought.Count / promotion.BuyQuantity
+	if n := got.Count / promotion.GetQuantity; n < times {
+		times = n
+	}
+	if times == 0 {
+		return
+	}
+	d := got.Product.Price.Mul(promotion.GetQuantity * times).Percent(promotion.DiscountPercentage)
+	description := fmt.Sprintf("Buy %d %s get %d %s Free", promotion.BuyQuantity, bought.Product.Name, promotion.GetQuantity, got.Product.Name)
+	if promotion.DiscountPercentage < 100 {
+		description = fmt.Sprintf("Buy %d %s get %d%% off %d %s", promotion.BuyQuantity, bought.Product.Name, promotion.DiscountPercentage, promotion.GetQuantity, got.Product.Name)
+	}
+	discounts = append(discounts, Discount{
+		Description: description,
+		Amount:      d,
+		Promotion:   promotion.ID,
+		Units: map[string]int64{
+			promotion.BuyCode: promotion.BuyQuantity * times,
+			promotion.GetCode: promotion.GetQuantity * times,
+		},
+	})
+	return
+}
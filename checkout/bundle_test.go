@@ -0,0 +1,131 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"reflect"
+	"testing"
+)
+
+func basketItems(counts map[string]int64) map[string]item {
+	items := make(map[string]item, len(counts))
+	for code, count := range counts {
+		items[code] = item{Product: merchandise.GetProduct(code), Count: count}
+	}
+	return items
+}
+
+var shirtAndMug = Bundle{
+	ID:    "SHIRT_MUG",
+	Items: map[string]int64{merchandise.TSHIRT: 1, merchandise.MUG: 1},
+	Price: merchandise.Cents(2000),
+}
+
+func TestBundle(t *testing.T) {
+	// 2 complete bundles, the third shirt has no mug: (20.00 + 7.50 - 20.00) * 2
+	discounts, err := shirtAndMug.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 3, merchandise.MUG: 2}))
+	if err != nil {
+		t.Errorf("Bundle returned an error %s", err.Error())
+		return
+	}
+	if len(discounts) != 1 {
+		t.Errorf("Bundle discount was not applied")
+		return
+	}
+	expected := Discount{
+		Description: "Lana Coffee Mug + Lana T-Shirt for 20.00 EUR",
+		Amount:      merchandise.Cents(1500),
+		Promotion:   "SHIRT_MUG",
+		Units:       map[string]int64{merchandise.TSHIRT: 2, merchandise.MUG: 2},
+	}
+	if !reflect.DeepEqual(discounts[0], expected) {
+		t.Errorf("wrong discount expected %v got %v", expected, discounts[0])
+	}
+}
+
+func TestBundleNotApplied(t *testing.T) {
+	discounts, _ := shirtAndMug.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 3}))
+	if len(discounts) != 0 {
+		t.Errorf("incomplete bundle should not be discounted")
+	}
+	// bundle price above regular price
+	expensive := shirtAndMug
+	expensive.Price = merchandise.Cents(5000)
+	discounts, _ = expensive.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 1, merchandise.MUG: 1}))
+	if len(discounts) != 0 {
+		t.Errorf("bundle should never make items more expensive")
+	}
+}
+
+func TestMixAndMatch(t *testing.T) {
+	anyThree := MixAndMatch{ID: "ANY3", Codes: []string{merchandise.PEN, merchandise.MUG, merchandise.TSHIRT}, Quantity: 3, Price: merchandise.Cents(1500)}
+	// 1 shirt, 2 mugs, 2 pens: one group with the most expensive units (20.00 + 7.50 + 7.50)
+	discounts, err := anyThree.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 1, merchandise.MUG: 2, merchandise.PEN: 2}))
+	if err != nil {
+		t.Errorf("MixAndMatch returned an error %s", err.Error())
+		return
+	}
+	if len(discounts) != 1 {
+		t.Errorf("MixAndMatch discount was not applied")
+		return
+	}
+	expected := Discount{
+		Description: "Any 3 of Lana Pen, Lana Coffee Mug, Lana T-Shirt for 15.00 EUR",
+		Amount:      merchandise.Cents(2000),
+		Promotion:   "ANY3",
+		Units:       map[string]int64{merchandise.TSHIRT: 1, merchandise.MUG: 2},
+	}
+	if !reflect.DeepEqual(discounts[0], expected) {
+		t.Errorf("wrong discount expected %v got %v", expected, discounts[0])
+	}
+	discounts, _ = anyThree.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 2}))
+	if len(discounts) != 0 {
+		t.Errorf("MixAndMatch should need 3 units")
+	}
+}
+
+func TestCrossProduct(t *testing.T) {
+	shirtGetsPen := CrossProduct{ID: "SHIRT_PEN", BuyCode: merchandise.TSHIRT, BuyQuantity: 1, GetCode: merchandise.PEN, GetQuantity: 1, DiscountPercentage: 100}
+	// 2 shirts but only 1 pen
+	discounts, err := shirtGetsPen.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 2, merchandise.PEN: 1}))
+	if err != nil {
+		t.Errorf("CrossProduct returned an error %s", err.Error())
+		return
+	}
+	expected := Discount{
+		Description: "Buy 1 Lana T-Shirt get 1 Lana Pen Free",
+		Amount:      merchandise.Cents(500),
+		Promotion:   "SHIRT_PEN",
+		Units:       map[string]int64{merchandise.TSHIRT: 1, merchandise.PEN: 1},
+	}
+	if len(discounts) != 1 || !reflect.DeepEqual(discounts[0], expected) {
+		t.Errorf("wrong discount expected %v got %v", expected, discounts)
+		return
+	}
+	half := shirtGetsPen
+	half.DiscountPercentage = 50
+	discounts, _ = half.Apply(basketItems(map[string]int64{merchandise.TSHIRT: 1, merchandise.PEN: 3}))
+	if len(discounts) != 1 || discounts[0].Amount != merchandise.Cents(250) || discounts[0].Description != "Buy 1 Lana T-Shirt get 50% off 1 Lana Pen" {
+		t.Errorf("wrong discount %v", discounts)
+		return
+	}
+	discounts, _ = shirtGetsPen.Apply(basketItems(map[string]int64{merchandise.PEN: 3}))
+	if len(discounts) != 0 {
+		t.Errorf("CrossProduct should need the bought product")
+	}
+}
+
+func TestCrossProductCompetesForUnits(t *testing.T) {
+	shirtGetsPen := CrossProduct{ID: "SHIRT_PEN", BuyCode: merchandise.TSHIRT, BuyQuantity: 1, GetCode: merchandise.PEN, GetQuantity: 1, DiscountPercentage: 100}
+	// 3 shirts and 2 pens: free pen takes one shirt and one pen, the 2 shirts
+	// left are not enough for 25% off and the pen left is not enough for 2x1
+	items := basketItems(map[string]int64{merchandise.TSHIRT: 3, merchandise.PEN: 2})
+	receipt, err := computeReceipt(items, []Promotion{PenBuy2Get1, TshirtBuy3Get25OFF, shirtGetsPen})
+	if err != nil {
+		t.Errorf("computeReceipt returned an error %s", err.Error())
+		return
+	}
+	// best is 25% off the shirts (15.00) and 2x1 on pens (5.00)
+	if receipt.DiscountTotal != merchandise.Cents(2000) {
+		t.Errorf("wrong discount total expected 20.00 EUR got %s (%v)", receipt.DiscountTotal, discountIDs(receipt.Discounts))
+	}
+}
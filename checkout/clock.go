@@ -0,0 +1,49 @@
+package checkout
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock - time source used to decide which promotions and coupons are active and
+// when baskets expire
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock - Clock that returns the current time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+// Now - current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock - Clock frozen at a moment (i.e. for tests or to preview promotions)
+type FixedClock time.Time
+
+// Now - the frozen moment
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// Mutex to syncronize access to the clock
+var clockLock = sync.RWMutex{}
+
+// clock used to evaluate promotions and coupons
+var clock = SystemClock
+
+// SetClock - replace the clock used to evaluate promotions and coupons
+func SetClock(c Clock) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	clock = c
+}
+
+func now() time.Time {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock.Now()
+}
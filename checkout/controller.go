@@ -1,52 +1,194 @@
 package checkout
 
 import (
-	// "fmt"
+	"errors"
+	"fmt"
 	"github.com/gato/lana/merchandise"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func abort(c *gin.Context, err error) {
 	status := http.StatusInternalServerError
+	var invalid InvalidPromotionError
+	if errors.As(err, &invalid) {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var payment PaymentError
+	if errors.As(err, &payment) {
+		http.Error(c.Writer, err.Error(), http.StatusBadGateway)
+		return
+	}
 	// TODO canonalize errors
-	if err.Error() == "Basket not found" {
+	switch err.Error() {
+	case ErrBasketNotFound.Error(), ErrOrderNotFound.Error(), "Product not in basket", ErrCustomerNotFound.Error(),
+		ErrCouponNotFound.Error(), ErrCouponNotApplied.Error(), ErrPromotionNotFound.Error():
 		status = http.StatusNotFound
+	case "Invalid count", "Invalid product", "Invalid ttl", "Invalid limit", "Invalid cursor", "Invalid email",
+		"Invalid merge rule", "Too many promotions",
+		"Basket is empty", "Card number is required",
+		ErrCouponExpired.Error(), ErrCouponExhausted.Error():
+		status = http.StatusBadRequest
+	case "Basket is checked out", ErrProductUnavailable.Error(), ErrCouponAlreadyApplied.Error(), ErrPromotionExists.Error(), ErrCustomerExists.Error(),
+		ErrIdempotencyKeyReused.Error(), ErrIdempotencyKeyInUse.Error():
+		status = http.StatusConflict
+	case ErrInvalidCredentials.Error(), ErrAuthenticationRequired.Error():
+		c.Header("WWW-Authenticate", "Bearer")
+		status = http.StatusUnauthorized
+	case ErrAdminRequired.Error():
+		status = http.StatusForbidden
+	case ErrBasketModified.Error():
+		status = http.StatusPreconditionFailed
+	case ErrPaymentDeclined.Error():
+		status = http.StatusPaymentRequired
+	case ErrPaymentTimeout.Error():
+		status = http.StatusGatewayTimeout
 	}
 	http.Error(c.Writer, err.Error(), status)
 }
 
-// HandleGetByID - http handler for getting a Basket by Id
+// quoted basket version
+func basketETag(version int64) string {
+	return "\"" + strconv.FormatInt(version, 10) + "\""
+}
+
+// versions listed in the If-Match header, ok is false when there is no precondition
+// (no header or *). Weak and invalid tags are ignored as they never match
+func ifMatch(c *gin.Context) (versions []int64, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, false
+	}
+	versions = make([]int64, 0)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	return versions, true
+}
+
+// basket read by a request, baskets of others are not found
+func basketFor(c *gin.Context, id string) (Basket, error) {
+	if err := AccessBasket(callerOf(c), id); err != nil {
+		return nil, err
+	}
+	return GetBasket(id)
+}
+
+// basket to be changed by a request, honoring its If-Match header
+func basketToChange(c *gin.Context, id string) (Basket, error) {
+	if err := AccessBasket(callerOf(c), id); err != nil {
+		return nil, err
+	}
+	if versions, ok := ifMatch(c); ok {
+		return GetBasketIfMatch(id, versions)
+	}
+	return GetBasket(id)
+}
+
+// ETag header with the basket version
+func setBasketETag(c *gin.Context, b Basket) {
+	if version, err := b.GetVersion(); err == nil {
+		c.Header("ETag", basketETag(version))
+	}
+}
+
+// HandleGetByID - http handler for getting a Basket by Id, the ETag header carries its version
+// the basket is read once so the body and the ETag describe the same version
 func HandleGetByID(c *gin.Context, id string) {
-	b, err := GetBasket(id)
+	basket, err := readBasket(callerOf(c), id)
 	if err != nil {
 		abort(c, err)
 		return
 	}
-	// TODO handle error
-	_items, _ := b.GetItems()
-	amount, _ := b.GetTotal()
+	// baskets holding unavailable products are still shown, without amount
+	receipt, err := basket.receipt()
+	if err != nil && err != ErrProductUnavailable {
+		abort(c, err)
+		return
+	}
+	promotions := basket.promotionsInfo()
+	times := basket.times()
+	c.Header("ETag", basketETag(basket.Version))
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":     b.GetID(),
-		"items":  _items,
-		"amount": amount,
-	})
+	body := gin.H{
+		"id":                 basket.ID,
+		"items":              basket.getItems(),
+		"promotion_policy":   promotions.Policy,
+		"promotions_version": promotions.Version,
+		"created_at":         times.CreatedAt,
+		"updated_at":         times.UpdatedAt,
+	}
+	if err == nil {
+		body["amount"] = receipt.Total
+	}
+	if basket.Owner != "" {
+		body["owner"] = basket.Owner
+	}
+	if times.ExpiresAt != nil {
+		body["expires_at"] = times.ExpiresAt
+	}
+	if len(basket.Coupons) > 0 {
+		body["coupons"] = basket.Coupons
+	}
+	if len(receipt.Discounts) > 0 {
+		body["discounts"] = receipt.Discounts
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// HandleGetReceipt - http handler for getting the itemized receipt of a Basket
+func HandleGetReceipt(c *gin.Context, id string) {
+	b, err := basketFor(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	receipt, err := b.GetReceipt()
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
 }
 
-// HandleCreateEmtpyBasket - http handler for creating a new basket
-func HandleCreateEmtpyBasket(c *gin.Context) {
-	b := NewBasket()
+// HandleCreateEmtpyBasket - http handler for creating a new basket, owned by the caller when
+// it is a customer
+func HandleCreateEmtpyBasket(c *gin.Context, options BasketOptions) {
+	options.Owner = callerOf(c).CustomerID
+	b, err := NewBasketWithOptions(options)
+	if err != nil {
+		abort(c, err)
+		return
+	}
 	id := b.GetID()
 	// TODO: build using url tools
 	location := c.Request.Host + c.Request.RequestURI + id
 	c.Header("Location", location)
+	setBasketETag(c, b)
 	c.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
-// HandleDeleteBasket - http handler to delete a basket
+// HandleDeleteBasket - http handler to delete a basket, honoring If-Match
 func HandleDeleteBasket(c *gin.Context, id string) {
-	err := DeleteBasket(id)
+	err := AccessBasket(callerOf(c), id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if versions, ok := ifMatch(c); ok {
+		err = DeleteBasketIfMatch(id, versions)
+	} else {
+		err = DeleteBasket(id)
+	}
 	if err != nil {
 		abort(c, err)
 		return
@@ -54,36 +196,33 @@ func HandleDeleteBasket(c *gin.Context, id string) {
 	c.JSON(http.StatusNoContent, nil)
 }
 
-// HandleGetAllBaskets - return all baskets in server
-// no pagination so use with caution!
-func HandleGetAllBaskets(c *gin.Context) {
-	baskets := ListBaskets()
-	ids := make([]string, len(baskets))
-	for i, v := range baskets {
-		ids[i] = v.GetID()
+// HandleGetAllBaskets - return a page of the baskets matching query sorted by creation time
+// customers only get their baskets and admins every basket
+func HandleGetAllBaskets(c *gin.Context, query BasketQuery) {
+	page, err := QueryBasketsFor(callerOf(c), query)
+	if err != nil {
+		abort(c, err)
+		return
 	}
-	c.JSON(http.StatusOK, ids)
+	c.JSON(http.StatusOK, page)
 }
 
-// HandleAddProduct - http handler to delete a basket
+// HandleAddProduct - http handler to add products to a basket
 func HandleAddProduct(c *gin.Context, id string, _item ProductItem) {
 	// Validate product
 	if !merchandise.IsValidProduct(_item.Product) {
 		http.Error(c.Writer, "Invalid product", http.StatusBadRequest)
 		return
 	}
-	b, err := GetBasket(id)
+	b, err := basketToChange(c, id)
 	if err != nil {
 		abort(c, err)
 		return
 	}
 	count, err := b.AddItem(_item)
 	if err != nil {
-		// This error is extremely weird and can't be easily tested
-		// basically the only way AddItem will fail is if basket do not exists
-		// but that is also checked in GetBasket
-		// only way this could happen is if someone deletes the basket between
-		// getting the basket and addItem
+		// invalid or too big counts, checked out baskets, version mismatches (If-Match)
+		// and baskets removed since they were looked up
 		abort(c, err)
 		return
 	}
@@ -92,5 +231,309 @@ func HandleAddProduct(c *gin.Context, id string, _item ProductItem) {
 		// item was already present return OK insted of created
 		status = http.StatusOK
 	}
+	setBasketETag(c, b)
 	c.JSON(status, gin.H{"count": count})
 }
+
+// HandleSetQuantity - http handler to set the absolute count of a product in a basket
+func HandleSetQuantity(c *gin.Context, id string, _item ProductItem) {
+	// Validate product
+	if !merchandise.IsValidProduct(_item.Product) {
+		http.Error(c.Writer, "Invalid product", http.StatusBadRequest)
+		return
+	}
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	count, err := b.SetQuantity(_item)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	setBasketETag(c, b)
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// HandleRemoveProduct - http handler to remove a product line from a basket
+func HandleRemoveProduct(c *gin.Context, id string, product string) {
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	err = b.RemoveItem(product)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	setBasketETag(c, b)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// HandleClearBasket - http handler to remove all items from a basket
+func HandleClearBasket(c *gin.Context, id string) {
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	err = b.Clear()
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	setBasketETag(c, b)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// HandleCheckout - http handler to convert a basket into an order
+// when a card is given the order is charged right away, if the payment fails the
+// order is still created (see Location header) and can be paid later
+func HandleCheckout(c *gin.Context, id string, card *PaymentCard) {
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	order, err := b.Checkout()
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	// TODO: build using url tools
+	c.Header("Location", c.Request.Host+"/api/v1/orders/"+order.ID)
+	if card != nil {
+		order, err = PayOrder(order.ID, *card)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusCreated, order)
+}
+
+// HandleApplyCoupon - http handler to attach a coupon to a basket
+// rejected codes return the reason (not found, expired, usage limit reached or already applied)
+func HandleApplyCoupon(c *gin.Context, id string, coupon CouponCode) {
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	applied, err := b.ApplyCoupon(coupon.Code)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	setBasketETag(c, b)
+	c.JSON(http.StatusCreated, gin.H{"coupons": applied})
+}
+
+// HandleRemoveCoupon - http handler to detach a coupon from a basket
+func HandleRemoveCoupon(c *gin.Context, id string, coupon CouponCode) {
+	b, err := basketToChange(c, id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	remaining, err := b.RemoveCoupon(coupon.Code)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	setBasketETag(c, b)
+	c.JSON(http.StatusOK, gin.H{"coupons": remaining})
+}
+
+// HandleGetOrder - http handler for getting an order by Id
+func HandleGetOrder(c *gin.Context, id string) {
+	order, err := GetOrder(id)
+	if err == nil && !callerOf(c).CanAccessOrder(order) {
+		err = ErrOrderNotFound
+	}
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// HandleGetAllOrders - return the orders of the caller (every order for admins) sorted by
+// creation time, no pagination so use with caution!
+func HandleGetAllOrders(c *gin.Context) {
+	list, err := ListOrdersFor(callerOf(c))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// order operations used by HandleOrderTransition by target state
+// payments need a card so they have their own handler
+var orderActions = map[OrderState]func(id string) (Order, error){
+	Fulfilled: FulfillOrder,
+	Cancelled: CancelOrder,
+	Refunded:  RefundOrder,
+}
+
+// HandleOrderTransition - http handler to move an order to a new state
+// transitions not allowed from current state return a 409 describing both states. Only states
+// with an action can be reached, orders are paid through HandlePayOrder
+func HandleOrderTransition(c *gin.Context, id string, to OrderState) {
+	action, ok := orderActions[to]
+	if !ok {
+		abort(c, fmt.Errorf("No action moves orders to %s", to))
+		return
+	}
+	if err := AccessOrder(callerOf(c), id); err != nil {
+		abort(c, err)
+		return
+	}
+	order, err := action(id)
+	respondOrder(c, order, err)
+}
+
+// HandlePayOrder - http handler to charge an order pending payment
+// declined payments return a 402 and provider timeouts a 504, both are recorded in the order
+func HandlePayOrder(c *gin.Context, id string, card PaymentCard) {
+	if err := AccessOrder(callerOf(c), id); err != nil {
+		abort(c, err)
+		return
+	}
+	order, err := PayOrder(id, card)
+	respondOrder(c, order, err)
+}
+
+func respondOrder(c *gin.Context, order Order, err error) {
+	var transitionErr TransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": transitionErr.Error(),
+			"from":  transitionErr.From,
+			"to":    transitionErr.To,
+		})
+		return
+	}
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// HandleGetAllPromotions - http handler listing every managed promotion, enabled or not
+func HandleGetAllPromotions(c *gin.Context) {
+	c.JSON(http.StatusOK, ListPromotions())
+}
+
+// HandleGetPromotion - http handler for getting a promotion by id
+func HandleGetPromotion(c *gin.Context, id string) {
+	promotion, err := GetPromotion(id)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, promotion)
+}
+
+// HandleCreatePromotion - http handler for adding a promotion, invalid rules return a 400
+func HandleCreatePromotion(c *gin.Context, promotion ManagedPromotion) {
+	created, err := CreatePromotion(promotion)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	// TODO: build using url tools
+	location := c.Request.Host + c.Request.RequestURI + created.ID
+	c.Header("Location", location)
+	c.JSON(http.StatusCreated, created)
+}
+
+// HandleUpdatePromotion - http handler for replacing the rule of a promotion
+func HandleUpdatePromotion(c *gin.Context, id string, promotion ManagedPromotion) {
+	updated, err := UpdatePromotion(id, promotion)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// HandleDeletePromotion - http handler for removing a promotion
+func HandleDeletePromotion(c *gin.Context, id string) {
+	if err := DeletePromotion(id); err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// HandleTogglePromotion - http handler to enable or disable a promotion
+func HandleTogglePromotion(c *gin.Context, id string, toggle PromotionToggle) {
+	if toggle.Enabled == nil {
+		abort(c, InvalidPromotionError{Reason: "enabled is required"})
+		return
+	}
+	promotion, err := EnablePromotion(id, *toggle.Enabled)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, promotion)
+}
+
+// HandleSimulatePromotions - http handler to preview the receipt of hypothetical basket contents
+// with a set of promotions, no basket is created
+func HandleSimulatePromotions(c *gin.Context, simulation Simulation) {
+	receipt, err := Simulate(simulation)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// HandleRegisterCustomer - http handler to create a customer, the response carries the token
+// to authenticate it that is not given again
+func HandleRegisterCustomer(c *gin.Context, registration CustomerRegistration) {
+	credentials, err := RegisterCustomer(registration)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, credentials)
+}
+
+// HandleGetCurrentCustomer - http handler for getting the customer making the request
+func HandleGetCurrentCustomer(c *gin.Context) {
+	caller := callerOf(c)
+	if caller.CustomerID == "" {
+		abort(c, ErrAuthenticationRequired)
+		return
+	}
+	customer, err := GetCustomer(caller.CustomerID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, customer)
+}
+
+// HandleLogin - http handler for a customer logging in, merging the anonymous basket it was
+// using into its basket
+func HandleLogin(c *gin.Context, login Login) {
+	caller := callerOf(c)
+	if caller.CustomerID == "" {
+		abort(c, ErrAuthenticationRequired)
+		return
+	}
+	result, err := LoginCustomer(caller.CustomerID, login)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
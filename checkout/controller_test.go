@@ -1,12 +1,15 @@
 package checkout
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/gato/lana/merchandise"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func getRouter() *gin.Engine {
@@ -17,7 +20,9 @@ func getRouter() *gin.Engine {
 }
 
 func TestHandleGetByID(t *testing.T) {
-	basket := NewBasket()
+	defer SetClock(SystemClock)
+	SetClock(FixedClock(time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)))
+	basket, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/basket/"+basket.GetID(), nil)
@@ -28,11 +33,16 @@ func TestHandleGetByID(t *testing.T) {
 		t.Errorf("HandleGetByID wrong http status expected %d got %d", expected, w.Code)
 		return
 	}
-	expectedBody := fmt.Sprintf("{\"amount\":0,\"id\":\"%s\",\"items\":[]}", basket.GetID())
+	expectedBody := fmt.Sprintf("{\"amount\":{\"minor_units\":0,\"currency\":\"EUR\",\"formatted\":\"0.00 EUR\"},"+
+		"\"created_at\":\"2020-11-20T10:00:00Z\",\"id\":\"%s\",\"items\":[],"+
+		"\"promotion_policy\":\"pinned\",\"promotions_version\":%d,\"updated_at\":\"2020-11-20T10:00:00Z\"}", basket.GetID(), PromotionsVersion())
 	if w.Body.String() != expectedBody {
 		t.Errorf("HandleGetByID wrong response body expected %s got %s", expectedBody, w.Body.String())
 		return
 	}
+	if etag := w.Header().Get("ETag"); etag != "\"1\"" {
+		t.Errorf("HandleGetByID wrong ETag expected \"1\" got %s", etag)
+	}
 }
 func TestHandleGetByIDNotFound(t *testing.T) {
 	r := getRouter()
@@ -54,7 +64,7 @@ func TestHandleGetByIDNotFound(t *testing.T) {
 
 func TestHandleCreateEmtpyBasket(t *testing.T) {
 	r := getRouter()
-	basketMap = make(map[string]basket)
+	SetBasketStore(NewMemoryBasketStore())
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/v1/basket/", nil)
 	r.ServeHTTP(w, req)
@@ -65,7 +75,7 @@ func TestHandleCreateEmtpyBasket(t *testing.T) {
 		return
 	}
 
-	baskets := ListBaskets()
+	baskets, _ := ListBaskets()
 	if len(baskets) != 1 {
 		t.Errorf("HandleCreateEmtpyBasket haven't created a basket")
 		return
@@ -79,7 +89,7 @@ func TestHandleCreateEmtpyBasket(t *testing.T) {
 }
 
 func TestHandleDeleteBasket(t *testing.T) {
-	basket := NewBasket()
+	basket, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/api/v1/basket/"+basket.GetID(), nil)
@@ -111,7 +121,7 @@ func TestHandleDeleteBasketNotFound(t *testing.T) {
 }
 
 func TestHandleAddProduct(t *testing.T) {
-	basket := NewBasket()
+	basket, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader("{\"product\":\"MUG\",\"count\":1}"))
@@ -144,7 +154,7 @@ func TestHandleAddProduct(t *testing.T) {
 }
 
 func TestHandleAddProductErrorInvalidProduct(t *testing.T) {
-	basket := NewBasket()
+	basket, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader("{\"product\":\"Rocket Fuel\",\"count\":1}"))
@@ -181,7 +191,7 @@ func TestHandleAddProductErrorBasketNotFound(t *testing.T) {
 }
 
 func TestHandleAddProductErrorBadPayload(t *testing.T) {
-	basket := NewBasket()
+	basket, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader("{\"lala\":\"lolo\""))
@@ -196,11 +206,14 @@ func TestHandleAddProductErrorBadPayload(t *testing.T) {
 
 func TestHandleGetAllBaskets(t *testing.T) {
 
-	basketMap = make(map[string]basket)
-	b1 := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	b1, _ := NewBasket()
 	r := getRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/basket/", nil)
+	req.Header.Set("Authorization", "Bearer admin")
 	r.ServeHTTP(w, req)
 
 	expected := http.StatusOK
@@ -208,9 +221,813 @@ func TestHandleGetAllBaskets(t *testing.T) {
 		t.Errorf("HandleGetAllBaskets wrong http status expected %d got %d", expected, w.Code)
 		return
 	}
-	expectedBody := "[\"" + b1.GetID() + "\"]"
+	times, _ := b1.GetTimes()
+	createdAt, _ := times.CreatedAt.MarshalJSON()
+	expectedBody := fmt.Sprintf("{\"baskets\":[{\"id\":\"%s\",\"created_at\":%s}]}", b1.GetID(), createdAt)
 	if w.Body.String() != expectedBody {
-		t.Errorf("HandleDeleteBasket wrong response body expected %s got %s", expectedBody, w.Body.String())
+		t.Errorf("HandleGetAllBaskets wrong response body expected %s got %s", expectedBody, w.Body.String())
+		return
+	}
+}
+
+func TestHandleGetAllBasketsQuery(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetAdminToken("admin")
+	defer SetAdminToken("")
+	b1, _ := NewBasket()
+	_, _ = b1.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	_, _ = NewBasket()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/?empty=false&summary=true", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("HandleGetAllBaskets wrong http status expected %d got %d", http.StatusOK, w.Code)
+		return
+	}
+	total, _ := b1.GetTotal()
+	var page BasketPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Errorf("HandleGetAllBaskets invalid response body %s", w.Body.String())
+		return
+	}
+	if len(page.Baskets) != 1 || page.Baskets[0].ID != b1.GetID() || page.Baskets[0].ItemCount == nil ||
+		*page.Baskets[0].ItemCount != 2 || *page.Baskets[0].Total != total {
+		t.Errorf("HandleGetAllBaskets wrong page %s", w.Body.String())
+		return
+	}
+	for _, query := range []string{"limit=-1", "limit=1000", "cursor=lala", "created_after=yesterday", "empty=maybe"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/basket/?"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("HandleGetAllBaskets %s wrong http status expected %d got %d", query, http.StatusBadRequest, w.Code)
+			return
+		}
+	}
+}
+
+func TestHandleAddProductErrorInvalidCount(t *testing.T) {
+	basket, _ := NewBasket()
+	r := getRouter()
+	for _, payload := range []string{"{\"product\":\"PEN\",\"count\":0}", "{\"product\":\"PEN\",\"count\":-2}"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader(payload))
+		r.ServeHTTP(w, req)
+
+		expected := http.StatusBadRequest
+		if w.Code != expected {
+			t.Errorf("HandleAddProduct wrong http status expected %d got %d", expected, w.Code)
+			return
+		}
+		expectedBody := "Invalid count\n"
+		if w.Body.String() != expectedBody {
+			t.Errorf("HandleAddProduct wrong response body expected %s got %s", expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleSetQuantity(t *testing.T) {
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 5})
+	r := getRouter()
+	for _, method := range []string{"PUT", "PATCH"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/api/v1/basket/"+basket.GetID()+"/items/PEN", strings.NewReader("{\"count\":3}"))
+		r.ServeHTTP(w, req)
+
+		expected := http.StatusOK
+		if w.Code != expected {
+			t.Errorf("HandleSetQuantity wrong http status expected %d got %d", expected, w.Code)
+			return
+		}
+		expectedBody := "{\"count\":3}"
+		if w.Body.String() != expectedBody {
+			t.Errorf("HandleSetQuantity wrong response body expected %s got %s", expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleSetQuantityErrors(t *testing.T) {
+	basket, _ := NewBasket()
+	r := getRouter()
+	cases := []struct {
+		url          string
+		payload      string
+		status       int
+		expectedBody string
+	}{
+		{"/api/v1/basket/" + basket.GetID() + "/items/PEN", "{\"count\":-1}", http.StatusBadRequest, "Invalid count\n"},
+		{"/api/v1/basket/" + basket.GetID() + "/items/FUEL", "{\"count\":1}", http.StatusBadRequest, "Invalid product\n"},
+		{"/api/v1/basket/1111/items/PEN", "{\"count\":1}", http.StatusNotFound, "Basket not found\n"},
+		{"/api/v1/basket/" + basket.GetID() + "/items/PEN", "{\"count\"", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", tc.url, strings.NewReader(tc.payload))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("HandleSetQuantity wrong http status expected %d got %d", tc.status, w.Code)
+			return
+		}
+		if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+			t.Errorf("HandleSetQuantity wrong response body expected %s got %s", tc.expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleRemoveProduct(t *testing.T) {
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 5})
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/basket/"+basket.GetID()+"/items/PEN", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusNoContent
+	if w.Code != expected {
+		t.Errorf("HandleRemoveProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	// second time product is no longer there
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/basket/"+basket.GetID()+"/items/PEN", nil)
+	r.ServeHTTP(w, req)
+
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleRemoveProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "Product not in basket\n"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleRemoveProduct wrong response body expected %s got %s", expectedBody, w.Body.String())
+		return
+	}
+}
+
+func TestHandleRemoveProductBasketNotFound(t *testing.T) {
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/basket/1111/items/PEN", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleRemoveProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+}
+
+func TestHandleClearBasket(t *testing.T) {
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 5})
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/basket/"+basket.GetID()+"/items", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusNoContent
+	if w.Code != expected {
+		t.Errorf("HandleClearBasket wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	items, _ := basket.GetItems()
+	if len(items) != 0 {
+		t.Errorf("HandleClearBasket basket should be empty got %d items", len(items))
+		return
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/basket/1111/items", nil)
+	r.ServeHTTP(w, req)
+
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleClearBasket wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+}
+
+func TestHandleGetReceipt(t *testing.T) {
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 2})
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/"+basket.GetID()+"/receipt", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusOK
+	if w.Code != expected {
+		t.Errorf("HandleGetReceipt wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "{\"lines\":[{\"product\":\"PEN\",\"name\":\"Lana Pen\"," +
+		"\"unit_price\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"},\"quantity\":2," +
+		"\"subtotal\":{\"minor_units\":1000,\"currency\":\"EUR\",\"formatted\":\"10.00 EUR\"}}]," +
+		"\"discounts\":[{\"description\":\"Buy 2 Lana Pen and get 1 Free\"," +
+		"\"amount\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"},\"promotion\":\"PEN_BUY2_GET1\",\"units\":{\"PEN\":2}}]," +
+		"\"subtotal\":{\"minor_units\":1000,\"currency\":\"EUR\",\"formatted\":\"10.00 EUR\"}," +
+		"\"discount_total\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}," +
+		"\"total\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleGetReceipt wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandleGetByIDUnavailableProduct(t *testing.T) {
+	if err := merchandise.CreateProduct(merchandise.Product{Code: "HAT", Name: "Lana Hat", Price: merchandise.Cents(900)}); err != nil {
+		t.Errorf("CreateProduct returned an error %s", err.Error())
+		return
+	}
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "HAT", Count: 1})
+	_ = merchandise.DeleteProduct("HAT")
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/"+basket.GetID(), nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("HandleGetByID wrong http status expected %d got %d", http.StatusOK, w.Code)
+		return
+	}
+	if body := w.Body.String(); !strings.Contains(body, "{\"product\":\"HAT\",\"count\":1,\"unavailable\":true}") || strings.Contains(body, "\"amount\"") {
+		t.Errorf("HandleGetByID wrong response body %s", body)
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/basket/"+basket.GetID()+"/receipt", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("HandleGetReceipt wrong http status expected %d got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestHandleGetReceiptNotFound(t *testing.T) {
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/123/receipt", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleGetReceipt wrong http status expected %d got %d", expected, w.Code)
+	}
+}
+
+func TestHandleCheckout(t *testing.T) {
+	SetOrderStore(NewMemoryOrderStore())
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 2})
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID()+"/checkout", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusCreated
+	if w.Code != expected {
+		t.Errorf("HandleCheckout wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	list, _ := ListOrders()
+	if len(list) != 1 {
+		t.Errorf("HandleCheckout haven't created an order")
+		return
+	}
+	if !strings.HasSuffix(w.Header().Get("Location"), "/api/v1/orders/"+list[0].ID) {
+		t.Errorf("HandleCheckout wrong location %s", w.Header().Get("Location"))
+	}
+
+	// basket is locked now
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader("{\"product\":\"MUG\",\"count\":1}"))
+	r.ServeHTTP(w, req)
+	expected = http.StatusConflict
+	if w.Code != expected {
+		t.Errorf("HandleAddProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "Basket is checked out\n"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleAddProduct wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandleCheckoutErrors(t *testing.T) {
+	basket, _ := NewBasket()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID()+"/checkout", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusBadRequest
+	if w.Code != expected {
+		t.Errorf("HandleCheckout wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/basket/1111/checkout", nil)
+	r.ServeHTTP(w, req)
+
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleCheckout wrong http status expected %d got %d", expected, w.Code)
+	}
+}
+
+func TestHandleGetOrders(t *testing.T) {
+	SetOrderStore(NewMemoryOrderStore())
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "MUG", Count: 1})
+	order, _ := basket.Checkout()
+	r := getRouter()
+
+	// only admins list every order
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/orders/", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("HandleGetAllOrders wrong http status expected %d got %d", http.StatusUnauthorized, w.Code)
+		return
+	}
+	defer SetAdminToken("")
+	SetAdminToken("secret")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/orders/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	expected := http.StatusOK
+	if w.Code != expected {
+		t.Errorf("HandleGetAllOrders wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	if !strings.Contains(w.Body.String(), "\"id\":\""+order.ID+"\"") {
+		t.Errorf("HandleGetAllOrders order missing in %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/orders/"+order.ID, nil)
+	r.ServeHTTP(w, req)
+	if w.Code != expected {
+		t.Errorf("HandleGetOrder wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	if !strings.Contains(w.Body.String(), "\"basket_id\":\""+basket.GetID()+"\"") {
+		t.Errorf("HandleGetOrder wrong order %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/orders/1234", nil)
+	r.ServeHTTP(w, req)
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleGetOrder wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "Order not found\n"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleGetOrder wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandleOrderTransition(t *testing.T) {
+	defer SetAdminToken("")
+	SetAdminToken("admin")
+	order := newTestOrder()
+	r := getRouter()
+	bodies := map[string]string{"pay": "{\"card_number\":\"4242424242424242\"}"}
+	for _, action := range []string{"pay", "fulfill", "refund"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/orders/"+order.ID+"/"+action, strings.NewReader(bodies[action]))
+		req.Header.Set("Authorization", "Bearer admin")
+		r.ServeHTTP(w, req)
+
+		expected := http.StatusOK
+		if w.Code != expected {
+			t.Errorf("HandleOrderTransition %s wrong http status expected %d got %d", action, expected, w.Code)
+			return
+		}
+	}
+	stored, _ := GetOrder(order.ID)
+	if stored.State != Refunded {
+		t.Errorf("wrong state expected %s got %s", Refunded, stored.State)
+	}
+}
+
+func TestHandleOrderTransitionWithoutAction(t *testing.T) {
+	order := newTestOrder()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	HandleOrderTransition(c, order.ID, Paid)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("HandleOrderTransition wrong http status expected %d got %d", http.StatusInternalServerError, w.Code)
+		return
+	}
+	if stored, _ := GetOrder(order.ID); stored.State != PendingPayment {
+		t.Errorf("order should not be paid got %s", stored.State)
+	}
+}
+
+func TestHandleOrderTransitionConflict(t *testing.T) {
+	defer SetAdminToken("")
+	SetAdminToken("admin")
+	order := newTestOrder()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/orders/"+order.ID+"/refund", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusConflict
+	if w.Code != expected {
+		t.Errorf("HandleOrderTransition wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "{\"error\":\"Order can't go from pending_payment to refunded\",\"from\":\"pending_payment\",\"to\":\"refunded\"}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleOrderTransition wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/orders/1234/cancel", nil)
+	r.ServeHTTP(w, req)
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleOrderTransition wrong http status expected %d got %d", expected, w.Code)
+	}
+}
+
+func TestHandlePayOrderFailures(t *testing.T) {
+	order := newTestOrder()
+	r := getRouter()
+	cases := []struct {
+		body     string
+		expected int
+	}{
+		{"{\"card_number\":\"" + MockCardDeclined + "\"}", http.StatusPaymentRequired},
+		{"{\"card_number\":\"" + MockCardTimeout + "\"}", http.StatusGatewayTimeout},
+		{"{}", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/orders/"+order.ID+"/pay", strings.NewReader(tc.body))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("HandlePayOrder with %s wrong http status expected %d got %d", tc.body, tc.expected, w.Code)
+		}
+	}
+	stored, _ := GetOrder(order.ID)
+	if stored.State != PendingPayment || len(stored.Payments) != 2 {
+		t.Errorf("failed payments should be recorded state %s payments %v", stored.State, stored.Payments)
+		return
+	}
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(invalidRequestProvider{NewMockPaymentGateway()})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/orders/"+order.ID+"/pay", strings.NewReader("{\"card_number\":\""+MockCardApproved+"\"}"))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("HandlePayOrder wrong http status expected %d got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestHandleCheckoutWithCard(t *testing.T) {
+	SetOrderStore(NewMemoryOrderStore())
+	r := getRouter()
+	cases := []struct {
+		card     string
+		expected int
+		state    OrderState
+	}{
+		{MockCardApproved, http.StatusCreated, Paid},
+		{MockCardDeclined, http.StatusPaymentRequired, PendingPayment},
+	}
+	for _, tc := range cases {
+		basket, _ := NewBasket()
+		_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 1})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID()+"/checkout", strings.NewReader("{\"card_number\":\""+tc.card+"\"}"))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("HandleCheckout wrong http status expected %d got %d", tc.expected, w.Code)
+			return
+		}
+		// order is created even if payment fails
+		data, _ := store.Get(basket.GetID())
+		order, err := GetOrder(data.OrderID)
+		if err != nil || order.State != tc.state {
+			t.Errorf("wrong order state expected %s got %s (%v)", tc.state, order.State, err)
+			return
+		}
+		if !strings.HasSuffix(w.Header().Get("Location"), "/api/v1/orders/"+order.ID) {
+			t.Errorf("HandleCheckout wrong location %s", w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHandleCoupons(t *testing.T) {
+	defer resetCoupons(mugCoupon)()
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "MUG", Count: 2})
+	r := getRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/basket/"+basket.GetID()+"/coupons", strings.NewReader("{\"code\":\"mugs4less\"}"))
+	r.ServeHTTP(w, req)
+	expected := http.StatusCreated
+	if w.Code != expected {
+		t.Errorf("HandleApplyCoupon wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "{\"coupons\":[\"MUGS4LESS\"]}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleApplyCoupon wrong response body expected %s got %s", expectedBody, w.Body.String())
+		return
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/basket/"+basket.GetID(), nil)
+	r.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "\"coupons\":[\"MUGS4LESS\"]") || !strings.Contains(w.Body.String(), "\"minor_units\":750") {
+		t.Errorf("HandleGetByID should include coupon and its discount got %s", w.Body.String())
+		return
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/basket/"+basket.GetID()+"/coupons", strings.NewReader("{\"code\":\"MUGS4LESS\"}"))
+	r.ServeHTTP(w, req)
+	expected = http.StatusOK
+	if w.Code != expected {
+		t.Errorf("HandleRemoveCoupon wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody = "{\"coupons\":[]}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleRemoveCoupon wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandleCouponsRejected(t *testing.T) {
+	expired := mugCoupon
+	expired.Code = "OLD"
+	expired.ExpiresAt = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer resetCoupons(mugCoupon, expired)()
+	basket, _ := NewBasket()
+	_, _ = basket.ApplyCoupon(mugCoupon.Code)
+	r := getRouter()
+	cases := []struct {
+		method   string
+		body     string
+		expected int
+		message  string
+	}{
+		{"POST", "{\"code\":\"NOPE\"}", http.StatusNotFound, "Coupon not found\n"},
+		{"POST", "{\"code\":\"OLD\"}", http.StatusBadRequest, "Coupon has expired\n"},
+		{"POST", "{\"code\":\"MUGS4LESS\"}", http.StatusConflict, "Coupon already applied\n"},
+		{"DELETE", "{\"code\":\"OLD\"}", http.StatusNotFound, "Coupon not in basket\n"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, "/api/v1/basket/"+basket.GetID()+"/coupons", strings.NewReader(tc.body))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.expected || w.Body.String() != tc.message {
+			t.Errorf("%s coupon %s expected %d %s got %d %s", tc.method, tc.body, tc.expected, tc.message, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleGetByIDDiscounts(t *testing.T) {
+	defer resetPromotions(PromotionRule{ID: "SPEND30", Type: SpendThresholdType, Threshold: 3000, AmountOff: 500})()
+	defer SetClock(SystemClock)
+	SetClock(FixedClock(time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)))
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "TSHIRT", Count: 2})
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/"+basket.GetID(), nil)
+	r.ServeHTTP(w, req)
+
+	expectedBody := fmt.Sprintf("{\"amount\":{\"minor_units\":3500,\"currency\":\"EUR\",\"formatted\":\"35.00 EUR\"},"+
+		"\"created_at\":\"2020-11-20T10:00:00Z\",\"discounts\":[{\"description\":\"5.00 EUR off when you spend 30.00 EUR\","+
+		"\"amount\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"},\"promotion\":\"SPEND30\"}],"+
+		"\"id\":\"%s\",\"items\":[{\"product\":\"TSHIRT\",\"count\":2}],"+
+		"\"promotion_policy\":\"pinned\",\"promotions_version\":%d,\"updated_at\":\"2020-11-20T10:00:00Z\"}", basket.GetID(), PromotionsVersion())
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleGetByID wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandlePromotions(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	defer resetCustomers()()
+	defer SetAdminToken("")
+	SetAdminToken("admin")
+	customer := mustRegisterCustomer(t, "alice@example.com")
+	r := getRouter()
+	// only admins change promotions
+	for token, status := range map[string]int{"": http.StatusUnauthorized, customer.Token: http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/promotions/", strings.NewReader("{\"type\":\"bulk_percentage_discount\",\"code\":\"MUG\",\"buy_quantity\":1,\"discount_percentage\":100}"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("HandleCreatePromotion wrong http status expected %d got %d", status, w.Code)
+			return
+		}
+	}
+	cases := []struct {
+		method       string
+		url          string
+		payload      string
+		status       int
+		expectedBody string
+	}{
+		{"GET", "/api/v1/promotions/PEN_BUY2_GET1", "", http.StatusOK,
+			"{\"id\":\"PEN_BUY2_GET1\",\"type\":\"buy_x_get_y\",\"code\":\"PEN\",\"buy_quantity\":2,\"get_free_quantity\":1,\"enabled\":true}"},
+		{"POST", "/api/v1/promotions/", "{\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":3,\"get_free_quantity\":1}", http.StatusCreated,
+			"{\"id\":\"buy_x_get_y:MUG\",\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":3,\"get_free_quantity\":1,\"enabled\":true}"},
+		{"POST", "/api/v1/promotions/", "{\"id\":\"buy_x_get_y:MUG\",\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":3,\"get_free_quantity\":1}", http.StatusConflict,
+			"Promotion already exists\n"},
+		{"POST", "/api/v1/promotions/", "{\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":1,\"get_free_quantity\":1}", http.StatusBadRequest,
+			"Invalid promotion: buy_quantity must be greater than get_free_quantity\n"},
+		{"PUT", "/api/v1/promotions/buy_x_get_y:MUG", "{\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":4,\"get_free_quantity\":1,\"enabled\":false}", http.StatusOK,
+			"{\"id\":\"buy_x_get_y:MUG\",\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":4,\"get_free_quantity\":1,\"enabled\":false}"},
+		{"PUT", "/api/v1/promotions/NOPE", "{\"type\":\"buy_x_get_y\",\"code\":\"MUG\",\"buy_quantity\":4,\"get_free_quantity\":1}", http.StatusNotFound,
+			"Promotion not found\n"},
+		{"PATCH", "/api/v1/promotions/PEN_BUY2_GET1", "{\"enabled\":false}", http.StatusOK,
+			"{\"id\":\"PEN_BUY2_GET1\",\"type\":\"buy_x_get_y\",\"code\":\"PEN\",\"buy_quantity\":2,\"get_free_quantity\":1,\"enabled\":false}"},
+		{"PATCH", "/api/v1/promotions/PEN_BUY2_GET1", "{}", http.StatusBadRequest,
+			"Invalid promotion: enabled is required\n"},
+		{"DELETE", "/api/v1/promotions/buy_x_get_y:MUG", "", http.StatusNoContent, ""},
+		{"DELETE", "/api/v1/promotions/buy_x_get_y:MUG", "", http.StatusNotFound, "Promotion not found\n"},
+		{"GET", "/api/v1/promotions/", "", http.StatusOK,
+			"[{\"id\":\"PEN_BUY2_GET1\",\"type\":\"buy_x_get_y\",\"code\":\"PEN\",\"buy_quantity\":2,\"get_free_quantity\":1,\"enabled\":false}," +
+				"{\"id\":\"TSHIRT_BUY3_GET25OFF\",\"type\":\"bulk_percentage_discount\",\"code\":\"TSHIRT\",\"buy_quantity\":3,\"discount_percentage\":25,\"enabled\":true}]"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.payload))
+		req.Header.Set("Authorization", "Bearer admin")
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s wrong http status expected %d got %d", tc.method, tc.url, tc.status, w.Code)
+			return
+		}
+		if w.Body.String() != tc.expectedBody {
+			t.Errorf("%s %s wrong response body expected %s got %s", tc.method, tc.url, tc.expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleSimulatePromotions(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	defer resetCustomers()()
+	defer SetAdminToken("")
+	SetAdminToken("admin")
+	customer := mustRegisterCustomer(t, "alice@example.com")
+	r := getRouter()
+	payload := "{\"items\":[{\"product\":\"PEN\",\"count\":2}],\"promotions\":[{\"id\":\"HALF\",\"type\":\"bulk_percentage_discount\",\"code\":\"PEN\",\"buy_quantity\":2,\"discount_percentage\":50}]}"
+	// only admins can simulate
+	for token, status := range map[string]int{"": http.StatusUnauthorized, customer.Token: http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/promotions/simulate", strings.NewReader(payload))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("HandleSimulatePromotions wrong http status expected %d got %d", status, w.Code)
+			return
+		}
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/promotions/simulate", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer admin")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("HandleSimulatePromotions wrong http status expected %d got %d", http.StatusOK, w.Code)
+		return
+	}
+	expectedBody := "{\"lines\":[{\"product\":\"PEN\",\"name\":\"Lana Pen\",\"unit_price\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}," +
+		"\"quantity\":2,\"subtotal\":{\"minor_units\":1000,\"currency\":\"EUR\",\"formatted\":\"10.00 EUR\"}}]," +
+		"\"discounts\":[{\"description\":\"Buy 2 or more Lana Pen get 50% off\",\"amount\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"},\"promotion\":\"HALF\",\"units\":{\"PEN\":2}}]," +
+		"\"subtotal\":{\"minor_units\":1000,\"currency\":\"EUR\",\"formatted\":\"10.00 EUR\"}," +
+		"\"discount_total\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}," +
+		"\"total\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleSimulatePromotions wrong response body expected %s got %s", expectedBody, w.Body.String())
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/promotions/simulate", strings.NewReader("{\"items\":[{\"product\":\"FUEL\",\"count\":2}]}"))
+	req.Header.Set("Authorization", "Bearer admin")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("HandleSimulatePromotions wrong http status expected %d got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleCreateBasketWithTTL(t *testing.T) {
+	defer SetClock(SystemClock)
+	SetClock(FixedClock(time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)))
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/basket/", strings.NewReader("{\"ttl_seconds\":3600}"))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("HandleCreateEmtpyBasket wrong http status expected %d got %d", http.StatusCreated, w.Code)
+		return
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/basket/"+created.ID, nil)
+	r.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "\"expires_at\":\"2020-11-20T11:00:00Z\"") {
+		t.Errorf("basket should expire in an hour got %s", w.Body.String())
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/basket/", strings.NewReader("{\"ttl_seconds\":-1}"))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || w.Body.String() != "Invalid ttl\n" {
+		t.Errorf("HandleCreateEmtpyBasket should reject negative ttls got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleBasketETags(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	basket, _ := NewBasket()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/"+basket.GetID(), nil)
+	r.ServeHTTP(w, req)
+	if etag := w.Header().Get("ETag"); etag != "\"1\"" {
+		t.Errorf("HandleGetByID wrong ETag expected \"1\" got %s", etag)
+		return
+	}
+	// first tab adds a product with the version it read
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/basket/"+basket.GetID(), strings.NewReader("{\"product\":\"PEN\",\"count\":1}"))
+	req.Header.Set("If-Match", "\"1\"")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Header().Get("ETag") != "\"2\"" {
+		t.Errorf("HandleAddProduct wrong response %d ETag %s", w.Code, w.Header().Get("ETag"))
+		return
+	}
+	// second tab still has the old version
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "", "{\"product\":\"MUG\",\"count\":1}"},
+		{"PUT", "/items/PEN", "{\"count\":3}"},
+		{"DELETE", "/items/PEN", ""},
+		{"DELETE", "/items", ""},
+		{"POST", "/checkout", ""},
+		{"DELETE", "", ""},
+	}
+	for _, request := range requests {
+		for _, ifMatch := range []string{"\"1\"", "W/\"2\"", "lala"} {
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest(request.method, "/api/v1/basket/"+basket.GetID()+request.path, strings.NewReader(request.body))
+			req.Header.Set("If-Match", ifMatch)
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusPreconditionFailed {
+				t.Errorf("%s %s If-Match %s wrong http status expected %d got %d", request.method, request.path, ifMatch, http.StatusPreconditionFailed, w.Code)
+				return
+			}
+		}
+	}
+	if items, _ := basket.GetItems(); len(items) != 1 || items[0].Count != 1 {
+		t.Errorf("rejected requests should not modify the basket %v", items)
+		return
+	}
+	// any of the listed versions or * match
+	for _, ifMatch := range []string{"\"1\", \"2\"", "*"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/api/v1/basket/"+basket.GetID()+"/items/PEN", strings.NewReader("{\"count\":3}"))
+		req.Header.Set("If-Match", ifMatch)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("HandleSetQuantity If-Match %s wrong http status expected %d got %d", ifMatch, http.StatusOK, w.Code)
+			return
+		}
+	}
+	if w.Header().Get("ETag") != "\"4\"" {
+		t.Errorf("HandleSetQuantity wrong ETag expected \"4\" got %s", w.Header().Get("ETag"))
 		return
 	}
 }
@@ -0,0 +1,290 @@
+package checkout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coupon errors, their messages are the reason given to shoppers
+var (
+	ErrCouponNotFound       = fmt.Errorf("Coupon not found")
+	ErrCouponExpired        = fmt.Errorf("Coupon has expired")
+	ErrCouponExhausted      = fmt.Errorf("Coupon usage limit reached")
+	ErrCouponAlreadyApplied = fmt.Errorf("Coupon already applied")
+	ErrCouponNotApplied     = fmt.Errorf("Coupon not in basket")
+)
+
+// Coupon - model, code shoppers attach to a basket to unlock a promotion
+// uses are counted when a basket with the coupon is checked out
+type Coupon struct {
+	Code      string
+	Promotion Promotion
+	// SingleUse - the coupon can be redeemed only once
+	SingleUse bool
+	// MaxUses - redemptions allowed for multi use coupons, 0 means unlimited
+	MaxUses int64
+	// ExpiresAt - the coupon can't be used from this moment, zero never expires
+	ExpiresAt time.Time
+	Uses      int64
+}
+
+// CouponRule - model, declarative definition of a coupon used in promotion config files
+// the promotion ID defaults to coupon:CODE
+type CouponRule struct {
+	Code      string        `json:"code" yaml:"code"`
+	Promotion PromotionRule `json:"promotion" yaml:"promotion"`
+	SingleUse bool          `json:"single_use,omitempty" yaml:"single_use"`
+	MaxUses   int64         `json:"max_uses,omitempty" yaml:"max_uses"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty" yaml:"expires_at"`
+}
+
+// CouponCode - DTO to attach or detach coupons
+type CouponCode struct {
+	Code string `json:"code"`
+}
+
+// codes are case insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Build - validate the rule and create the coupon it describes
+func (rule CouponRule) Build() (Coupon, error) {
+	code := normalizeCouponCode(rule.Code)
+	if code == "" {
+		return Coupon{}, fmt.Errorf("coupon code is required")
+	}
+	if rule.MaxUses < 0 {
+		return Coupon{}, fmt.Errorf("max_uses can't be negative")
+	}
+	if rule.Promotion.ID == "" {
+		rule.Promotion.ID = "coupon:" + code
+	}
+	promotion, err := rule.Promotion.Build()
+	if err != nil {
+		return Coupon{}, err
+	}
+	coupon := Coupon{Code: code, Promotion: promotion, SingleUse: rule.SingleUse, MaxUses: rule.MaxUses}
+	if rule.ExpiresAt != nil {
+		coupon.ExpiresAt = *rule.ExpiresAt
+	}
+	return coupon, nil
+}
+
+func (coupon Coupon) limit() int64 {
+	if coupon.SingleUse {
+		return 1
+	}
+	return coupon.MaxUses
+}
+
+// Usable - nil if the coupon can be redeemed at t, otherwise the reason it can't
+func (coupon Coupon) Usable(t time.Time) error {
+	if !coupon.ExpiresAt.IsZero() && !t.Before(coupon.ExpiresAt) {
+		return ErrCouponExpired
+	}
+	if limit := coupon.limit(); limit > 0 && coupon.Uses >= limit {
+		return ErrCouponExhausted
+	}
+	return nil
+}
+
+// Mutex to syncronize access to coupons
+var couponsLock = sync.RWMutex{}
+
+// known coupons by code
+var coupons = make(map[string]Coupon)
+
+// GetCoupon - Get a coupon by code
+func GetCoupon(code string) (Coupon, error) {
+	couponsLock.RLock()
+	defer couponsLock.RUnlock()
+	coupon, ok := coupons[normalizeCouponCode(code)]
+	if !ok {
+		return Coupon{}, ErrCouponNotFound
+	}
+	return coupon, nil
+}
+
+// ListCoupons - Get every coupon sorted by code
+func ListCoupons() []Coupon {
+	couponsLock.RLock()
+	defer couponsLock.RUnlock()
+	list := make([]Coupon, 0, len(coupons))
+	for _, coupon := range coupons {
+		list = append(list, coupon)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// CreateCoupon - add a coupon, replacing any previous one with the same code
+func CreateCoupon(coupon Coupon) error {
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	if coupon.Code == "" {
+		return fmt.Errorf("Coupon code is required")
+	}
+	if coupon.Promotion == nil {
+		return fmt.Errorf("Coupon promotion is required")
+	}
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	coupons[coupon.Code] = coupon
+	return nil
+}
+
+// DeleteCoupon - remove a coupon, baskets that have it stop getting its discounts
+func DeleteCoupon(code string) error {
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	code = normalizeCouponCode(code)
+	if _, ok := coupons[code]; !ok {
+		return ErrCouponNotFound
+	}
+	delete(coupons, code)
+	return nil
+}
+
+// SetCoupons - replace every coupon (i.e. on config reload)
+// uses of coupons that are kept are preserved so limits survive reloads
+func SetCoupons(list []Coupon) {
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	replaced := make(map[string]Coupon, len(list))
+	for _, coupon := range list {
+		coupon.Code = normalizeCouponCode(coupon.Code)
+		if previous, ok := coupons[coupon.Code]; ok {
+			coupon.Uses = previous.Uses
+		}
+		replaced[coupon.Code] = coupon
+	}
+	coupons = replaced
+}
+
+// RestoreCouponUses - count the uses of every coupon from the stored orders that redeemed it,
+// meant to be called on startup once coupons are loaded and the order store is set so usage
+// limits survive restarts
+func RestoreCouponUses() error {
+	list, err := orders.List()
+	if err != nil {
+		return err
+	}
+	uses := make(map[string]int64)
+	for _, order := range list {
+		for _, code := range order.Coupons {
+			uses[code]++
+		}
+	}
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	for code, coupon := range coupons {
+		coupon.Uses = uses[code]
+		coupons[code] = coupon
+	}
+	return nil
+}
+
+// promotions unlocked by codes that are currently usable, others are ignored
+func couponPromotions(codes []string) []Promotion {
+	couponsLock.RLock()
+	defer couponsLock.RUnlock()
+	t := now()
+	promotions := make([]Promotion, 0, len(codes))
+	for _, code := range codes {
+		coupon, ok := coupons[code]
+		if ok && coupon.Usable(t) == nil {
+			promotions = append(promotions, coupon.Promotion)
+		}
+	}
+	return promotions
+}
+
+// count one use of every code, nothing is counted if any of them can't be used
+func redeemCoupons(codes []string) error {
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	t := now()
+	for _, code := range codes {
+		coupon, ok := coupons[code]
+		if !ok {
+			return ErrCouponNotFound
+		}
+		if err := coupon.Usable(t); err != nil {
+			return err
+		}
+	}
+	for _, code := range codes {
+		coupon := coupons[code]
+		coupon.Uses++
+		coupons[code] = coupon
+	}
+	return nil
+}
+
+// give back uses counted by redeemCoupons (i.e. when checkout fails afterwards)
+func releaseCoupons(codes []string) {
+	couponsLock.Lock()
+	defer couponsLock.Unlock()
+	for _, code := range codes {
+		if coupon, ok := coupons[code]; ok && coupon.Uses > 0 {
+			coupon.Uses--
+			coupons[code] = coupon
+		}
+	}
+}
+
+// ApplyCoupon - attach a coupon to the basket, its discounts are included in totals
+func (b BasketWrapper) ApplyCoupon(code string) (applied []string, err error) {
+	coupon, err := GetCoupon(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := coupon.Usable(now()); err != nil {
+		return nil, err
+	}
+	err = b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		for _, c := range basket.Coupons {
+			if c == coupon.Code {
+				return ErrCouponAlreadyApplied
+			}
+		}
+		basket.Coupons = append(basket.Coupons, coupon.Code)
+		applied = append([]string(nil), basket.Coupons...)
+		return nil
+	})
+	return
+}
+
+// RemoveCoupon - detach a coupon from the basket
+func (b BasketWrapper) RemoveCoupon(code string) (remaining []string, err error) {
+	code = normalizeCouponCode(code)
+	err = b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		for i, c := range basket.Coupons {
+			if c == code {
+				basket.Coupons = append(basket.Coupons[:i], basket.Coupons[i+1:]...)
+				remaining = append([]string{}, basket.Coupons...)
+				return nil
+			}
+		}
+		return ErrCouponNotApplied
+	})
+	return
+}
+
+// GetCoupons - codes of the coupons attached to the basket
+func (b BasketWrapper) GetCoupons() ([]string, error) {
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{}, basket.Coupons...), nil
+}
@@ -0,0 +1,221 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+	"time"
+)
+
+// replace coupons for a test, returns a function restoring previous ones
+func resetCoupons(list ...Coupon) func() {
+	couponsLock.Lock()
+	previous := coupons
+	coupons = make(map[string]Coupon)
+	couponsLock.Unlock()
+	for _, coupon := range list {
+		_ = CreateCoupon(coupon)
+	}
+	return func() {
+		couponsLock.Lock()
+		defer couponsLock.Unlock()
+		coupons = previous
+	}
+}
+
+var mugCoupon = Coupon{
+	Code:      "MUGS4LESS",
+	Promotion: BulkPercentageDiscount{ID: "coupon:MUGS4LESS", Code: merchandise.MUG, BuyQuantity: 1, DiscountPercentage: 50},
+}
+
+func TestApplyCoupon(t *testing.T) {
+	defer resetCoupons(mugCoupon)()
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 2})
+	applied, err := b.ApplyCoupon("mugs4less")
+	if err != nil {
+		t.Errorf("ApplyCoupon returned an error %s", err.Error())
+		return
+	}
+	if len(applied) != 1 || applied[0] != "MUGS4LESS" {
+		t.Errorf("wrong applied coupons %v", applied)
+		return
+	}
+	total, _ := b.GetTotal()
+	expected := merchandise.Cents(750)
+	if total != expected {
+		t.Errorf("invalid total expected %s got %s", expected, total)
+		return
+	}
+	if _, err := b.ApplyCoupon("MUGS4LESS"); err != ErrCouponAlreadyApplied {
+		t.Errorf("ApplyCoupon twice expected %v got %v", ErrCouponAlreadyApplied, err)
+		return
+	}
+	if _, err := b.RemoveCoupon("MUGS4LESS"); err != nil {
+		t.Errorf("RemoveCoupon returned an error %s", err.Error())
+		return
+	}
+	total, _ = b.GetTotal()
+	expected = merchandise.Cents(1500)
+	if total != expected {
+		t.Errorf("invalid total after removing coupon expected %s got %s", expected, total)
+		return
+	}
+	if _, err := b.RemoveCoupon("MUGS4LESS"); err != ErrCouponNotApplied {
+		t.Errorf("RemoveCoupon expected %v got %v", ErrCouponNotApplied, err)
+	}
+}
+
+func TestApplyCouponRejected(t *testing.T) {
+	defer SetClock(SystemClock)
+	expired := mugCoupon
+	expired.Code = "OLD"
+	expired.ExpiresAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	used := mugCoupon
+	used.Code = "USED"
+	used.SingleUse = true
+	used.Uses = 1
+	limited := mugCoupon
+	limited.Code = "LIMITED"
+	limited.MaxUses = 3
+	limited.Uses = 3
+	defer resetCoupons(expired, used, limited)()
+	SetClock(FixedClock(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	cases := []struct {
+		code     string
+		expected error
+	}{
+		{"NOPE", ErrCouponNotFound},
+		{"OLD", ErrCouponExpired},
+		{"USED", ErrCouponExhausted},
+		{"LIMITED", ErrCouponExhausted},
+	}
+	for _, tc := range cases {
+		if _, err := b.ApplyCoupon(tc.code); err != tc.expected {
+			t.Errorf("ApplyCoupon %s expected %v got %v", tc.code, tc.expected, err)
+		}
+	}
+}
+
+func TestCouponRedeemedOnCheckout(t *testing.T) {
+	single := mugCoupon
+	single.SingleUse = true
+	defer resetCoupons(single)()
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	first, _ := NewBasket()
+	second, _ := NewBasket()
+	for _, b := range []Basket{first, second} {
+		_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+		if _, err := b.ApplyCoupon(single.Code); err != nil {
+			t.Errorf("ApplyCoupon returned an error %s", err.Error())
+			return
+		}
+	}
+	order, err := first.Checkout()
+	if err != nil {
+		t.Errorf("Checkout returned an error %s", err.Error())
+		return
+	}
+	expected := merchandise.Cents(375)
+	if order.Receipt.Total != expected {
+		t.Errorf("invalid order total expected %s got %s", expected, order.Receipt.Total)
+		return
+	}
+	coupon, _ := GetCoupon(single.Code)
+	if coupon.Uses != 1 {
+		t.Errorf("coupon use was not counted got %d", coupon.Uses)
+		return
+	}
+	// coupon is used up, it stops applying and the other basket can't be checked out with it
+	total, _ := second.GetTotal()
+	if total != merchandise.Cents(750) {
+		t.Errorf("exhausted coupon should not apply got %s", total)
+	}
+	if _, err := second.Checkout(); err != ErrCouponExhausted {
+		t.Errorf("Checkout expected %v got %v", ErrCouponExhausted, err)
+		return
+	}
+	if _, err := second.RemoveCoupon(single.Code); err != nil {
+		t.Errorf("RemoveCoupon returned an error %s", err.Error())
+		return
+	}
+	if _, err := second.Checkout(); err != nil {
+		t.Errorf("Checkout without coupon returned an error %s", err.Error())
+	}
+}
+
+func TestCouponRuleBuild(t *testing.T) {
+	expires := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	rule := CouponRule{
+		Code:      " welcome ",
+		Promotion: PromotionRule{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 2, GetFreeQuantity: 1},
+		MaxUses:   100,
+		ExpiresAt: &expires,
+	}
+	coupon, err := rule.Build()
+	if err != nil {
+		t.Errorf("Build returned an error %s", err.Error())
+		return
+	}
+	expected := BuyXGetY{ID: "coupon:WELCOME", Code: merchandise.PEN, BuyQuantity: 2, GetFreeQuantity: 1}
+	if coupon.Code != "WELCOME" || coupon.Promotion != expected || coupon.MaxUses != 100 || !coupon.ExpiresAt.Equal(expires) {
+		t.Errorf("wrong coupon %v", coupon)
+		return
+	}
+	if _, err := (CouponRule{Promotion: rule.Promotion}).Build(); err == nil {
+		t.Errorf("Build should require a code")
+	}
+	if _, err := (CouponRule{Code: "X", Promotion: rule.Promotion, MaxUses: -1}).Build(); err == nil {
+		t.Errorf("Build should reject negative max_uses")
+	}
+}
+
+func TestSetCouponsKeepsUses(t *testing.T) {
+	used := mugCoupon
+	used.Uses = 4
+	defer resetCoupons(used)()
+	reloaded := mugCoupon
+	reloaded.MaxUses = 10
+	SetCoupons([]Coupon{reloaded})
+	coupon, _ := GetCoupon(mugCoupon.Code)
+	if coupon.Uses != 4 || coupon.MaxUses != 10 {
+		t.Errorf("wrong reloaded coupon %v", coupon)
+		return
+	}
+	if err := DeleteCoupon(mugCoupon.Code); err != nil {
+		t.Errorf("DeleteCoupon returned an error %s", err.Error())
+		return
+	}
+	if len(ListCoupons()) != 0 {
+		t.Errorf("coupon was not deleted")
+	}
+}
+
+func TestRestoreCouponUses(t *testing.T) {
+	limited := mugCoupon
+	limited.MaxUses = 2
+	defer resetCoupons(limited)()
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	for i := 0; i < 2; i++ {
+		b, _ := NewBasket()
+		_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+		_, _ = b.ApplyCoupon(limited.Code)
+		if order, err := b.Checkout(); err != nil || len(order.Coupons) != 1 || order.Coupons[0] != limited.Code {
+			t.Errorf("order should record its coupons got %v %v", order.Coupons, err)
+			return
+		}
+	}
+	// coupons are loaded again without uses on restart, they come from stored orders
+	_ = resetCoupons(limited)
+	if err := RestoreCouponUses(); err != nil {
+		t.Errorf("RestoreCouponUses returned an error %s", err.Error())
+		return
+	}
+	if coupon, _ := GetCoupon(limited.Code); coupon.Uses != 2 || coupon.Usable(now()) != ErrCouponExhausted {
+		t.Errorf("wrong restored uses expected 2 got %d", coupon.Uses)
+	}
+}
@@ -0,0 +1,313 @@
+package checkout
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// customer errors
+var (
+	ErrCustomerNotFound       = fmt.Errorf("Customer not found")
+	ErrCustomerExists         = fmt.Errorf("Customer already exists")
+	ErrInvalidCredentials     = fmt.Errorf("Invalid credentials")
+	ErrAuthenticationRequired = fmt.Errorf("Authentication required")
+	ErrAdminRequired          = fmt.Errorf("Admin access required")
+)
+
+// Customer - model, shopper with an account, baskets created by a customer belong to it
+type Customer struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CustomerRegistration - DTO to create a customer, emails are unique (case insensitive)
+type CustomerRegistration struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// CustomerCredentials - DTO, registered customer and the bearer token that identifies it
+// the token is only given on registration, only its hash is kept
+type CustomerCredentials struct {
+	Customer
+	Token string `json:"token"`
+}
+
+// Caller - who makes a request: a customer, an admin or anonymous (zero value)
+type Caller struct {
+	CustomerID string
+	Admin      bool
+}
+
+// Mutex to syncronize access to customers
+var customersLock = sync.RWMutex{}
+
+var (
+	// customers by id
+	customers = make(map[string]Customer)
+	// customer ids by email
+	customerEmails = make(map[string]string)
+	// customer ids by token hash
+	customerTokens = make(map[string]string)
+	// hash of the token identifying admins, empty means there are no admins
+	adminToken string
+	// log new customers are appended to, nil keeps them in memory only
+	customersLog *os.File
+)
+
+const customersFile = "customers.log"
+
+// customer as stored in the customers log, only the hash of its token is kept
+type storedCustomer struct {
+	Customer
+	TokenHash string `json:"token_hash"`
+}
+
+// PersistCustomers - load the customers stored in dir and append new ones there so customers
+// and their tokens survive restarts, customers are never changed so the log is not compacted.
+// Returns a function to stop persisting them and release the file
+func PersistCustomers(dir string) (func() error, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, customersFile)
+	customersLock.Lock()
+	defer customersLock.Unlock()
+	err := readLog(path, func() interface{} { return &storedCustomer{} }, func(v interface{}) {
+		stored := v.(*storedCustomer)
+		customers[stored.ID] = stored.Customer
+		customerEmails[stored.Email] = stored.ID
+		customerTokens[stored.TokenHash] = stored.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// an incomplete entry left by a crash is dropped so new ones don't land after it
+	if err := dropIncompleteEntry(log); err != nil {
+		log.Close()
+		return nil, err
+	}
+	customersLog = log
+	return func() error {
+		customersLock.Lock()
+		defer customersLock.Unlock()
+		customersLog = nil
+		return log.Close()
+	}, nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// RegisterCustomer - create a customer and the token to authenticate it
+func RegisterCustomer(registration CustomerRegistration) (CustomerCredentials, error) {
+	email := normalizeEmail(registration.Email)
+	if at := strings.Index(email, "@"); at <= 0 || at == len(email)-1 {
+		return CustomerCredentials{}, fmt.Errorf("Invalid email")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return CustomerCredentials{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	customer := Customer{
+		ID:        uuid.Must(uuid.NewRandom()).String(),
+		Email:     email,
+		Name:      strings.TrimSpace(registration.Name),
+		CreatedAt: now().UTC(),
+	}
+	customersLock.Lock()
+	defer customersLock.Unlock()
+	if _, ok := customerEmails[email]; ok {
+		return CustomerCredentials{}, ErrCustomerExists
+	}
+	hash := hashToken(token)
+	if customersLog != nil {
+		if err := appendLog(customersLog, storedCustomer{Customer: customer, TokenHash: hash}); err != nil {
+			return CustomerCredentials{}, err
+		}
+	}
+	customers[customer.ID] = customer
+	customerEmails[email] = customer.ID
+	customerTokens[hash] = customer.ID
+	return CustomerCredentials{Customer: customer, Token: token}, nil
+}
+
+// GetCustomer - Get a customer by id
+func GetCustomer(id string) (Customer, error) {
+	customersLock.RLock()
+	defer customersLock.RUnlock()
+	customer, ok := customers[id]
+	if !ok {
+		return Customer{}, ErrCustomerNotFound
+	}
+	return customer, nil
+}
+
+// SetAdminToken - token identifying admins (i.e. dashboards listing every basket), empty
+// disables admin access
+func SetAdminToken(token string) {
+	customersLock.Lock()
+	defer customersLock.Unlock()
+	adminToken = ""
+	if token != "" {
+		adminToken = hashToken(token)
+	}
+}
+
+// Authenticate - caller identified by a bearer token, ErrInvalidCredentials if the token
+// is unknown
+func Authenticate(token string) (Caller, error) {
+	hash := hashToken(token)
+	customersLock.RLock()
+	defer customersLock.RUnlock()
+	if adminToken != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(adminToken)) == 1 {
+		return Caller{Admin: true}, nil
+	}
+	if id, ok := customerTokens[hash]; ok {
+		return Caller{CustomerID: id}, nil
+	}
+	return Caller{}, ErrInvalidCredentials
+}
+
+// CanAccess - anonymous baskets can be used by anyone knowing their id, owned baskets only
+// by their owner and admins
+func (caller Caller) CanAccess(basket BasketData) bool {
+	return basket.Owner == "" || caller.Admin || basket.Owner == caller.CustomerID
+}
+
+// AccessBasket - nil if caller can use the basket, baskets of others are not found so
+// their existence is not disclosed
+func AccessBasket(caller Caller, id string) error {
+	basket, err := loadBasket(id)
+	if err != nil {
+		return err
+	}
+	if !caller.CanAccess(basket) {
+		return ErrBasketNotFound
+	}
+	return nil
+}
+
+// CanAccessOrder - orders can be used by whoever could use the basket they come from
+func (caller Caller) CanAccessOrder(order Order) bool {
+	return order.Owner == "" || caller.Admin || order.Owner == caller.CustomerID
+}
+
+// AccessOrder - nil if caller can use the order, orders of others are not found
+func AccessOrder(caller Caller, id string) error {
+	order, err := GetOrder(id)
+	if err != nil {
+		return err
+	}
+	if !caller.CanAccessOrder(order) {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// ListOrdersFor - ListOrders scoped to the caller: customers only get their orders and admins
+// every order, anonymous callers can't list orders
+func ListOrdersFor(caller Caller) ([]Order, error) {
+	if !caller.Admin && caller.CustomerID == "" {
+		return nil, ErrAuthenticationRequired
+	}
+	list, err := ListOrders()
+	if err != nil || caller.Admin {
+		return list, err
+	}
+	owned := make([]Order, 0, len(list))
+	for _, order := range list {
+		if order.Owner == caller.CustomerID {
+			owned = append(owned, order)
+		}
+	}
+	return owned, nil
+}
+
+// QueryBasketsFor - QueryBaskets scoped to the caller: customers only get their baskets and
+// admins every basket, anonymous callers can't list baskets
+func QueryBasketsFor(caller Caller, query BasketQuery) (BasketPage, error) {
+	if !caller.Admin {
+		if caller.CustomerID == "" {
+			return BasketPage{}, ErrAuthenticationRequired
+		}
+		query.Owner = caller.CustomerID
+	}
+	return QueryBaskets(query)
+}
+
+// key of the caller in request context
+const callerKey = "checkout.caller"
+
+// Authentication - middleware identifying the caller by an Authorization: Bearer header,
+// requests without it are anonymous and unknown tokens are rejected
+func Authentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if !strings.HasPrefix(header, "Bearer ") || token == "" {
+			abort(c, ErrInvalidCredentials)
+			c.Abort()
+			return
+		}
+		caller, err := Authenticate(token)
+		if err != nil {
+			abort(c, err)
+			c.Abort()
+			return
+		}
+		c.Set(callerKey, caller)
+	}
+}
+
+// AdminOnly - middleware rejecting requests not made by an admin, goes after Authentication
+// anonymous requests get a 401 and customers a 403
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := callerOf(c)
+		if caller.Admin {
+			return
+		}
+		if caller.CustomerID == "" {
+			abort(c, ErrAuthenticationRequired)
+		} else {
+			abort(c, ErrAdminRequired)
+		}
+		c.Abort()
+	}
+}
+
+// caller identified by Authentication, anonymous if none
+func callerOf(c *gin.Context) Caller {
+	if caller, ok := c.Get(callerKey); ok {
+		return caller.(Caller)
+	}
+	return Caller{}
+}
@@ -0,0 +1,328 @@
+package checkout
+
+import (
+	"encoding/json"
+	"github.com/gato/lana/merchandise"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// forget every customer, returns a function restoring previous ones
+func resetCustomers() func() {
+	customersLock.Lock()
+	previous := []map[string]string{customerEmails, customerTokens}
+	previousCustomers, previousLog := customers, customersLog
+	customersLog = nil
+	customers = make(map[string]Customer)
+	customerEmails = make(map[string]string)
+	customerTokens = make(map[string]string)
+	customersLock.Unlock()
+	return func() {
+		customersLock.Lock()
+		defer customersLock.Unlock()
+		customers, customersLog = previousCustomers, previousLog
+		customerEmails, customerTokens = previous[0], previous[1]
+	}
+}
+
+func mustRegisterCustomer(t *testing.T, email string) CustomerCredentials {
+	credentials, err := RegisterCustomer(CustomerRegistration{Email: email})
+	if err != nil {
+		t.Fatalf("RegisterCustomer returned an error %s", err.Error())
+	}
+	return credentials
+}
+
+func TestRegisterCustomer(t *testing.T) {
+	defer resetCustomers()()
+	credentials, err := RegisterCustomer(CustomerRegistration{Email: " Alice@Example.com ", Name: "Alice"})
+	if err != nil {
+		t.Errorf("RegisterCustomer returned an error %s", err.Error())
+		return
+	}
+	if credentials.Email != "alice@example.com" || credentials.ID == "" || credentials.Token == "" {
+		t.Errorf("wrong credentials %v", credentials)
+		return
+	}
+	if _, err := RegisterCustomer(CustomerRegistration{Email: "ALICE@example.com"}); err != ErrCustomerExists {
+		t.Errorf("expected %v got %v", ErrCustomerExists, err)
+		return
+	}
+	for _, email := range []string{"", "alice", "@example.com", "alice@"} {
+		if _, err := RegisterCustomer(CustomerRegistration{Email: email}); err == nil || err.Error() != "Invalid email" {
+			t.Errorf("email %q expected Invalid email got %v", email, err)
+			return
+		}
+	}
+	caller, err := Authenticate(credentials.Token)
+	if err != nil || caller.CustomerID != credentials.ID || caller.Admin {
+		t.Errorf("wrong caller %v %v", caller, err)
+		return
+	}
+	if _, err := Authenticate("lala"); err != ErrInvalidCredentials {
+		t.Errorf("expected %v got %v", ErrInvalidCredentials, err)
+		return
+	}
+}
+
+func TestPersistCustomers(t *testing.T) {
+	defer resetCustomers()()
+	dir := t.TempDir()
+	closeCustomers, err := PersistCustomers(dir)
+	if err != nil {
+		t.Errorf("PersistCustomers returned an error %s", err.Error())
+		return
+	}
+	credentials := mustRegisterCustomer(t, "alice@example.com")
+	if err := closeCustomers(); err != nil {
+		t.Errorf("closing customers returned an error %s", err.Error())
+		return
+	}
+	// a crash in the middle of a write leaves an incomplete entry
+	f, err := os.OpenFile(filepath.Join(dir, customersFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Errorf("unable to open the customers log %s", err.Error())
+		return
+	}
+	f.WriteString(`{"id":"lala`)
+	f.Close()
+	resetCustomers()
+	closeCustomers, err = PersistCustomers(dir)
+	if err != nil {
+		t.Errorf("PersistCustomers returned an error %s", err.Error())
+		return
+	}
+	caller, err := Authenticate(credentials.Token)
+	if err != nil || caller.CustomerID != credentials.ID {
+		t.Errorf("wrong caller %v %v", caller, err)
+		return
+	}
+	if _, err := RegisterCustomer(CustomerRegistration{Email: "alice@example.com"}); err != ErrCustomerExists {
+		t.Errorf("expected %v got %v", ErrCustomerExists, err)
+		return
+	}
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	closeCustomers()
+	resetCustomers()
+	closeCustomers, err = PersistCustomers(dir)
+	if err != nil {
+		t.Errorf("PersistCustomers returned an error %s", err.Error())
+		return
+	}
+	defer closeCustomers()
+	for _, id := range []string{credentials.ID, bob.ID} {
+		if _, err := GetCustomer(id); err != nil {
+			t.Errorf("customer %s not restored %v", id, err)
+			return
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	defer SetAdminToken("")
+	if _, err := Authenticate(""); err != ErrInvalidCredentials {
+		t.Errorf("admin access should be disabled by default got %v", err)
+		return
+	}
+	SetAdminToken("secret")
+	if caller, err := Authenticate("secret"); err != nil || !caller.Admin {
+		t.Errorf("wrong caller %v %v", caller, err)
+		return
+	}
+}
+
+func TestAccessBasket(t *testing.T) {
+	defer resetCustomers()()
+	SetBasketStore(NewMemoryBasketStore())
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	anonymous, _ := NewBasket()
+	owned, _ := NewBasketWithOptions(BasketOptions{Owner: alice.ID})
+	tests := []struct {
+		caller   Caller
+		basket   Basket
+		expected error
+	}{
+		{Caller{}, anonymous, nil},
+		{Caller{CustomerID: bob.ID}, anonymous, nil},
+		{Caller{CustomerID: alice.ID}, owned, nil},
+		{Caller{Admin: true}, owned, nil},
+		{Caller{}, owned, ErrBasketNotFound},
+		{Caller{CustomerID: bob.ID}, owned, ErrBasketNotFound},
+	}
+	for i, test := range tests {
+		if err := AccessBasket(test.caller, test.basket.GetID()); err != test.expected {
+			t.Errorf("test %d expected %v got %v", i, test.expected, err)
+			return
+		}
+	}
+}
+
+func TestQueryBasketsFor(t *testing.T) {
+	defer resetCustomers()()
+	SetBasketStore(NewMemoryBasketStore())
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	_, _ = NewBasket()
+	owned, _ := NewBasketWithOptions(BasketOptions{Owner: alice.ID})
+	page, _ := QueryBasketsFor(Caller{CustomerID: alice.ID}, BasketQuery{Owner: bob.ID})
+	if ids := pageIDs(page); len(ids) != 1 || ids[0] != owned.GetID() {
+		t.Errorf("customers should only list their baskets got %v", ids)
+		return
+	}
+	if page, _ := QueryBasketsFor(Caller{Admin: true}, BasketQuery{}); len(page.Baskets) != 2 {
+		t.Errorf("admins should list every basket got %v", pageIDs(page))
+		return
+	}
+	if _, err := QueryBasketsFor(Caller{}, BasketQuery{}); err != ErrAuthenticationRequired {
+		t.Errorf("expected %v got %v", ErrAuthenticationRequired, err)
+		return
+	}
+}
+
+func TestHandleCustomerOrders(t *testing.T) {
+	defer resetCustomers()()
+	defer SetAdminToken("")
+	SetAdminToken("admin")
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	owned, _ := NewBasketWithOptions(BasketOptions{Owner: alice.ID})
+	_, _ = owned.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	order, _ := owned.Checkout()
+	if order.Owner != alice.ID {
+		t.Errorf("order should belong to the basket owner got %q", order.Owner)
+		return
+	}
+	anonymous, _ := NewBasket()
+	_, _ = anonymous.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = anonymous.Checkout()
+	r := getRouter()
+	request := func(method, path, body, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	path := "/api/v1/orders/" + order.ID
+	for _, token := range []string{"", bob.Token} {
+		for _, action := range []string{"", "/pay", "/cancel"} {
+			method := "POST"
+			if action == "" {
+				method = "GET"
+			}
+			if w := request(method, path+action, "{\"card_number\":\""+MockCardApproved+"\"}", token); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s by others wrong http status expected %d got %d", method, action, http.StatusNotFound, w.Code)
+				return
+			}
+		}
+	}
+	// fulfilling and refunding orders is for admins, even the owner can't
+	for token, status := range map[string]int{"": http.StatusUnauthorized, alice.Token: http.StatusForbidden, bob.Token: http.StatusForbidden} {
+		for _, action := range []string{"/fulfill", "/refund"} {
+			if w := request("POST", path+action, "", token); w.Code != status {
+				t.Errorf("POST %s wrong http status expected %d got %d", action, status, w.Code)
+				return
+			}
+		}
+	}
+	if w := request("GET", path, "", alice.Token); w.Code != http.StatusOK {
+		t.Errorf("HandleGetOrder wrong http status expected %d got %d", http.StatusOK, w.Code)
+		return
+	}
+	w := request("GET", "/api/v1/orders/", "", alice.Token)
+	var list []Order
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].ID != order.ID {
+		t.Errorf("HandleGetAllOrders wrong list %s", w.Body.String())
+		return
+	}
+	if w := request("POST", path+"/pay", "{\"card_number\":\""+MockCardApproved+"\"}", alice.Token); w.Code != http.StatusOK {
+		t.Errorf("HandlePayOrder wrong http status expected %d got %d", http.StatusOK, w.Code)
+		return
+	}
+	if w := request("POST", path+"/fulfill", "", "admin"); w.Code != http.StatusOK {
+		t.Errorf("HandleOrderTransition wrong http status expected %d got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestHandleCustomerBaskets(t *testing.T) {
+	defer resetCustomers()()
+	SetBasketStore(NewMemoryBasketStore())
+	r := getRouter()
+	request := func(method, path, body, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w := request("POST", "/api/v1/customers/", "{\"email\":\"alice@example.com\",\"name\":\"Alice\"}", "")
+	var alice CustomerCredentials
+	if err := json.Unmarshal(w.Body.Bytes(), &alice); w.Code != http.StatusCreated || err != nil || alice.Token == "" {
+		t.Errorf("HandleRegisterCustomer wrong response %d %s", w.Code, w.Body.String())
+		return
+	}
+	if w := request("POST", "/api/v1/customers/", "{\"email\":\"alice@example.com\"}", ""); w.Code != http.StatusConflict {
+		t.Errorf("HandleRegisterCustomer wrong http status expected %d got %d", http.StatusConflict, w.Code)
+		return
+	}
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	if w := request("GET", "/api/v1/customers/me", "", alice.Token); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), alice.ID) {
+		t.Errorf("HandleGetCurrentCustomer wrong response %d %s", w.Code, w.Body.String())
+		return
+	}
+	if w := request("GET", "/api/v1/customers/me", "", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("HandleGetCurrentCustomer wrong http status expected %d got %d", http.StatusUnauthorized, w.Code)
+		return
+	}
+	if w := request("GET", "/api/v1/basket/", "", "lala"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token wrong http status expected %d got %d", http.StatusUnauthorized, w.Code)
+		return
+	}
+	// baskets created by customers are theirs
+	w = request("POST", "/api/v1/basket/", "", alice.Token)
+	var created struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	path := "/api/v1/basket/" + created.ID
+	if w := request("POST", path, "{\"product\":\"PEN\",\"count\":1}", alice.Token); w.Code != http.StatusCreated {
+		t.Errorf("owner wrong http status expected %d got %d", http.StatusCreated, w.Code)
+		return
+	}
+	if w := request("GET", path, "", alice.Token); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\"owner\":\""+alice.ID+"\"") {
+		t.Errorf("owner wrong response %d %s", w.Code, w.Body.String())
+		return
+	}
+	for _, token := range []string{"", bob.Token} {
+		for _, method := range []string{"GET", "POST", "DELETE"} {
+			w := request(method, path, "{\"product\":\"PEN\",\"count\":1}", token)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s by others wrong http status expected %d got %d", method, http.StatusNotFound, w.Code)
+				return
+			}
+		}
+	}
+	// listing is scoped to the caller
+	_ = request("POST", "/api/v1/basket/", "", bob.Token)
+	w = request("GET", "/api/v1/basket/", "", alice.Token)
+	var page BasketPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Baskets) != 1 || page.Baskets[0].ID != created.ID {
+		t.Errorf("HandleGetAllBaskets wrong page %s", w.Body.String())
+		return
+	}
+	if w := request("GET", "/api/v1/basket/", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous listing wrong http status expected %d got %d", http.StatusUnauthorized, w.Code)
+		return
+	}
+}
@@ -0,0 +1,211 @@
+package checkout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// BasketExpiry - idle time to live given to new baskets, a basket expires when it is not
+// modified for TTL (0 never expires). With TouchOnRead reading a basket also extends it.
+// Checked out baskets never expire, they are kept for reference of their order
+type BasketExpiry struct {
+	TTL         time.Duration
+	TouchOnRead bool
+}
+
+// BasketOptions - DTO, settings of a new basket
+type BasketOptions struct {
+	// TTLSeconds - idle seconds before the basket expires, 0 never expires and missing
+	// uses the server default
+	TTLSeconds *int64 `json:"ttl_seconds"`
+	// Owner - customer the basket belongs to, empty for anonymous baskets. It is the caller
+	// creating the basket, not part of the payload
+	Owner string `json:"-"`
+}
+
+// BasketTimes - DTO, when a basket was created, last modified and when it expires
+type BasketTimes struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// BasketArchive - receives expired baskets before they are removed
+type BasketArchive interface {
+	Archive(BasketData) error
+}
+
+// Mutex to syncronize access to basket expiry settings
+var expiryLock = sync.RWMutex{}
+
+var basketExpiry = BasketExpiry{}
+
+// SetBasketExpiry - change the expiry settings, the TTL only applies to new baskets
+func SetBasketExpiry(expiry BasketExpiry) error {
+	if expiry.TTL < 0 {
+		return fmt.Errorf("ttl can't be negative")
+	}
+	expiryLock.Lock()
+	defer expiryLock.Unlock()
+	basketExpiry = expiry
+	return nil
+}
+
+func currentBasketExpiry() BasketExpiry {
+	expiryLock.RLock()
+	defer expiryLock.RUnlock()
+	return basketExpiry
+}
+
+func (data BasketData) expired(t time.Time) bool {
+	return data.TTL > 0 && !data.CheckedOut && !t.Before(data.ExpiresAt)
+}
+
+// extend the basket life from t
+func (data *BasketData) extend(t time.Time) {
+	if data.TTL > 0 {
+		data.ExpiresAt = t.Add(data.TTL)
+	}
+}
+
+func (data BasketData) times() BasketTimes {
+	times := BasketTimes{CreatedAt: data.CreatedAt, UpdatedAt: data.UpdatedAt}
+	if data.TTL > 0 && !data.CheckedOut {
+		expiresAt := data.ExpiresAt
+		times.ExpiresAt = &expiresAt
+	}
+	return times
+}
+
+// get a stored basket, expired baskets are not found even if they were not reaped yet
+func loadBasket(id string) (BasketData, error) {
+	basket, err := store.Get(id)
+	if err != nil {
+		return BasketData{}, err
+	}
+	if basket.expired(now()) {
+		return BasketData{}, ErrBasketNotFound
+	}
+	return basket, nil
+}
+
+// modify a basket, expired baskets are not found and any change extends the basket life
+// and increases its version, returns the new version
+func updateBasket(id string, fn func(*BasketData) error) (version int64, err error) {
+	err = store.Update(id, func(basket *BasketData) error {
+		t := now()
+		if basket.expired(t) {
+			return ErrBasketNotFound
+		}
+		if err := fn(basket); err != nil {
+			return err
+		}
+		basket.UpdatedAt = t.UTC()
+		basket.extend(t)
+		basket.Version++
+		version = basket.Version
+		return nil
+	})
+	return
+}
+
+// extend the life of a basket that is read if the expiry settings say so
+func touchBasket(id string) error {
+	_, err := readBasket(Caller{Admin: true}, id)
+	return err
+}
+
+// basket read by caller, its life is extended if the expiry settings say so. Baskets
+// of others are not found nor touched
+func readBasket(caller Caller, id string) (BasketData, error) {
+	if !currentBasketExpiry().TouchOnRead {
+		basket, err := loadBasket(id)
+		if err == nil && !caller.CanAccess(basket) {
+			return BasketData{}, ErrBasketNotFound
+		}
+		return basket, err
+	}
+	var read BasketData
+	err := store.Update(id, func(basket *BasketData) error {
+		t := now()
+		if basket.expired(t) || !caller.CanAccess(*basket) {
+			return ErrBasketNotFound
+		}
+		basket.extend(t)
+		read = basket.copy()
+		return nil
+	})
+	return read, err
+}
+
+// GetTimes - when the basket was created, last modified and when it expires
+func (b BasketWrapper) GetTimes() (BasketTimes, error) {
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return BasketTimes{}, err
+	}
+	return basket.times(), nil
+}
+
+// returned by the reaper to keep baskets that are no longer expired
+var errBasketNotExpired = fmt.Errorf("Basket not expired")
+
+// ReapExpiredBaskets - remove expired baskets, archiving them first when archive is not nil
+// returns how many were removed. Baskets can change after being listed so expiry is checked
+// again while removing them and the archived copy is the one removed
+func ReapExpiredBaskets(archive BasketArchive) (int, error) {
+	t := now()
+	baskets, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	reaped := 0
+	for _, basket := range baskets {
+		if !basket.expired(t) {
+			continue
+		}
+		err := store.DeleteIf(basket.ID, func(basket BasketData) error {
+			if !basket.expired(now()) {
+				return errBasketNotExpired
+			}
+			if archive != nil {
+				return archive.Archive(basket)
+			}
+			return nil
+		})
+		if err == errBasketNotExpired || err == ErrBasketNotFound {
+			continue
+		}
+		if err != nil {
+			return reaped, err
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// JSONBasketArchive - BasketArchive writing one json document per basket and line
+type JSONBasketArchive struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewJSONBasketArchive - archive writing expired baskets to w
+func NewJSONBasketArchive(w io.Writer) *JSONBasketArchive {
+	return &JSONBasketArchive{w: w}
+}
+
+// Archive - write the basket as a line of json
+func (a *JSONBasketArchive) Archive(basket BasketData) error {
+	data, err := json.Marshal(basket)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,205 @@
+package checkout
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gato/lana/merchandise"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock that can be moved forward
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.t
+}
+
+func startExpiryTest(expiry BasketExpiry) (*testClock, func()) {
+	clock := &testClock{t: time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	SetBasketStore(NewMemoryBasketStore())
+	_ = SetBasketExpiry(expiry)
+	return clock, func() {
+		SetClock(SystemClock)
+		_ = SetBasketExpiry(BasketExpiry{})
+	}
+}
+
+func TestBasketExpiry(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour})
+	defer stop()
+	b, _ := NewBasket()
+	times, _ := b.GetTimes()
+	if times.ExpiresAt == nil || !times.ExpiresAt.Equal(clock.t.Add(time.Hour)) || !times.CreatedAt.Equal(clock.t) {
+		t.Errorf("wrong basket times %v", times)
+		return
+	}
+	// changes extend the basket life
+	clock.t = clock.t.Add(50 * time.Minute)
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	times, _ = b.GetTimes()
+	if !times.UpdatedAt.Equal(clock.t) || !times.ExpiresAt.Equal(clock.t.Add(time.Hour)) {
+		t.Errorf("changes should extend the basket life %v", times)
+		return
+	}
+	// reads don't
+	clock.t = clock.t.Add(50 * time.Minute)
+	if _, err := GetBasket(b.GetID()); err != nil {
+		t.Errorf("GetBasket returned an error %s", err.Error())
+		return
+	}
+	clock.t = clock.t.Add(10 * time.Minute)
+	if _, err := GetBasket(b.GetID()); err != ErrBasketNotFound {
+		t.Errorf("expired basket should not be found got %v", err)
+		return
+	}
+	if _, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1}); err != ErrBasketNotFound {
+		t.Errorf("expired basket should not be modified got %v", err)
+		return
+	}
+	if list, _ := ListBaskets(); len(list) != 0 {
+		t.Errorf("expired baskets should not be listed")
+	}
+	if err := DeleteBasket(b.GetID()); err != ErrBasketNotFound {
+		t.Errorf("expired basket should not be found got %v", err)
+	}
+}
+
+func TestBasketTouchOnRead(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour, TouchOnRead: true})
+	defer stop()
+	b, _ := NewBasket()
+	for i := 0; i < 3; i++ {
+		clock.t = clock.t.Add(50 * time.Minute)
+		if _, err := GetBasket(b.GetID()); err != nil {
+			t.Errorf("reads should extend the basket life got %v", err)
+			return
+		}
+	}
+	times, _ := b.GetTimes()
+	if !times.UpdatedAt.Equal(clock.t.Add(-150*time.Minute)) || !times.ExpiresAt.Equal(clock.t.Add(time.Hour)) {
+		t.Errorf("reads should only change expiry %v", times)
+		return
+	}
+	// the body of a touched basket shows its new expiry
+	clock.t = clock.t.Add(50 * time.Minute)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/basket/"+b.GetID(), nil)
+	getRouter().ServeHTTP(w, req)
+	if expires := clock.t.Add(time.Hour).Format(time.RFC3339); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\"expires_at\":\""+expires) {
+		t.Errorf("HandleGetByID wrong response %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBasketOptions(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour})
+	defer stop()
+	never := int64(0)
+	forever, _ := NewBasketWithOptions(BasketOptions{TTLSeconds: &never})
+	minute := int64(60)
+	short, _ := NewBasketWithOptions(BasketOptions{TTLSeconds: &minute})
+	clock.t = clock.t.Add(2 * time.Minute)
+	if _, err := GetBasket(short.GetID()); err != ErrBasketNotFound {
+		t.Errorf("basket should have its own ttl got %v", err)
+	}
+	clock.t = clock.t.Add(1000 * time.Hour)
+	if times, err := forever.GetTimes(); err != nil || times.ExpiresAt != nil {
+		t.Errorf("basket without ttl should never expire got %v %v", times, err)
+	}
+	invalid := int64(-1)
+	if _, err := NewBasketWithOptions(BasketOptions{TTLSeconds: &invalid}); err == nil || err.Error() != "Invalid ttl" {
+		t.Errorf("negative ttl should be rejected got %v", err)
+	}
+	if err := SetBasketExpiry(BasketExpiry{TTL: -time.Second}); err == nil {
+		t.Errorf("SetBasketExpiry should reject negative ttls")
+	}
+}
+
+func TestReapExpiredBaskets(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour})
+	defer stop()
+	abandoned, _ := NewBasket()
+	_, _ = abandoned.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+	checkedOut, _ := NewBasket()
+	_, _ = checkedOut.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = checkedOut.Checkout()
+	clock.t = clock.t.Add(30 * time.Minute)
+	active, _ := NewBasket()
+
+	clock.t = clock.t.Add(45 * time.Minute)
+	var archived bytes.Buffer
+	reaped, err := ReapExpiredBaskets(NewJSONBasketArchive(&archived))
+	if err != nil {
+		t.Errorf("ReapExpiredBaskets returned an error %s", err.Error())
+		return
+	}
+	if reaped != 1 {
+		t.Errorf("wrong number of reaped baskets expected 1 got %d", reaped)
+		return
+	}
+	var data BasketData
+	if err := json.Unmarshal([]byte(strings.TrimSpace(archived.String())), &data); err != nil || data.ID != abandoned.GetID() || data.Items[merchandise.MUG] != 1 {
+		t.Errorf("abandoned basket should be archived got %s", archived.String())
+		return
+	}
+	if _, err := store.Get(abandoned.GetID()); err != ErrBasketNotFound {
+		t.Errorf("reaped basket should be removed")
+	}
+	for _, b := range []Basket{checkedOut, active} {
+		if _, err := GetBasket(b.GetID()); err != nil {
+			t.Errorf("basket %s should not be reaped", b.GetID())
+		}
+	}
+	// without archive baskets are just removed
+	clock.t = clock.t.Add(time.Hour)
+	if reaped, _ := ReapExpiredBaskets(nil); reaped != 1 {
+		t.Errorf("wrong number of reaped baskets expected 1 got %d", reaped)
+	}
+}
+
+// store listing baskets as they were at some point
+type staleListStore struct {
+	BasketStore
+	list []BasketData
+}
+
+func (s staleListStore) List() ([]BasketData, error) {
+	return s.list, nil
+}
+
+func TestReapExpiredBasketsChangedAfterListing(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour})
+	defer stop()
+	touched, _ := NewBasket()
+	checkedOut, _ := NewBasket()
+	_, _ = checkedOut.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	clock.t = clock.t.Add(2 * time.Hour)
+	listed, _ := store.List()
+	// both baskets change after being listed as expired
+	_ = store.Update(touched.GetID(), func(basket *BasketData) error {
+		basket.extend(now())
+		return nil
+	})
+	_ = store.Update(checkedOut.GetID(), func(basket *BasketData) error {
+		basket.CheckedOut = true
+		return nil
+	})
+	SetBasketStore(staleListStore{BasketStore: store, list: listed})
+	var archived bytes.Buffer
+	reaped, err := ReapExpiredBaskets(NewJSONBasketArchive(&archived))
+	if err != nil || reaped != 0 || archived.Len() != 0 {
+		t.Errorf("changed baskets should not be reaped got %d %v %s", reaped, err, archived.String())
+		return
+	}
+	for _, b := range []Basket{touched, checkedOut} {
+		if _, err := store.Get(b.GetID()); err != nil {
+			t.Errorf("basket %s should not be removed", b.GetID())
+		}
+	}
+}
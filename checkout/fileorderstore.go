@@ -0,0 +1,142 @@
+package checkout
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	orderSnapshotFile = "orders.snapshot"
+	orderLogFile      = "orders.log"
+)
+
+// FileOrderStore - OrderStore persisted on disk, like FileBasketStore every change is
+// appended (and synced) to a log before being applied in memory and the log is
+// periodically compacted into a snapshot. Orders are logged whole as they are never removed
+type FileOrderStore struct {
+	lock    sync.RWMutex
+	dir     string
+	orders  map[string]Order
+	log     *os.File
+	entries int
+	// SnapshotEvery - log entries written before compacting, 0 disables compaction
+	SnapshotEvery int
+}
+
+// OpenFileOrderStore - opens (creating if needed) an order store in dir and replays its contents
+func OpenFileOrderStore(dir string) (*FileOrderStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileOrderStore{
+		dir:           dir,
+		orders:        make(map[string]Order),
+		SnapshotEvery: DefaultSnapshotEvery,
+	}
+	var orders []Order
+	if err := readSnapshot(filepath.Join(dir, orderSnapshotFile), &orders); err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		s.orders[order.ID] = order
+	}
+	err := readLog(filepath.Join(dir, orderLogFile), func() interface{} { return &Order{} }, func(v interface{}) {
+		order := v.(*Order)
+		s.orders[order.ID] = *order
+	})
+	if err != nil {
+		return nil, err
+	}
+	// start with a fresh log, everything replayed goes into the snapshot
+	if err := s.snapshot(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// snapshot - write every order into a new snapshot and truncate the log
+// must be called with the write lock held (or before the store is shared)
+func (s *FileOrderStore) snapshot() error {
+	orders := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		orders = append(orders, order)
+	}
+	log, err := compact(filepath.Join(s.dir, orderSnapshotFile), filepath.Join(s.dir, orderLogFile), orders, s.log)
+	if err != nil {
+		return err
+	}
+	s.log = log
+	s.entries = 0
+	return nil
+}
+
+// append an order to the log and store it in memory, must be called with the write lock held
+func (s *FileOrderStore) write(order Order) error {
+	if err := appendLog(s.log, order); err != nil {
+		return err
+	}
+	s.orders[order.ID] = order
+	s.entries++
+	if s.SnapshotEvery > 0 && s.entries >= s.SnapshotEvery {
+		// the entry is already durable in the log, a failed compaction
+		// is retried on the next write
+		_ = s.snapshot()
+	}
+	return nil
+}
+
+// Get - get an order
+func (s *FileOrderStore) Get(id string) (Order, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// Put - store an order
+func (s *FileOrderStore) Put(order Order) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.write(order)
+}
+
+// List - get every order (in no particular order)
+func (s *FileOrderStore) List() ([]Order, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		list = append(list, order)
+	}
+	return list, nil
+}
+
+// Update - modify an order while holding the store write lock
+func (s *FileOrderStore) Update(id string, fn func(*Order) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	order.History = append([]OrderTransition(nil), order.History...)
+	order.Payments = append([]PaymentRecord(nil), order.Payments...)
+	if err := fn(&order); err != nil {
+		return err
+	}
+	return s.write(order)
+}
+
+// Close - compact the log into a snapshot and release files
+func (s *FileOrderStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.snapshot(); err != nil {
+		return err
+	}
+	return s.log.Close()
+}
@@ -0,0 +1,83 @@
+package checkout
+
+import (
+	"encoding/json"
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestFileOrderStoreSurvivesReopen(t *testing.T) {
+	defer SetOrderStore(NewMemoryOrderStore())
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(NewMockPaymentGateway())
+	SetBasketStore(NewMemoryBasketStore())
+	dir := t.TempDir()
+	s, err := OpenFileOrderStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileOrderStore returned an error %s", err.Error())
+		return
+	}
+	SetOrderStore(s)
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	order, _ := b.Checkout()
+	paid, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	if err != nil {
+		t.Errorf("PayOrder returned an error %s", err.Error())
+		return
+	}
+	// simulate a crash, log is not compacted
+	s.log.Close()
+
+	s, err = OpenFileOrderStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileOrderStore returned an error %s", err.Error())
+		return
+	}
+	defer s.Close()
+	list, _ := s.List()
+	if len(list) != 1 {
+		t.Errorf("wrong number of orders expected 1 got %d", len(list))
+		return
+	}
+	restored, err := s.Get(order.ID)
+	if err != nil {
+		t.Errorf("Get returned an error %s", err.Error())
+		return
+	}
+	expected, _ := json.Marshal(paid)
+	got, _ := json.Marshal(restored)
+	if string(expected) != string(got) {
+		t.Errorf("wrong restored order expected %s got %s", expected, got)
+		return
+	}
+	if _, err := s.Get("lala"); err != ErrOrderNotFound {
+		t.Errorf("expected %v got %v", ErrOrderNotFound, err)
+	}
+}
+
+func TestFileOrderStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFileOrderStore(dir)
+	s.SnapshotEvery = 2
+	_ = s.Put(Order{ID: "1", State: PendingPayment})
+	_ = s.Update("1", func(order *Order) error {
+		order.State = Cancelled
+		return nil
+	})
+	_ = s.Put(Order{ID: "2", State: PendingPayment})
+	s.Close()
+	s, err := OpenFileOrderStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileOrderStore returned an error %s", err.Error())
+		return
+	}
+	defer s.Close()
+	if order, _ := s.Get("1"); order.State != Cancelled {
+		t.Errorf("wrong state expected %s got %s", Cancelled, order.State)
+		return
+	}
+	if _, err := s.Get("2"); err != nil {
+		t.Errorf("Get returned an error %s", err.Error())
+	}
+}
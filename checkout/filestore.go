@@ -0,0 +1,343 @@
+package checkout
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "baskets.snapshot"
+	logFile      = "baskets.log"
+	// DefaultSnapshotEvery - log entries written before compacting into a snapshot
+	DefaultSnapshotEvery = 1000
+)
+
+// one line of the write-ahead log, baskets are logged whole so replaying
+// an entry more than once is harmless
+type logEntry struct {
+	Op     string      `json:"op"`
+	ID     string      `json:"id"`
+	Basket *BasketData `json:"basket,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// FileBasketStore - BasketStore persisted on disk
+// every mutation is appended (and synced) to a log before being applied in memory
+// and the log is periodically compacted into a snapshot. On open the snapshot is
+// loaded and the log replayed.
+// Promotions are rebuilt from the rules pinned by each basket, baskets without them
+// get the currently active promotions.
+type FileBasketStore struct {
+	lock    sync.RWMutex
+	dir     string
+	baskets map[string]BasketData
+	log     *os.File
+	entries int
+	// SnapshotEvery - log entries written before compacting, 0 disables compaction
+	SnapshotEvery int
+}
+
+// OpenFileBasketStore - opens (creating if needed) a store in dir and replays its contents
+func OpenFileBasketStore(dir string) (*FileBasketStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileBasketStore{
+		dir:           dir,
+		baskets:       make(map[string]BasketData),
+		SnapshotEvery: DefaultSnapshotEvery,
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+	// start with a fresh log, everything replayed goes into the snapshot
+	if err := s.snapshot(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read the snapshot at path into v, v is left untouched when there is none
+func readSnapshot(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("corrupted snapshot: %s", err.Error())
+	}
+	return nil
+}
+
+// decode every entry of the log at path into a new value got from entry and pass it to apply
+func readLog(path string, entry func() interface{}, apply func(interface{})) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	// every entry ends with a new line, anything after the last one is an
+	// incomplete write interrupted by a crash and is discarded
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		v := entry()
+		if err := json.Unmarshal(line, v); err != nil {
+			return fmt.Errorf("corrupted log at line %d: %s", i+1, err.Error())
+		}
+		apply(v)
+	}
+	return nil
+}
+
+func (s *FileBasketStore) loadSnapshot() error {
+	var baskets []BasketData
+	if err := readSnapshot(filepath.Join(s.dir, snapshotFile), &baskets); err != nil {
+		return err
+	}
+	for _, basket := range baskets {
+		s.baskets[basket.ID] = restoreBasket(basket)
+	}
+	return nil
+}
+
+func (s *FileBasketStore) replayLog() error {
+	return readLog(filepath.Join(s.dir, logFile), func() interface{} { return &logEntry{} }, func(v interface{}) {
+		s.replay(*v.(*logEntry))
+	})
+}
+
+func restoreBasket(basket BasketData) BasketData {
+	if basket.Items == nil {
+		basket.Items = make(map[string]int64)
+	}
+	if basket.PromotionRules != nil {
+		basket.Promotions = pinnedPromotions(basket.PromotionRules)
+		return basket
+	}
+	// stored before pinned rules were kept, they get the current set
+	var rules []PromotionRule
+	basket.Promotions, rules, basket.PromotionsVersion = currentPromotions()
+	if basket.promotionPolicy() == PinnedPromotions {
+		basket.PromotionRules = rules
+	}
+	return basket
+}
+
+func (s *FileBasketStore) replay(entry logEntry) {
+	switch entry.Op {
+	case opPut:
+		if entry.Basket != nil {
+			s.baskets[entry.ID] = restoreBasket(*entry.Basket)
+		}
+	case opDelete:
+		delete(s.baskets, entry.ID)
+	}
+}
+
+// snapshot - write every basket into a new snapshot and truncate the log
+// must be called with the write lock held (or before the store is shared)
+func (s *FileBasketStore) snapshot() error {
+	baskets := make([]BasketData, 0, len(s.baskets))
+	for _, basket := range s.baskets {
+		baskets = append(baskets, basket)
+	}
+	log, err := compact(filepath.Join(s.dir, snapshotFile), filepath.Join(s.dir, logFile), baskets, s.log)
+	if err != nil {
+		return err
+	}
+	s.log = log
+	s.entries = 0
+	return nil
+}
+
+// write v into a new snapshot at path and replace the log at logPath (old) by an empty one
+func compact(path, logPath string, v interface{}, old *os.File) (*os.File, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return nil, err
+	}
+	// rename is atomic, a crash before truncating the log only means
+	// some entries get replayed again on top of the snapshot
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	// the old log is kept until the new one is open so writes can go on if it fails, its
+	// entries are already in the snapshot and replaying them again is harmless
+	log, err := os.OpenFile(logPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		old.Close()
+	}
+	return log, nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// append v as a line of log and sync it
+func appendLog(log *os.File, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	offset, err := log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := log.Write(append(data, '\n')); err != nil {
+		// drop any partial entry so following writes don't land after garbage
+		_ = log.Truncate(offset)
+		return err
+	}
+	return log.Sync()
+}
+
+// truncate log after its last complete entry, anything after it is an incomplete write
+// interrupted by a crash
+func dropIncompleteEntry(log *os.File) error {
+	data, err := ioutil.ReadFile(log.Name())
+	if err != nil {
+		return err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end == len(data) {
+		return nil
+	}
+	return log.Truncate(int64(end))
+}
+
+// append an entry to the log and apply it in memory, must be called with the write lock held
+func (s *FileBasketStore) write(entry logEntry) error {
+	if err := appendLog(s.log, entry); err != nil {
+		return err
+	}
+	if entry.Op == opDelete {
+		delete(s.baskets, entry.ID)
+	} else {
+		s.baskets[entry.ID] = *entry.Basket
+	}
+	s.entries++
+	if s.SnapshotEvery > 0 && s.entries >= s.SnapshotEvery {
+		// the entry is already durable in the log, a failed compaction
+		// is retried on the next write
+		_ = s.snapshot()
+	}
+	return nil
+}
+
+// Get - get a copy of a basket
+func (s *FileBasketStore) Get(id string) (BasketData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return BasketData{}, ErrBasketNotFound
+	}
+	return data.copy(), nil
+}
+
+// Put - store a basket
+func (s *FileBasketStore) Put(data BasketData) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data = data.copy()
+	return s.write(logEntry{Op: opPut, ID: data.ID, Basket: &data})
+}
+
+// Delete - remove a basket
+func (s *FileBasketStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.baskets[id]; !ok {
+		return ErrBasketNotFound
+	}
+	return s.write(logEntry{Op: opDelete, ID: id})
+}
+
+// DeleteIf - remove a basket if fn allows it while holding the store write lock
+func (s *FileBasketStore) DeleteIf(id string, fn func(BasketData) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return ErrBasketNotFound
+	}
+	if err := fn(data.copy()); err != nil {
+		return err
+	}
+	return s.write(logEntry{Op: opDelete, ID: id})
+}
+
+// List - get a copy of every basket (in no particular order)
+func (s *FileBasketStore) List() ([]BasketData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]BasketData, 0, len(s.baskets))
+	for _, data := range s.baskets {
+		list = append(list, data.copy())
+	}
+	return list, nil
+}
+
+// Update - modify a basket while holding the store write lock
+func (s *FileBasketStore) Update(id string, fn func(*BasketData) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return ErrBasketNotFound
+	}
+	data = data.copy()
+	if err := fn(&data); err != nil {
+		return err
+	}
+	return s.write(logEntry{Op: opPut, ID: id, Basket: &data})
+}
+
+// Close - compact the log into a snapshot and release files
+func (s *FileBasketStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.snapshot(); err != nil {
+		return err
+	}
+	return s.log.Close()
+}
@@ -0,0 +1,209 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileBasketStoreSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileBasketStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileBasketStore returned an error %s", err.Error())
+		return
+	}
+	_ = s.Put(BasketData{ID: "1", Items: map[string]int64{}, PromotionPolicy: LivePromotions})
+	_ = s.Put(BasketData{ID: "2", Items: map[string]int64{}})
+	_ = s.Update("1", func(data *BasketData) error {
+		data.Items[merchandise.PEN] = 3
+		return nil
+	})
+	_ = s.Delete("2")
+	_ = s.Put(BasketData{ID: "3", Items: map[string]int64{}})
+	_ = s.DeleteIf("3", func(BasketData) error { return nil })
+	// simulate a crash, log is not compacted
+	s.log.Close()
+
+	s, err = OpenFileBasketStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileBasketStore returned an error %s", err.Error())
+		return
+	}
+	defer s.Close()
+	list, _ := s.List()
+	if len(list) != 1 {
+		t.Errorf("wrong number of baskets expected 1 got %d", len(list))
+		return
+	}
+	data, err := s.Get("1")
+	if err != nil {
+		t.Errorf("Get returned an error %s", err.Error())
+		return
+	}
+	if data.Items[merchandise.PEN] != 3 {
+		t.Errorf("wrong number of items expected 3 got %d", data.Items[merchandise.PEN])
+	}
+	if len(data.Promotions) != len(defaultPromotions()) || data.PromotionsVersion != PromotionsVersion() {
+		t.Errorf("restored basket should get default promotions")
+	}
+	if data.PromotionPolicy != LivePromotions {
+		t.Errorf("promotion policy should be restored got %q", data.PromotionPolicy)
+	}
+}
+
+func TestFileBasketStoreKeepsPinnedPromotions(t *testing.T) {
+	defer SetBasketStore(NewMemoryBasketStore())
+	defer resetPromotions(mugBuy3Get1)()
+	dir := t.TempDir()
+	s, _ := OpenFileBasketStore(dir)
+	SetBasketStore(s)
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 3})
+	pinned, _ := b.GetPromotions()
+	s.Close()
+
+	// the promotion changes while the server is down
+	changed := mugBuy3Get1
+	changed.GetFreeQuantity = 2
+	if _, err := UpdatePromotion(changed.ID, ManagedPromotion{PromotionRule: changed, Enabled: true}); err != nil {
+		t.Errorf("UpdatePromotion returned an error %s", err.Error())
+		return
+	}
+	s, err := OpenFileBasketStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileBasketStore returned an error %s", err.Error())
+		return
+	}
+	defer s.Close()
+	SetBasketStore(s)
+	if promotions, _ := b.GetPromotions(); promotions != pinned {
+		t.Errorf("pinned promotions should be restored expected %v got %v", pinned, promotions)
+		return
+	}
+	if total, _ := b.GetTotal(); total != merchandise.Cents(1500) {
+		t.Errorf("wrong total expected 15.00 got %s", total)
+	}
+}
+
+func TestFileBasketStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFileBasketStore(dir)
+	s.SnapshotEvery = 2
+	_ = s.Put(BasketData{ID: "1", Items: map[string]int64{}})
+	_ = s.Put(BasketData{ID: "2", Items: map[string]int64{}})
+	// second write triggers compaction so the log must be empty
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Errorf("log file missing %s", err.Error())
+		return
+	}
+	if info.Size() != 0 {
+		t.Errorf("log should be empty after snapshot but has %d bytes", info.Size())
+		return
+	}
+	_ = s.Put(BasketData{ID: "3", Items: map[string]int64{}})
+	_ = s.Close()
+
+	s, _ = OpenFileBasketStore(dir)
+	defer s.Close()
+	list, _ := s.List()
+	if len(list) != 3 {
+		t.Errorf("wrong number of baskets expected 3 got %d", len(list))
+	}
+}
+
+func TestFileBasketStoreFailedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFileBasketStore(dir)
+	defer s.Close()
+	s.SnapshotEvery = 1
+	// the new log can't be opened, the old one must be kept
+	_ = os.Remove(filepath.Join(dir, logFile))
+	_ = os.Mkdir(filepath.Join(dir, logFile), 0755)
+	for _, id := range []string{"1", "2"} {
+		if err := s.Put(BasketData{ID: id, Items: map[string]int64{}}); err != nil {
+			t.Errorf("writes should go on after a failed snapshot got %s", err.Error())
+			return
+		}
+	}
+}
+
+func TestFileBasketStoreIgnoresTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFileBasketStore(dir)
+	_ = s.Put(BasketData{ID: "1", Items: map[string]int64{}})
+	// half written entry at the end of the log
+	_, _ = s.log.Write([]byte("{\"op\":\"put\",\"id\":\"2\",\"bas"))
+	s.log.Close()
+
+	s, err := OpenFileBasketStore(dir)
+	if err != nil {
+		t.Errorf("OpenFileBasketStore returned an error %s", err.Error())
+		return
+	}
+	defer s.Close()
+	list, _ := s.List()
+	if len(list) != 1 || list[0].ID != "1" {
+		t.Errorf("only basket 1 should have been restored got %v", list)
+	}
+}
+
+func TestFileBasketStoreCorruptedLog(t *testing.T) {
+	dir := t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(dir, logFile), []byte("garbage\n{\"op\":\"delete\",\"id\":\"1\"}\n"), 0644)
+	_, err := OpenFileBasketStore(dir)
+	if err == nil {
+		t.Errorf("OpenFileBasketStore should have returned an error")
+	}
+}
+
+func TestFileBasketStoreCorruptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(dir, snapshotFile), []byte("garbage"), 0644)
+	_, err := OpenFileBasketStore(dir)
+	if err == nil {
+		t.Errorf("OpenFileBasketStore should have returned an error")
+	}
+}
+
+func TestFileBasketStoreNotFound(t *testing.T) {
+	s, _ := OpenFileBasketStore(t.TempDir())
+	defer s.Close()
+	if _, err := s.Get("1"); err != ErrBasketNotFound {
+		t.Errorf("Get should have returned ErrBasketNotFound got %v", err)
+	}
+	if err := s.Delete("1"); err != ErrBasketNotFound {
+		t.Errorf("Delete should have returned ErrBasketNotFound got %v", err)
+	}
+	err := s.Update("1", func(*BasketData) error { return nil })
+	if err != ErrBasketNotFound {
+		t.Errorf("Update should have returned ErrBasketNotFound got %v", err)
+	}
+}
+
+func TestFileBasketStoreWithBaskets(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenFileBasketStore(dir)
+	SetBasketStore(s)
+	defer SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	_ = s.Close()
+
+	s, _ = OpenFileBasketStore(dir)
+	defer s.Close()
+	SetBasketStore(s)
+	b, err := GetBasket(b.GetID())
+	if err != nil {
+		t.Errorf("GetBasket returned an error %s", err.Error())
+		return
+	}
+	total, _ := b.GetTotal()
+	expected := merchandise.Cents(1000)
+	if total != expected {
+		t.Errorf("invalid total expected %s got %s", expected, total)
+	}
+}
@@ -0,0 +1,222 @@
+package checkout
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader - header carrying the key that identifies retries of a request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyRetention - how long responses are kept for replay unless changed
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// MaxIdempotentBodySize - bytes of body read to fingerprint requests with an idempotency key,
+// bigger requests get a 413
+const MaxIdempotentBodySize = 1 << 20
+
+// errors returned for requests repeating a key
+var (
+	ErrIdempotencyKeyReused = fmt.Errorf("Idempotency key was used for a different request")
+	ErrIdempotencyKeyInUse  = fmt.Errorf("A request with the same idempotency key is in progress")
+)
+
+// headers of a response replayed along its status and body
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// response stored for a key, done is false while the first request is being handled
+type idempotentResponse struct {
+	fingerprint string
+	at          time.Time
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// responses by idempotency key, expired ones are dropped at most once a minute
+type idempotencyCache struct {
+	lock      sync.Mutex
+	retention time.Duration
+	purged    time.Time
+	responses map[string]idempotentResponse
+}
+
+var idempotency = &idempotencyCache{
+	retention: DefaultIdempotencyRetention,
+	responses: make(map[string]idempotentResponse),
+}
+
+// SetIdempotencyRetention - change how long responses are kept for replay (0 disables replays)
+func SetIdempotencyRetention(retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("retention can't be negative")
+	}
+	idempotency.lock.Lock()
+	defer idempotency.lock.Unlock()
+	idempotency.retention = retention
+	return nil
+}
+
+// forget every stored response
+func resetIdempotency() {
+	idempotency.lock.Lock()
+	defer idempotency.lock.Unlock()
+	idempotency.responses = make(map[string]idempotentResponse)
+}
+
+// stored response for key, when there is none the key is reserved for the request and
+// found is false. Keys repeated with a different request or while the first one is being
+// handled return an error
+func (cache *idempotencyCache) reserve(key, fingerprint string) (response idempotentResponse, found bool, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	t := now()
+	if t.Sub(cache.purged) >= time.Minute {
+		for k, r := range cache.responses {
+			if r.done && t.Sub(r.at) >= cache.retention {
+				delete(cache.responses, k)
+			}
+		}
+		cache.purged = t
+	}
+	response, found = cache.responses[key]
+	if found && response.done && t.Sub(response.at) >= cache.retention {
+		found = false
+	}
+	if !found {
+		cache.responses[key] = idempotentResponse{fingerprint: fingerprint, at: t}
+		return idempotentResponse{}, false, nil
+	}
+	if response.fingerprint != fingerprint {
+		return idempotentResponse{}, false, ErrIdempotencyKeyReused
+	}
+	if !response.done {
+		return idempotentResponse{}, false, ErrIdempotencyKeyInUse
+	}
+	return response, true, nil
+}
+
+// store the response of the request that reserved key
+func (cache *idempotencyCache) complete(key string, response idempotentResponse) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	response.at = now()
+	response.done = true
+	cache.responses[key] = response
+}
+
+// release key so the request can be retried
+func (cache *idempotencyCache) release(key string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.responses, key)
+}
+
+// keys are scoped to the caller so keys of different callers never clash
+func idempotencyKey(caller Caller, key string) string {
+	return fmt.Sprintf("%s:%t:%s", caller.CustomerID, caller.Admin, key)
+}
+
+// response writer keeping a copy of the body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent - middleware for requests that must not be applied twice (i.e. adding items).
+// Requests with an Idempotency-Key header get the response of the first request with the
+// same key while it is retained (flagged with an Idempotent-Replayed header). Reusing a key
+// for a different request (method, path or body) or while the first one is in progress is a
+// conflict. Internal errors are not stored so the request can be retried
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			http.Error(c.Writer, "Invalid idempotency key", http.StatusBadRequest)
+			c.Abort()
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxIdempotentBodySize))
+		if err != nil && len(body) == MaxIdempotentBodySize {
+			http.Error(c.Writer, "Request body too large", http.StatusRequestEntityTooLarge)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		key = idempotencyKey(callerOf(c), key)
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		stored, found, err := idempotency.reserve(key, fingerprint)
+		if err != nil {
+			abort(c, err)
+			c.Abort()
+			return
+		}
+		if found {
+			for name, values := range stored.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Status(stored.status)
+			_, _ = c.Writer.Write(stored.body)
+			c.Abort()
+			return
+		}
+
+		completed := false
+		defer func() {
+			// handler panicked or failed
+			if !completed {
+				idempotency.release(key)
+			}
+		}()
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		if writer.Status() == http.StatusInternalServerError {
+			return
+		}
+		completed = true
+		header := make(http.Header)
+		for _, name := range replayedHeaders {
+			if values := writer.Header().Values(name); len(values) > 0 {
+				header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		idempotency.complete(key, idempotentResponse{
+			fingerprint: fingerprint,
+			status:      writer.Status(),
+			header:      header,
+			body:        writer.body.Bytes(),
+		})
+	}
+}
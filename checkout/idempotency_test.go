@@ -0,0 +1,168 @@
+package checkout
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(method, path, body, key string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	getRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotentCreateBasket(t *testing.T) {
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	resetIdempotency()
+	first := idempotentRequest("POST", "/api/v1/basket/", "", "create-1")
+	second := idempotentRequest("POST", "/api/v1/basket/", "", "create-1")
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Errorf("wrong http status expected %d got %d and %d", http.StatusCreated, first.Code, second.Code)
+		return
+	}
+	if first.Body.String() != second.Body.String() || first.Header().Get("Location") != second.Header().Get("Location") {
+		t.Errorf("retry should get the same response %s %s", first.Body.String(), second.Body.String())
+		return
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("only replayed responses should be flagged")
+		return
+	}
+	if baskets, _ := ListBaskets(); len(baskets) != 1 {
+		t.Errorf("retry should not create a basket got %d baskets", len(baskets))
+		return
+	}
+	// without key every request creates a basket
+	_ = idempotentRequest("POST", "/api/v1/basket/", "", "")
+	_ = idempotentRequest("POST", "/api/v1/basket/", "", "")
+	if baskets, _ := ListBaskets(); len(baskets) != 3 {
+		t.Errorf("requests without key should create a basket each got %d baskets", len(baskets))
+		return
+	}
+}
+
+func TestIdempotentAddProduct(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	resetIdempotency()
+	basket, _ := NewBasket()
+	path := "/api/v1/basket/" + basket.GetID()
+	payload := "{\"product\":\"PEN\",\"count\":1}"
+	for i := 0; i < 3; i++ {
+		w := idempotentRequest("POST", path, payload, "add-1")
+		if w.Code != http.StatusCreated || w.Body.String() != "{\"count\":1}" || w.Header().Get("ETag") != "\"2\"" {
+			t.Errorf("retry %d wrong response %d %s %s", i, w.Code, w.Body.String(), w.Header().Get("ETag"))
+			return
+		}
+	}
+	if items, _ := basket.GetItems(); len(items) != 1 || items[0].Count != 1 {
+		t.Errorf("retries should not add items %v", items)
+		return
+	}
+	// a different key is a different request
+	if w := idempotentRequest("POST", path, payload, "add-2"); w.Body.String() != "{\"count\":2}" {
+		t.Errorf("new key should add items got %s", w.Body.String())
+		return
+	}
+	// same key for a different payload or path is a conflict
+	if w := idempotentRequest("POST", path, "{\"product\":\"PEN\",\"count\":5}", "add-1"); w.Code != http.StatusConflict {
+		t.Errorf("reused key wrong http status expected %d got %d", http.StatusConflict, w.Code)
+		return
+	}
+	if w := idempotentRequest("POST", path+"/checkout", "", "add-1"); w.Code != http.StatusConflict {
+		t.Errorf("reused key wrong http status expected %d got %d", http.StatusConflict, w.Code)
+		return
+	}
+	// once the retention is over the key can be used again
+	clock.t = clock.t.Add(DefaultIdempotencyRetention)
+	if w := idempotentRequest("POST", path, "{\"product\":\"PEN\",\"count\":5}", "add-1"); w.Body.String() != "{\"count\":7}" {
+		t.Errorf("expired key should be accepted got %d %s", w.Code, w.Body.String())
+		return
+	}
+}
+
+func TestIdempotentRejectedRequests(t *testing.T) {
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	resetIdempotency()
+	basket, _ := NewBasket()
+	path := "/api/v1/basket/" + basket.GetID()
+	// the outcome of rejected requests is replayed too
+	if w := idempotentRequest("POST", path+"/checkout", "", "checkout-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("empty basket checkout wrong http status expected %d got %d", http.StatusBadRequest, w.Code)
+		return
+	}
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 1})
+	if w := idempotentRequest("POST", path+"/checkout", "", "checkout-1"); w.Code != http.StatusBadRequest || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("rejected request should be replayed got %d", w.Code)
+		return
+	}
+	// a request in progress can't be repeated
+	_, _, _ = idempotency.reserve(idempotencyKey(Caller{}, "checkout-2"), "in progress")
+	if w := idempotentRequest("POST", path+"/checkout", "", "checkout-2"); w.Code != http.StatusConflict {
+		t.Errorf("key in use wrong http status expected %d got %d", http.StatusConflict, w.Code)
+		return
+	}
+	if w := idempotentRequest("POST", path+"/checkout", "", strings.Repeat("k", 256)); w.Code != http.StatusBadRequest {
+		t.Errorf("long key wrong http status expected %d got %d", http.StatusBadRequest, w.Code)
+		return
+	}
+	if w := idempotentRequest("POST", path+"/checkout", strings.Repeat(" ", MaxIdempotentBodySize+1), "checkout-3"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("big body wrong http status expected %d got %d", http.StatusRequestEntityTooLarge, w.Code)
+		return
+	}
+}
+
+func TestIdempotentCheckout(t *testing.T) {
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	resetIdempotency()
+	SetOrderStore(NewMemoryOrderStore())
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: "PEN", Count: 1})
+	path := "/api/v1/basket/" + basket.GetID() + "/checkout"
+	first := idempotentRequest("POST", path, "", "checkout-1")
+	second := idempotentRequest("POST", path, "", "checkout-1")
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Errorf("wrong http status expected %d got %d and %d", http.StatusCreated, first.Code, second.Code)
+		return
+	}
+	var order1, order2 Order
+	_ = json.Unmarshal(first.Body.Bytes(), &order1)
+	_ = json.Unmarshal(second.Body.Bytes(), &order2)
+	if order1.ID == "" || order1.ID != order2.ID {
+		t.Errorf("retry should get the same order %s %s", order1.ID, order2.ID)
+		return
+	}
+	if list, _ := ListOrders(); len(list) != 1 {
+		t.Errorf("retry should not create orders got %d", len(list))
+		return
+	}
+}
+
+func TestSetIdempotencyRetention(t *testing.T) {
+	defer func() { _ = SetIdempotencyRetention(DefaultIdempotencyRetention) }()
+	if err := SetIdempotencyRetention(-time.Second); err == nil {
+		t.Errorf("An error was expected")
+		return
+	}
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	resetIdempotency()
+	_ = SetIdempotencyRetention(0)
+	_ = idempotentRequest("POST", "/api/v1/basket/", "", "create-1")
+	_ = idempotentRequest("POST", "/api/v1/basket/", "", "create-1")
+	if baskets, _ := ListBaskets(); len(baskets) != 2 {
+		t.Errorf("responses should not be replayed without retention got %d baskets", len(baskets))
+		return
+	}
+}
@@ -0,0 +1,74 @@
+package checkout
+
+import (
+	"fmt"
+	"time"
+)
+
+// OrderState - state of an order in its lifecycle
+type OrderState string
+
+// order states
+const (
+	PendingPayment OrderState = "pending_payment"
+	Paid           OrderState = "paid"
+	Fulfilled      OrderState = "fulfilled"
+	Cancelled      OrderState = "cancelled"
+	Refunded       OrderState = "refunded"
+)
+
+// OrderTransition - model, moment an order entered a state
+type OrderTransition struct {
+	State OrderState `json:"state"`
+	At    time.Time  `json:"at"`
+}
+
+// valid transitions, cancelled and refunded are final states
+// paid orders are not cancelled but refunded
+var orderTransitions = map[OrderState][]OrderState{
+	PendingPayment: {Paid, Cancelled},
+	Paid:           {Fulfilled, Refunded},
+	Fulfilled:      {Refunded},
+}
+
+// TransitionError - returned when an order can't move from its current state to the requested one
+type TransitionError struct {
+	From OrderState
+	To   OrderState
+}
+
+func (err TransitionError) Error() string {
+	return fmt.Sprintf("Order can't go from %s to %s", err.From, err.To)
+}
+
+// CanTransition - true if an order in state from can move to state to
+func CanTransition(from, to OrderState) bool {
+	for _, state := range orderTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// moves the order to a new state recording when it happened
+func (order *Order) transition(to OrderState) error {
+	if !CanTransition(order.State, to) {
+		return TransitionError{From: order.State, To: to}
+	}
+	order.State = to
+	order.History = append(order.History, OrderTransition{State: to, At: now().UTC()})
+	return nil
+}
+
+// TransitionOrder - move an order to a new state, TransitionError if it is not allowed
+func TransitionOrder(id string, to OrderState) (order Order, err error) {
+	err = orders.Update(id, func(o *Order) error {
+		if err := o.transition(to); err != nil {
+			return err
+		}
+		order = *o
+		return nil
+	})
+	return
+}
@@ -0,0 +1,98 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func newTestOrder() Order {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	order, _ := b.Checkout()
+	return order
+}
+
+func TestNewOrderIsPendingPayment(t *testing.T) {
+	order := newTestOrder()
+	if order.State != PendingPayment {
+		t.Errorf("wrong state expected %s got %s", PendingPayment, order.State)
+		return
+	}
+	if len(order.History) != 1 || order.History[0].State != PendingPayment || order.History[0].At.IsZero() {
+		t.Errorf("wrong history %v", order.History)
+	}
+}
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from    OrderState
+		to      OrderState
+		allowed bool
+	}{
+		{PendingPayment, Paid, true},
+		{PendingPayment, Cancelled, true},
+		{PendingPayment, Fulfilled, false},
+		{PendingPayment, Refunded, false},
+		{Paid, Fulfilled, true},
+		{Paid, Refunded, true},
+		{Paid, Cancelled, false},
+		{Paid, Paid, false},
+		{Fulfilled, Refunded, true},
+		{Fulfilled, Cancelled, false},
+		{Cancelled, Paid, false},
+		{Refunded, Paid, false},
+	}
+	for _, tc := range cases {
+		if CanTransition(tc.from, tc.to) != tc.allowed {
+			t.Errorf("transition from %s to %s allowed should be %t", tc.from, tc.to, tc.allowed)
+		}
+	}
+}
+
+func TestTransitionOrder(t *testing.T) {
+	order := newTestOrder()
+	for _, state := range []OrderState{Paid, Fulfilled, Refunded} {
+		updated, err := TransitionOrder(order.ID, state)
+		if err != nil {
+			t.Errorf("TransitionOrder returned an error %s", err.Error())
+			return
+		}
+		if updated.State != state {
+			t.Errorf("wrong state expected %s got %s", state, updated.State)
+			return
+		}
+	}
+	stored, _ := GetOrder(order.ID)
+	if len(stored.History) != 4 {
+		t.Errorf("wrong history length expected 4 got %d", len(stored.History))
+		return
+	}
+	for i := 1; i < len(stored.History); i++ {
+		if stored.History[i].At.Before(stored.History[i-1].At) {
+			t.Errorf("history is not in chronological order")
+		}
+	}
+}
+
+func TestTransitionOrderErrors(t *testing.T) {
+	order := newTestOrder()
+	_, _ = TransitionOrder(order.ID, Cancelled)
+	_, err := TransitionOrder(order.ID, Paid)
+	expected := TransitionError{From: Cancelled, To: Paid}
+	if err != expected {
+		t.Errorf("TransitionOrder should have returned %v got %v", expected, err)
+		return
+	}
+	if err.Error() != "Order can't go from cancelled to paid" {
+		t.Errorf("wrong error message %s", err.Error())
+	}
+	stored, _ := GetOrder(order.ID)
+	if stored.State != Cancelled || len(stored.History) != 2 {
+		t.Errorf("failed transition should not change the order %v", stored)
+	}
+	if _, err := TransitionOrder("1234", Paid); err != ErrOrderNotFound {
+		t.Errorf("TransitionOrder should have returned ErrOrderNotFound got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package checkout
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize and MaxPageSize - baskets returned per page when no limit is given and
+// the highest limit accepted
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// BasketQuery - DTO, filters and page of a basket listing. Baskets are sorted by creation
+// time, Cursor is the next_cursor of the previous page (empty for the first one) and the
+// filters should not change between pages. Empty filters by baskets with or without items,
+// Product by baskets containing it and CreatedAfter by creation time (exclusive).
+// With Summary the item count and total of every basket is included
+type BasketQuery struct {
+	Limit        int       `form:"limit"`
+	Cursor       string    `form:"cursor"`
+	Empty        *bool     `form:"empty"`
+	Product      string    `form:"product"`
+	CreatedAfter time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	Owner        string    `form:"owner"`
+	Summary      bool      `form:"summary"`
+}
+
+// BasketSummary - DTO, basket entry of a listing, ItemCount and Total are only set when
+// the summary is requested
+type BasketSummary struct {
+	ID        string             `json:"id"`
+	CreatedAt time.Time          `json:"created_at"`
+	Owner     string             `json:"owner,omitempty"`
+	ItemCount *int64             `json:"item_count,omitempty"`
+	Total     *merchandise.Money `json:"total,omitempty"`
+}
+
+// BasketPage - DTO, a page of baskets, NextCursor is empty on the last page
+type BasketPage struct {
+	Baskets    []BasketSummary `json:"baskets"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// position of a basket in the listing order, creation time and id for baskets created
+// at the same time
+type basketCursor struct {
+	createdAt time.Time
+	id        string
+}
+
+func (cursor basketCursor) before(basket BasketData) bool {
+	if !cursor.createdAt.Equal(basket.CreatedAt) {
+		return cursor.createdAt.Before(basket.CreatedAt)
+	}
+	return cursor.id < basket.ID
+}
+
+func (cursor basketCursor) String() string {
+	value := strconv.FormatInt(cursor.createdAt.UnixNano(), 10) + ":" + cursor.id
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func parseBasketCursor(value string) (basketCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return basketCursor{}, fmt.Errorf("Invalid cursor")
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return basketCursor{}, fmt.Errorf("Invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return basketCursor{}, fmt.Errorf("Invalid cursor")
+	}
+	return basketCursor{createdAt: time.Unix(0, nanos).UTC(), id: parts[1]}, nil
+}
+
+func (query BasketQuery) matches(basket BasketData) bool {
+	if query.Empty != nil && *query.Empty != (len(basket.Items) == 0) {
+		return false
+	}
+	if query.Product != "" && basket.Items[query.Product] == 0 {
+		return false
+	}
+	if !query.CreatedAfter.IsZero() && !basket.CreatedAt.After(query.CreatedAfter) {
+		return false
+	}
+	return query.Owner == "" || query.Owner == basket.Owner
+}
+
+func (data BasketData) summary(full bool) (BasketSummary, error) {
+	summary := BasketSummary{ID: data.ID, CreatedAt: data.CreatedAt, Owner: data.Owner}
+	if !full {
+		return summary, nil
+	}
+	count := int64(0)
+	for _, n := range data.Items {
+		count += n
+	}
+	summary.ItemCount = &count
+	items, err := data.items()
+	if err == ErrProductUnavailable {
+		// products removed from the catalog can't be priced, the total is left out
+		// so the rest of the listing still works
+		return summary, nil
+	}
+	if err != nil {
+		return BasketSummary{}, err
+	}
+	receipt, err := computeReceipt(items, data.promotions())
+	if err != nil {
+		return BasketSummary{}, err
+	}
+	summary.Total = &receipt.Total
+	return summary, nil
+}
+
+// sort baskets in listing order
+func sortBaskets(baskets []BasketData) {
+	sort.Slice(baskets, func(i, j int) bool {
+		return basketCursor{createdAt: baskets[i].CreatedAt, id: baskets[i].ID}.before(baskets[j])
+	})
+}
+
+// QueryBaskets - a page of the baskets matching query sorted by creation time, expired
+// baskets are left out
+func QueryBaskets(query BasketQuery) (BasketPage, error) {
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultPageSize
+	}
+	if limit < 0 || limit > MaxPageSize {
+		return BasketPage{}, fmt.Errorf("Invalid limit")
+	}
+	var after *basketCursor
+	if query.Cursor != "" {
+		cursor, err := parseBasketCursor(query.Cursor)
+		if err != nil {
+			return BasketPage{}, err
+		}
+		after = &cursor
+	}
+	baskets, err := store.List()
+	if err != nil {
+		return BasketPage{}, err
+	}
+	sortBaskets(baskets)
+	t := now()
+	page := BasketPage{Baskets: make([]BasketSummary, 0)}
+	for _, basket := range baskets {
+		if basket.expired(t) || (after != nil && !after.before(basket)) || !query.matches(basket) {
+			continue
+		}
+		if len(page.Baskets) == limit {
+			last := page.Baskets[limit-1]
+			page.NextCursor = basketCursor{createdAt: last.CreatedAt, id: last.ID}.String()
+			break
+		}
+		summary, err := basket.summary(query.Summary)
+		if err != nil {
+			return BasketPage{}, err
+		}
+		page.Baskets = append(page.Baskets, summary)
+	}
+	return page, nil
+}
@@ -0,0 +1,174 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+	"time"
+)
+
+func pageIDs(page BasketPage) []string {
+	ids := make([]string, len(page.Baskets))
+	for i, basket := range page.Baskets {
+		ids[i] = basket.ID
+	}
+	return ids
+}
+
+func TestQueryBasketsPages(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	ids := make([]string, 5)
+	for i := range ids {
+		b, _ := NewBasket()
+		ids[i] = b.GetID()
+		clock.t = clock.t.Add(time.Minute)
+	}
+	var got []string
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		page, err := QueryBaskets(BasketQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Errorf("QueryBaskets returned an error %s", err.Error())
+			return
+		}
+		got = append(got, pageIDs(page)...)
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	if cursor != "" || len(got) != len(ids) {
+		t.Errorf("wrong baskets listed expected %v got %v (next %q)", ids, got, cursor)
+		return
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Errorf("baskets should be sorted by creation time expected %v got %v", ids, got)
+			return
+		}
+	}
+}
+
+func TestQueryBasketsCursorIsStable(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	// baskets created at the same time are sorted by id
+	for i := 0; i < 4; i++ {
+		_, _ = NewBasket()
+	}
+	first, _ := QueryBaskets(BasketQuery{Limit: 2})
+	// new baskets and removed ones don't move the rest of the listing
+	clock.t = clock.t.Add(time.Minute)
+	last, _ := NewBasket()
+	_ = DeleteBasket(first.Baskets[0].ID)
+	second, err := QueryBaskets(BasketQuery{Limit: 3, Cursor: first.NextCursor})
+	if err != nil {
+		t.Errorf("QueryBaskets returned an error %s", err.Error())
+		return
+	}
+	if len(second.Baskets) != 3 || second.Baskets[2].ID != last.GetID() || second.NextCursor != "" {
+		t.Errorf("wrong second page %v", second)
+		return
+	}
+	if !(first.Baskets[1].ID < second.Baskets[0].ID && second.Baskets[0].ID < second.Baskets[1].ID) {
+		t.Errorf("baskets created at the same time should be sorted by id %v %v", first, second)
+		return
+	}
+}
+
+func TestQueryBasketsFilters(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	empty, _ := NewBasket()
+	clock.t = clock.t.Add(time.Minute)
+	pens, _ := NewBasketWithOptions(BasketOptions{Owner: "alice"})
+	_, _ = pens.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	clock.t = clock.t.Add(time.Minute)
+	mugs, _ := NewBasket()
+	_, _ = mugs.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		query    BasketQuery
+		expected []string
+	}{
+		{"all", BasketQuery{}, []string{empty.GetID(), pens.GetID(), mugs.GetID()}},
+		{"empty", BasketQuery{Empty: &yes}, []string{empty.GetID()}},
+		{"not empty", BasketQuery{Empty: &no}, []string{pens.GetID(), mugs.GetID()}},
+		{"product", BasketQuery{Product: merchandise.MUG}, []string{mugs.GetID()}},
+		{"created after", BasketQuery{CreatedAfter: clock.t.Add(-time.Minute)}, []string{mugs.GetID()}},
+		{"owner", BasketQuery{Owner: "alice"}, []string{pens.GetID()}},
+		{"no match", BasketQuery{Owner: "bob"}, []string{}},
+	}
+	for _, test := range tests {
+		page, err := QueryBaskets(test.query)
+		if err != nil {
+			t.Errorf("%s: QueryBaskets returned an error %s", test.name, err.Error())
+			return
+		}
+		got := pageIDs(page)
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: expected %v got %v", test.name, test.expected, got)
+			return
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: expected %v got %v", test.name, test.expected, got)
+				return
+			}
+		}
+	}
+}
+
+func TestQueryBasketsSummary(t *testing.T) {
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	defer resetPromotions(DefaultPromotionRules...)()
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	page, _ := QueryBaskets(BasketQuery{})
+	if page.Baskets[0].ItemCount != nil || page.Baskets[0].Total != nil {
+		t.Errorf("summary fields should only be set when requested %v", page.Baskets[0])
+		return
+	}
+	page, _ = QueryBaskets(BasketQuery{Summary: true})
+	total, _ := b.GetTotal()
+	summary := page.Baskets[0]
+	if summary.ItemCount == nil || *summary.ItemCount != 3 || summary.Total == nil || *summary.Total != total {
+		t.Errorf("wrong summary %v expected 3 items and %s", summary, total)
+		return
+	}
+}
+
+func TestQueryBasketsSkipsExpired(t *testing.T) {
+	clock, stop := startExpiryTest(BasketExpiry{TTL: time.Hour})
+	defer stop()
+	_, _ = NewBasket()
+	clock.t = clock.t.Add(30 * time.Minute)
+	b, _ := NewBasket()
+	clock.t = clock.t.Add(40 * time.Minute)
+	page, _ := QueryBaskets(BasketQuery{})
+	if got := pageIDs(page); len(got) != 1 || got[0] != b.GetID() {
+		t.Errorf("expired baskets should not be listed got %v", got)
+		return
+	}
+}
+
+func TestQueryBasketsErrors(t *testing.T) {
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	tests := map[string]BasketQuery{
+		"Invalid limit":  {Limit: MaxPageSize + 1},
+		"Invalid cursor": {Cursor: "not a cursor"},
+	}
+	for expected, query := range tests {
+		if _, err := QueryBaskets(query); err == nil || err.Error() != expected {
+			t.Errorf("expected error %s got %v", expected, err)
+			return
+		}
+	}
+	if _, err := QueryBaskets(BasketQuery{Limit: -1}); err == nil {
+		t.Errorf("An error was expected")
+		return
+	}
+}
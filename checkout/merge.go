@@ -0,0 +1,172 @@
+package checkout
+
+import "fmt"
+
+// MergeRule - how the quantity of a product in both baskets is merged when an anonymous basket
+// is merged into the customer's one. Products only in one of them are kept as they are
+type MergeRule string
+
+// merge rules, MergeSum is the default
+const (
+	// MergeSum - quantities are added, up to MaxItemCount
+	MergeSum MergeRule = "sum"
+	// MergeMax - the larger quantity is kept
+	MergeMax MergeRule = "max"
+	// MergeLatest - the quantity of the basket changed last is kept
+	MergeLatest MergeRule = "latest"
+)
+
+// Login - DTO, anonymous basket used before logging in (optional) and how to merge it
+type Login struct {
+	BasketID string    `json:"basket_id"`
+	Merge    MergeRule `json:"merge"`
+}
+
+// LoginResult - DTO, customer logged in and its basket, empty when it has none
+type LoginResult struct {
+	Customer Customer `json:"customer"`
+	BasketID string   `json:"basket_id,omitempty"`
+}
+
+func (rule MergeRule) merge(current, anonymous int64, anonymousIsLatest bool) int64 {
+	switch rule {
+	case MergeMax:
+		if anonymous > current {
+			return anonymous
+		}
+		return current
+	case MergeLatest:
+		if anonymousIsLatest {
+			return anonymous
+		}
+		return current
+	}
+	if current+anonymous > MaxItemCount {
+		return MaxItemCount
+	}
+	return current + anonymous
+}
+
+// open basket of the customer changed last, skipping skip
+func customerBasket(customerID, skip string) (BasketData, bool, error) {
+	baskets, err := store.List()
+	if err != nil {
+		return BasketData{}, false, err
+	}
+	t := now()
+	var found *BasketData
+	for i, basket := range baskets {
+		if basket.Owner != customerID || basket.ID == skip || basket.CheckedOut || basket.expired(t) {
+			continue
+		}
+		if found == nil || basket.UpdatedAt.After(found.UpdatedAt) {
+			found = &baskets[i]
+		}
+	}
+	if found == nil {
+		return BasketData{}, false, nil
+	}
+	return *found, true, nil
+}
+
+// LoginCustomer - basket of a customer that logs in. Without a basket in login it is its open
+// basket changed last (if any). With an anonymous basket, it becomes the customer's basket when
+// the customer has no open basket, otherwise its items and coupons are merged into the customer's
+// basket following the merge rule and the anonymous basket is removed. Logging in again with a
+// basket the customer already owns returns it
+func LoginCustomer(customerID string, login Login) (LoginResult, error) {
+	customer, err := GetCustomer(customerID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	rule := login.Merge
+	if rule == "" {
+		rule = MergeSum
+	}
+	if rule != MergeSum && rule != MergeMax && rule != MergeLatest {
+		return LoginResult{}, fmt.Errorf("Invalid merge rule")
+	}
+	result := LoginResult{Customer: customer}
+	if login.BasketID == "" {
+		current, found, err := customerBasket(customerID, "")
+		if err != nil || !found {
+			return result, err
+		}
+		result.BasketID = current.ID
+		return result, nil
+	}
+	anonymous, err := loadBasket(login.BasketID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if anonymous.Owner == customerID {
+		result.BasketID = anonymous.ID
+		return result, nil
+	}
+	if anonymous.Owner != "" {
+		return LoginResult{}, ErrBasketNotFound
+	}
+	if err := anonymous.editable(); err != nil {
+		return LoginResult{}, err
+	}
+	current, found, err := customerBasket(customerID, anonymous.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if !found {
+		// nothing to merge with, the basket becomes the customer's
+		_, err := updateBasket(anonymous.ID, func(basket *BasketData) error {
+			if basket.Owner != "" {
+				return ErrBasketNotFound
+			}
+			if err := basket.editable(); err != nil {
+				return err
+			}
+			basket.Owner = customerID
+			return nil
+		})
+		if err != nil {
+			return LoginResult{}, err
+		}
+		result.BasketID = anonymous.ID
+		return result, nil
+	}
+	// removing it first (as long as nobody changed it) makes sure its items are merged once
+	if err := deleteBasket(anonymous.ID, []int64{anonymous.Version}); err != nil {
+		return LoginResult{}, err
+	}
+	_, err = updateBasket(current.ID, func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		anonymousIsLatest := anonymous.UpdatedAt.After(basket.UpdatedAt)
+		for code, count := range anonymous.Items {
+			basket.Items[code] = rule.merge(basket.Items[code], count, anonymousIsLatest)
+		}
+		for _, code := range anonymous.Coupons {
+			applied := false
+			for _, c := range basket.Coupons {
+				applied = applied || c == code
+			}
+			if !applied {
+				basket.Coupons = append(basket.Coupons, code)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// the customer basket was checked out or expired meanwhile, the anonymous basket
+		// becomes the customer's so its items are not lost
+		t := now()
+		anonymous.Owner = customerID
+		anonymous.Version++
+		anonymous.UpdatedAt = t.UTC()
+		anonymous.extend(t)
+		if err := store.Put(anonymous); err != nil {
+			return LoginResult{}, err
+		}
+		current.ID = anonymous.ID
+	}
+	result.BasketID = current.ID
+	return result, nil
+}
@@ -0,0 +1,177 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeRules(t *testing.T) {
+	tests := []struct {
+		rule              MergeRule
+		current           int64
+		anonymous         int64
+		anonymousIsLatest bool
+		expected          int64
+	}{
+		{MergeSum, 2, 3, false, 5},
+		{MergeSum, 0, 3, false, 3},
+		{MergeSum, MaxItemCount, 3, false, MaxItemCount},
+		{MergeMax, 2, 3, false, 3},
+		{MergeMax, 4, 3, true, 4},
+		{MergeLatest, 2, 3, true, 3},
+		{MergeLatest, 2, 3, false, 2},
+		{MergeLatest, 0, 3, false, 0},
+	}
+	for _, test := range tests {
+		if got := test.rule.merge(test.current, test.anonymous, test.anonymousIsLatest); got != test.expected {
+			t.Errorf("%s %d %d expected %d got %d", test.rule, test.current, test.anonymous, test.expected, got)
+			return
+		}
+	}
+}
+
+func TestLoginClaimsAnonymousBasket(t *testing.T) {
+	defer resetCustomers()()
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	if result, err := LoginCustomer(alice.ID, Login{}); err != nil || result.BasketID != "" || result.Customer.ID != alice.ID {
+		t.Errorf("wrong login without baskets %v %v", result, err)
+		return
+	}
+	anonymous, _ := NewBasket()
+	_, _ = anonymous.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	result, err := LoginCustomer(alice.ID, Login{BasketID: anonymous.GetID()})
+	if err != nil || result.BasketID != anonymous.GetID() {
+		t.Errorf("basket should become the customer's %v %v", result, err)
+		return
+	}
+	if owner, _ := anonymous.GetOwner(); owner != alice.ID {
+		t.Errorf("wrong owner expected %s got %s", alice.ID, owner)
+		return
+	}
+	// logging in again is harmless
+	if result, err := LoginCustomer(alice.ID, Login{BasketID: anonymous.GetID()}); err != nil || result.BasketID != anonymous.GetID() {
+		t.Errorf("wrong second login %v %v", result, err)
+		return
+	}
+	if result, _ := LoginCustomer(alice.ID, Login{}); result.BasketID != anonymous.GetID() {
+		t.Errorf("login should return the customer basket got %v", result)
+		return
+	}
+	// baskets of others can't be taken
+	bob := mustRegisterCustomer(t, "bob@example.com")
+	if _, err := LoginCustomer(bob.ID, Login{BasketID: anonymous.GetID()}); err != ErrBasketNotFound {
+		t.Errorf("expected %v got %v", ErrBasketNotFound, err)
+		return
+	}
+}
+
+func TestLoginMergesBaskets(t *testing.T) {
+	defer resetCustomers()()
+	defer resetCoupons(mugCoupon)()
+	clock, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	tests := []struct {
+		rule     MergeRule
+		expected map[string]int64
+	}{
+		{"", map[string]int64{merchandise.PEN: 3, merchandise.MUG: 1, merchandise.TSHIRT: 4}},
+		{MergeSum, map[string]int64{merchandise.PEN: 3, merchandise.MUG: 1, merchandise.TSHIRT: 4}},
+		{MergeMax, map[string]int64{merchandise.PEN: 2, merchandise.MUG: 1, merchandise.TSHIRT: 3}},
+		// the anonymous basket is changed last
+		{MergeLatest, map[string]int64{merchandise.PEN: 1, merchandise.MUG: 1, merchandise.TSHIRT: 1}},
+	}
+	for _, test := range tests {
+		SetBasketStore(NewMemoryBasketStore())
+		owned, _ := NewBasketWithOptions(BasketOptions{Owner: alice.ID})
+		_, _ = owned.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+		_, _ = owned.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 3})
+		clock.t = clock.t.Add(time.Minute)
+		anonymous, _ := NewBasket()
+		_, _ = anonymous.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+		_, _ = anonymous.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+		_, _ = anonymous.AddItem(ProductItem{Product: merchandise.TSHIRT, Count: 1})
+		_, _ = anonymous.ApplyCoupon(mugCoupon.Code)
+		result, err := LoginCustomer(alice.ID, Login{BasketID: anonymous.GetID(), Merge: test.rule})
+		if err != nil || result.BasketID != owned.GetID() {
+			t.Errorf("%s: items should be merged into the customer basket %v %v", test.rule, result, err)
+			return
+		}
+		items, _ := owned.GetItems()
+		if len(items) != len(test.expected) {
+			t.Errorf("%s: expected %v got %v", test.rule, test.expected, items)
+			return
+		}
+		for _, item := range items {
+			if test.expected[item.Product] != item.Count {
+				t.Errorf("%s: expected %v got %v", test.rule, test.expected, items)
+				return
+			}
+		}
+		if coupons, _ := owned.GetCoupons(); len(coupons) != 1 || coupons[0] != mugCoupon.Code {
+			t.Errorf("%s: coupons should be merged got %v", test.rule, coupons)
+			return
+		}
+		if _, err := GetBasket(anonymous.GetID()); err != ErrBasketNotFound {
+			t.Errorf("%s: merged basket should be removed got %v", test.rule, err)
+			return
+		}
+	}
+	if _, err := LoginCustomer(alice.ID, Login{Merge: "lala"}); err == nil || err.Error() != "Invalid merge rule" {
+		t.Errorf("expected Invalid merge rule got %v", err)
+		return
+	}
+}
+
+func TestLoginCheckedOutBasket(t *testing.T) {
+	defer resetCustomers()()
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	anonymous, _ := NewBasket()
+	_, _ = anonymous.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = anonymous.Checkout()
+	if _, err := LoginCustomer(alice.ID, Login{BasketID: anonymous.GetID()}); err == nil || err.Error() != "Basket is checked out" {
+		t.Errorf("expected Basket is checked out got %v", err)
+		return
+	}
+	// checked out baskets of the customer are not merged into
+	owned, _ := NewBasketWithOptions(BasketOptions{Owner: alice.ID})
+	_, _ = owned.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = owned.Checkout()
+	next, _ := NewBasket()
+	if result, _ := LoginCustomer(alice.ID, Login{BasketID: next.GetID()}); result.BasketID != next.GetID() {
+		t.Errorf("basket should become the customer's got %v", result)
+		return
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	defer resetCustomers()()
+	_, stop := startExpiryTest(BasketExpiry{})
+	defer stop()
+	alice := mustRegisterCustomer(t, "alice@example.com")
+	anonymous, _ := NewBasket()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/customers/login", strings.NewReader("{\"basket_id\":\""+anonymous.GetID()+"\"}"))
+	req.Header.Set("Authorization", "Bearer "+alice.Token)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "\"basket_id\":\""+anonymous.GetID()+"\"") {
+		t.Errorf("HandleLogin wrong response %d %s", w.Code, w.Body.String())
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/customers/login", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("HandleLogin wrong http status expected %d got %d", http.StatusUnauthorized, w.Code)
+		return
+	}
+}
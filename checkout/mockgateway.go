@@ -0,0 +1,130 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"sync"
+)
+
+// MockOutcome - what the mock gateway does with a card
+type MockOutcome string
+
+// mock outcomes
+const (
+	MockApprove MockOutcome = "approve"
+	MockDecline MockOutcome = "decline"
+	MockTimeout MockOutcome = "timeout"
+)
+
+// test cards known by the mock gateway, any other card is approved
+const (
+	MockCardApproved = "4242424242424242"
+	MockCardDeclined = "4000000000000002"
+	MockCardTimeout  = "4000000000000119"
+)
+
+type mockAuthorization struct {
+	amount   merchandise.Money
+	captured merchandise.Money
+	refunded merchandise.Money
+	voided   bool
+}
+
+// MockPaymentGateway - deterministic PaymentProvider that never talks to a real processor
+// the outcome of authorizations depends only on the card number (see Outcomes) and
+// references are generated in sequence (auth_1, auth_2...). Timeouts are reported
+// right away with ErrPaymentTimeout instead of waiting.
+type MockPaymentGateway struct {
+	lock sync.Mutex
+	// Outcomes - outcome by card number, cards not listed are approved
+	Outcomes       map[string]MockOutcome
+	authorizations map[string]*mockAuthorization
+	sequence       int
+}
+
+// NewMockPaymentGateway - creates a mock gateway that knows the MockCard* test cards
+func NewMockPaymentGateway() *MockPaymentGateway {
+	return &MockPaymentGateway{
+		Outcomes: map[string]MockOutcome{
+			MockCardApproved: MockApprove,
+			MockCardDeclined: MockDecline,
+			MockCardTimeout:  MockTimeout,
+		},
+		authorizations: make(map[string]*mockAuthorization),
+	}
+}
+
+func (g *MockPaymentGateway) get(reference string) (*mockAuthorization, error) {
+	authorization, ok := g.authorizations[reference]
+	if !ok {
+		return nil, fmt.Errorf("Unknown payment reference %s", reference)
+	}
+	return authorization, nil
+}
+
+// Authorize - reserve amount on card according to the card outcome
+func (g *MockPaymentGateway) Authorize(card PaymentCard, amount merchandise.Money) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch g.Outcomes[card.Number] {
+	case MockDecline:
+		return "", ErrPaymentDeclined
+	case MockTimeout:
+		return "", ErrPaymentTimeout
+	}
+	if !amount.IsPositive() {
+		return "", fmt.Errorf("Invalid payment amount %s", amount)
+	}
+	g.sequence++
+	reference := fmt.Sprintf("auth_%d", g.sequence)
+	g.authorizations[reference] = &mockAuthorization{amount: amount}
+	return reference, nil
+}
+
+// Capture - charge an authorized amount, can be done in several parts
+func (g *MockPaymentGateway) Capture(reference string, amount merchandise.Money) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	authorization, err := g.get(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.voided {
+		return fmt.Errorf("Authorization %s was voided", reference)
+	}
+	if authorization.captured.Add(amount).Amount > authorization.amount.Amount {
+		return fmt.Errorf("Capture exceeds authorized amount")
+	}
+	authorization.captured = authorization.captured.Add(amount)
+	return nil
+}
+
+// Void - release an authorization that has not been captured
+func (g *MockPaymentGateway) Void(reference string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	authorization, err := g.get(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.captured.IsPositive() {
+		return fmt.Errorf("Authorization %s was already captured", reference)
+	}
+	authorization.voided = true
+	return nil
+}
+
+// Refund - return a captured amount, can be done in several parts
+func (g *MockPaymentGateway) Refund(reference string, amount merchandise.Money) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	authorization, err := g.get(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.refunded.Add(amount).Amount > authorization.captured.Amount {
+		return fmt.Errorf("Refund exceeds captured amount")
+	}
+	authorization.refunded = authorization.refunded.Add(amount)
+	return nil
+}
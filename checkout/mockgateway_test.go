@@ -0,0 +1,88 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestMockGatewayOutcomes(t *testing.T) {
+	g := NewMockPaymentGateway()
+	amount := merchandise.Cents(500)
+	cases := []struct {
+		card     string
+		expected error
+	}{
+		{MockCardApproved, nil},
+		{"5555555555554444", nil},
+		{MockCardDeclined, ErrPaymentDeclined},
+		{MockCardTimeout, ErrPaymentTimeout},
+	}
+	for _, tc := range cases {
+		reference, err := g.Authorize(PaymentCard{Number: tc.card}, amount)
+		if err != tc.expected {
+			t.Errorf("Authorize with %s expected %v got %v", tc.card, tc.expected, err)
+			continue
+		}
+		if err == nil && reference == "" {
+			t.Errorf("Authorize with %s returned no reference", tc.card)
+		}
+	}
+	g.Outcomes["5555555555554444"] = MockDecline
+	if _, err := g.Authorize(PaymentCard{Number: "5555555555554444"}, amount); err != ErrPaymentDeclined {
+		t.Errorf("configured outcome was not used got %v", err)
+	}
+}
+
+func TestMockGatewayReferencesAreSequential(t *testing.T) {
+	g := NewMockPaymentGateway()
+	card := PaymentCard{Number: MockCardApproved}
+	first, _ := g.Authorize(card, merchandise.Cents(100))
+	second, _ := g.Authorize(card, merchandise.Cents(100))
+	if first != "auth_1" || second != "auth_2" {
+		t.Errorf("wrong references expected auth_1 and auth_2 got %s and %s", first, second)
+	}
+}
+
+func TestMockGatewayCaptureAndRefund(t *testing.T) {
+	g := NewMockPaymentGateway()
+	reference, _ := g.Authorize(PaymentCard{Number: MockCardApproved}, merchandise.Cents(1000))
+	if err := g.Refund(reference, merchandise.Cents(100)); err == nil {
+		t.Errorf("Refund before capture should fail")
+		return
+	}
+	if err := g.Capture(reference, merchandise.Cents(1001)); err == nil {
+		t.Errorf("Capture above authorized amount should fail")
+		return
+	}
+	if err := g.Capture(reference, merchandise.Cents(1000)); err != nil {
+		t.Errorf("Capture returned an error %s", err.Error())
+		return
+	}
+	if err := g.Void(reference); err == nil {
+		t.Errorf("Void after capture should fail")
+		return
+	}
+	if err := g.Refund(reference, merchandise.Cents(600)); err != nil {
+		t.Errorf("Refund returned an error %s", err.Error())
+		return
+	}
+	if err := g.Refund(reference, merchandise.Cents(600)); err == nil {
+		t.Errorf("Refund above captured amount should fail")
+		return
+	}
+	if err := g.Capture("auth_99", merchandise.Cents(1)); err == nil {
+		t.Errorf("Capture with unknown reference should fail")
+	}
+}
+
+func TestMockGatewayVoid(t *testing.T) {
+	g := NewMockPaymentGateway()
+	reference, _ := g.Authorize(PaymentCard{Number: MockCardApproved}, merchandise.Cents(1000))
+	if err := g.Void(reference); err != nil {
+		t.Errorf("Void returned an error %s", err.Error())
+		return
+	}
+	if err := g.Capture(reference, merchandise.Cents(1000)); err == nil {
+		t.Errorf("Capture after void should fail")
+	}
+}
@@ -0,0 +1,495 @@
+package checkout
+
+import (
+	"errors"
+	"github.com/gato/lana/merchandise"
+	"sort"
+	"strconv"
+)
+
+// competing promotions searched for the best combination, bigger sets of
+// promotions sharing products are applied greedily in priority order
+const maxSearchedPromotions = 16
+
+// offers tried by a search before giving up on it and applying its promotions greedily,
+// the search grows exponentially with the promotions competing for a product, this
+// bounds it to some tens of milliseconds whatever the promotions are
+const maxSearchSteps = 20000
+
+var errSearchTooLarge = errors.New("search too large")
+
+// ProductPromotion - implemented by promotions that know which products they use
+// promotions that don't are assumed to compete with every other promotion
+type ProductPromotion interface {
+	Promotion
+	Products() []string
+}
+
+// products used by promotion, nil means any product
+func productsOf(promotion Promotion) []string {
+	if p, ok := promotion.(ProductPromotion); ok {
+		return p.Products()
+	}
+	return nil
+}
+
+// QuantityPromotion - implemented by single product promotions whose discount only changes
+// at some quantities: from minimum units and then every step units (i.e. buy 2 get 1 free
+// changes every 2 units). Promotions that don't are assumed to change with every unit
+type QuantityPromotion interface {
+	Promotion
+	QuantityStep() (minimum, step int64)
+}
+
+// quantities that change the result of promotion
+func quantityStepOf(promotion Promotion) (minimum, step int64) {
+	minimum, step = 1, 1
+	if p, ok := promotion.(QuantityPromotion); ok {
+		minimum, step = p.QuantityStep()
+	}
+	if minimum < 1 {
+		minimum = 1
+	}
+	if step < 1 {
+		step = 1
+	}
+	return minimum, step
+}
+
+// Products - products of the wrapped promotion
+func (promotion windowedPromotion) Products() []string {
+	return productsOf(promotion.Promotion)
+}
+
+// QuantityStep - quantities that change the result of the wrapped promotion
+func (promotion windowedPromotion) QuantityStep() (int64, int64) {
+	return quantityStepOf(promotion.Promotion)
+}
+
+// Products - products of the wrapped promotion
+func (promotion stackedPromotion) Products() []string {
+	return productsOf(promotion.Promotion)
+}
+
+// QuantityStep - quantities that change the result of the wrapped promotion
+func (promotion stackedPromotion) QuantityStep() (int64, int64) {
+	return quantityStepOf(promotion.Promotion)
+}
+
+// promotion taking part in the search, order is its position by priority
+type candidate struct {
+	order     int
+	promotion Promotion
+	stacking  Stacking
+	products  []string
+	minimum   int64
+	step      int64
+}
+
+func (c candidate) uses(code string) bool {
+	if c.products == nil {
+		return true
+	}
+	for _, product := range c.products {
+		if product == code {
+			return true
+		}
+	}
+	return false
+}
+
+type allocatedDiscount struct {
+	order    int
+	discount Discount
+}
+
+// discounts chosen for a set of promotions and their value in minor units
+type allocation struct {
+	value     int64
+	discounts []allocatedDiscount
+}
+
+func (a allocation) plus(other allocation) allocation {
+	return allocation{
+		value:     a.value + other.value,
+		discounts: append(append([]allocatedDiscount(nil), a.discounts...), other.discounts...),
+	}
+}
+
+// apply the combination of promotions that gives the lowest total
+// every promotion only gets units no other promotion used (see Discount.Units) so a unit
+// is never discounted twice, stacking rules are followed and discounts are capped so the
+// total can't go below zero. Discounts are returned in priority order
+func applyPromotions(items map[string]item, promotions []Promotion, subtotal merchandise.Money) ([]Discount, error) {
+	discounts, _, err := allocatePromotions(items, promotions, subtotal)
+	return discounts, err
+}
+
+// same as applyPromotions also returning the stacking of the promotion of every discount
+func allocatePromotions(items map[string]item, promotions []Promotion, subtotal merchandise.Money) ([]Discount, []Stacking, error) {
+	ordered := append([]Promotion(nil), promotions...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return stackingOf(ordered[i]).Priority > stackingOf(ordered[j]).Priority
+	})
+	var stackable, alone []candidate
+	for i, promotion := range ordered {
+		c := candidate{order: i, promotion: promotion, stacking: stackingOf(promotion), products: productsOf(promotion)}
+		c.minimum, c.step = quantityStepOf(promotion)
+		if c.stacking.Stackable {
+			stackable = append(stackable, c)
+		} else {
+			alone = append(alone, c)
+		}
+	}
+	// promotions that don't share products nor groups are independent
+	best := allocation{}
+	for _, component := range components(stackable) {
+		a, err := optimize(items, component)
+		if err != nil {
+			return nil, nil, err
+		}
+		best = best.plus(a)
+	}
+	// not stackable promotions compete against the best combination of the others
+	for _, c := range alone {
+		a, err := optimize(items, []candidate{c})
+		if err != nil {
+			return nil, nil, err
+		}
+		if a.value > best.value {
+			best = a
+		}
+	}
+	sort.SliceStable(best.discounts, func(i, j int) bool { return best.discounts[i].order < best.discounts[j].order })
+	discounts := make([]Discount, len(best.discounts))
+	stackings := make([]Stacking, len(best.discounts))
+	available := subtotal
+	for i, allocated := range best.discounts {
+		discount := allocated.discount
+		if discount.Amount.Amount > available.Amount {
+			discount.Amount = available
+		}
+		available = available.Sub(discount.Amount)
+		discounts[i] = discount
+		stackings[i] = stackingOf(ordered[allocated.order])
+	}
+	return discounts, stackings, nil
+}
+
+// split candidates in groups that compete for products or exclusivity groups
+func components(candidates []candidate) [][]candidate {
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		i, j = find(i), find(j)
+		if i < j {
+			parent[j] = i
+		} else if j < i {
+			parent[i] = j
+		}
+	}
+	owners := make(map[string]int)
+	claim := func(key string, i int) {
+		if j, ok := owners[key]; ok {
+			union(i, j)
+			return
+		}
+		owners[key] = i
+	}
+	any := -1
+	for i, c := range candidates {
+		if c.products == nil {
+			if any < 0 {
+				any = i
+			}
+			union(i, any)
+		}
+		for _, code := range c.products {
+			claim("product:"+code, i)
+		}
+		if c.stacking.Group != "" {
+			claim("group:"+c.stacking.Group, i)
+		}
+	}
+	if any >= 0 {
+		for i := range candidates {
+			union(i, any)
+		}
+	}
+	byRoot := make(map[int][]candidate)
+	roots := make([]int, 0)
+	for i, c := range candidates {
+		root := find(i)
+		if _, ok := byRoot[root]; !ok {
+			roots = append(roots, root)
+		}
+		byRoot[root] = append(byRoot[root], c)
+	}
+	list := make([][]candidate, len(roots))
+	for i, root := range roots {
+		list[i] = byRoot[root]
+	}
+	return list
+}
+
+// memoized search of the best allocation of units to a set of competing promotions
+// counts are indexed like codes
+type search struct {
+	items      map[string]item
+	codes      []string
+	index      map[string]int
+	candidates []candidate
+	memo       map[string]allocation
+	applied    map[string]application
+	steps      int
+}
+
+// result of applying a promotion to some units
+type application struct {
+	allocation
+	used []int64
+}
+
+func optimize(items map[string]item, candidates []candidate) (allocation, error) {
+	s := search{
+		items:      make(map[string]item),
+		index:      make(map[string]int),
+		candidates: candidates,
+		memo:       make(map[string]allocation),
+		applied:    make(map[string]application),
+	}
+	// only products the promotions use are taken into account
+	for _, c := range candidates {
+		if c.products == nil {
+			s.items = items
+			break
+		}
+		for _, code := range c.products {
+			if item, ok := items[code]; ok {
+				s.items[code] = item
+			}
+		}
+	}
+	for code := range s.items {
+		s.codes = append(s.codes, code)
+	}
+	sort.Strings(s.codes)
+	counts := make([]int64, len(s.codes))
+	for i, code := range s.codes {
+		s.index[code] = i
+		counts[i] = s.items[code].Count
+	}
+	if len(candidates) > maxSearchedPromotions {
+		return s.greedy(counts)
+	}
+	best, err := s.best(counts, 0)
+	if err == errSearchTooLarge {
+		return s.greedy(counts)
+	}
+	return best, err
+}
+
+// counts left for a promotion, restricted to the products it uses
+func (s *search) remaining(c candidate, counts []int64) []int64 {
+	if c.products == nil {
+		return counts
+	}
+	remaining := make([]int64, len(counts))
+	for _, code := range c.products {
+		if i, ok := s.index[code]; ok {
+			remaining[i] = counts[i]
+		}
+	}
+	return remaining
+}
+
+// true when there are no units left
+func none(counts []int64) bool {
+	for _, count := range counts {
+		if count > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// units promotion i can be offered: everything left and, for single product promotions,
+// smaller quantities so part of the units can go to other promotions still to be applied.
+// Only quantities changing its result are offered and only a few steps of them: holding
+// more units than the others need, a promotion never does better than giving whole steps
+// of them to the best of the others, so offers don't grow with the units in the basket
+func (s *search) offers(i int, counts []int64, used uint32) [][]int64 {
+	c := s.candidates[i]
+	remaining := s.remaining(c, counts)
+	if none(remaining) {
+		return nil
+	}
+	offers := [][]int64{remaining}
+	if len(c.products) != 1 {
+		return offers
+	}
+	code := c.products[0]
+	span := int64(0)
+	for j, other := range s.candidates {
+		if j == i || used&(1<<uint(j)) != 0 || !other.uses(code) {
+			continue
+		}
+		if need := other.minimum + other.step; need > span {
+			span = need
+		}
+	}
+	if span == 0 {
+		return offers
+	}
+	j := s.index[code]
+	top := c.minimum + c.step*span
+	if top >= remaining[j] {
+		top = remaining[j] - 1
+	}
+	if top < c.minimum {
+		return offers
+	}
+	// larger quantities first, ties go to the first allocation found
+	for k := top - (top-c.minimum)%c.step; k >= c.minimum; k -= c.step {
+		partial := make([]int64, len(remaining))
+		partial[j] = k
+		offers = append(offers, partial)
+	}
+	return offers
+}
+
+// apply a promotion to the offered units, results are cached as the same
+// offer is reached from many states of the search
+func (s *search) apply(i int, offer []int64) (application, error) {
+	key := countsKey(int64(i), offer)
+	if result, ok := s.applied[key]; ok {
+		return result, nil
+	}
+	items := make(map[string]item)
+	for j, count := range offer {
+		if count > 0 {
+			left := s.items[s.codes[j]]
+			left.Count = count
+			items[s.codes[j]] = left
+		}
+	}
+	c := s.candidates[i]
+	discounts, err := c.promotion.Apply(items)
+	if err != nil {
+		return application{}, err
+	}
+	result := application{used: make([]int64, len(offer))}
+	for _, discount := range discounts {
+		result.value += discount.Amount.Amount
+		result.discounts = append(result.discounts, allocatedDiscount{order: c.order, discount: discount})
+		for code, units := range discount.Units {
+			if j, ok := s.index[code]; ok {
+				result.used[j] += units
+			}
+		}
+	}
+	s.applied[key] = result
+	return result, nil
+}
+
+// memo key, called for every state of the search so it avoids fmt
+func countsKey(prefix int64, counts []int64) string {
+	key := strconv.AppendInt(make([]byte, 0, 4*len(counts)+8), prefix, 36)
+	for _, count := range counts {
+		key = append(key, ',')
+		key = strconv.AppendInt(key, count, 36)
+	}
+	return string(key)
+}
+
+// counts minus used units
+func left(counts, used []int64) []int64 {
+	next := make([]int64, len(counts))
+	for i := range counts {
+		next[i] = counts[i] - used[i]
+		if next[i] < 0 {
+			next[i] = 0
+		}
+	}
+	return next
+}
+
+func (s *search) best(counts []int64, used uint32) (allocation, error) {
+	key := countsKey(int64(used), counts)
+	if a, ok := s.memo[key]; ok {
+		return a, nil
+	}
+	groups := make(map[string]bool)
+	for i, c := range s.candidates {
+		if used&(1<<uint(i)) != 0 && c.stacking.Group != "" {
+			groups[c.stacking.Group] = true
+		}
+	}
+	best := allocation{}
+	for i, c := range s.candidates {
+		if used&(1<<uint(i)) != 0 || (c.stacking.Group != "" && groups[c.stacking.Group]) {
+			continue
+		}
+		for _, offer := range s.offers(i, counts, used) {
+			s.steps++
+			if s.steps > maxSearchSteps {
+				return allocation{}, errSearchTooLarge
+			}
+			applied, err := s.apply(i, offer)
+			if err != nil {
+				return allocation{}, err
+			}
+			if len(applied.discounts) == 0 {
+				continue
+			}
+			rest, err := s.best(left(counts, applied.used), used|1<<uint(i))
+			if err != nil {
+				return allocation{}, err
+			}
+			// only strictly better combinations replace the first one found so
+			// ties go to higher priority promotions
+			if applied.value+rest.value > best.value {
+				best = applied.plus(rest)
+			}
+		}
+	}
+	s.memo[key] = best
+	return best, nil
+}
+
+// apply candidates in priority order, each one gets the units previous ones left
+func (s *search) greedy(counts []int64) (allocation, error) {
+	result := allocation{}
+	groups := make(map[string]bool)
+	for i, c := range s.candidates {
+		if c.stacking.Group != "" && groups[c.stacking.Group] {
+			continue
+		}
+		remaining := s.remaining(c, counts)
+		if none(remaining) {
+			continue
+		}
+		applied, err := s.apply(i, remaining)
+		if err != nil {
+			return allocation{}, err
+		}
+		if len(applied.discounts) == 0 {
+			continue
+		}
+		result = result.plus(applied.allocation)
+		counts = left(counts, applied.used)
+		if c.stacking.Group != "" {
+			groups[c.stacking.Group] = true
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var penBulk = BulkPercentageDiscount{ID: "PEN_BULK", Code: merchandise.PEN, BuyQuantity: 3, DiscountPercentage: 25}
+
+func TestApplyPromotionsBestPrice(t *testing.T) {
+	// applied in the given order the bulk discount would take every pen (6.25), 2x1 on 4 of
+	// them is worth more (10.00) and the last one can't get the bulk discount alone
+	discounts, err := applyPromotions(pens(5), []Promotion{penBulk, PenBuy2Get1}, merchandise.Cents(2500))
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	if len(discounts) != 1 || discounts[0].Promotion != PenBuy2Get1.ID {
+		t.Errorf("wrong discounts %v", discountIDs(discounts))
+		return
+	}
+	if !reflect.DeepEqual(discounts[0].Units, map[string]int64{merchandise.PEN: 4}) || discounts[0].Amount != merchandise.Cents(1000) {
+		t.Errorf("wrong 2x1 allocation %v %s", discounts[0].Units, discounts[0].Amount)
+	}
+	// the result doesn't depend on the order promotions are given in
+	reversed, _ := applyPromotions(pens(5), []Promotion{PenBuy2Get1, penBulk}, merchandise.Cents(2500))
+	if !reflect.DeepEqual(reversed, discounts) {
+		t.Errorf("order of promotions changed the discounts %v vs %v", reversed, discounts)
+	}
+}
+
+func TestApplyPromotionsSplitsUnits(t *testing.T) {
+	// 3 pens: buy 3 get 1 free on all of them (5.00) is worth less than 2x1
+	// on 2 (5.00) plus 10% off the third one (0.50)
+	buy3 := BuyXGetY{ID: "PEN_3X2", Code: merchandise.PEN, BuyQuantity: 3, GetFreeQuantity: 1}
+	tenPercent := BulkPercentageDiscount{ID: "PEN_10", Code: merchandise.PEN, BuyQuantity: 1, DiscountPercentage: 10}
+	discounts, _ := applyPromotions(pens(3), []Promotion{buy3, PenBuy2Get1, tenPercent}, merchandise.Cents(1500))
+	if total(discounts) != merchandise.Cents(550) {
+		t.Errorf("wrong discount expected 5.50 EUR got %s (%v)", total(discounts), discountIDs(discounts))
+	}
+}
+
+func total(discounts []Discount) merchandise.Money {
+	sum := merchandise.Cents(0)
+	for _, discount := range discounts {
+		sum = sum.Add(discount.Amount)
+	}
+	return sum
+}
+
+func TestApplyPromotionsDeterministic(t *testing.T) {
+	items := pens(7)
+	first, _ := applyPromotions(items, []Promotion{PenBuy2Get1, penBulk, penHalfPrice}, merchandise.Cents(3500))
+	for i := 0; i < 20; i++ {
+		again, _ := applyPromotions(items, []Promotion{PenBuy2Get1, penBulk, penHalfPrice}, merchandise.Cents(3500))
+		if !reflect.DeepEqual(first, again) {
+			t.Errorf("applyPromotions is not deterministic %v vs %v", first, again)
+			return
+		}
+	}
+}
+
+func TestApplyPromotionsManyLines(t *testing.T) {
+	items := make(map[string]item)
+	promotions := make([]Promotion, 0)
+	subtotal := merchandise.Cents(0)
+	for i := 0; i < 40; i++ {
+		code := fmt.Sprintf("P%02d", i)
+		product := merchandise.Product{Code: code, Name: code, Price: merchandise.Cents(100)}
+		items[code] = item{Product: product, Count: 25}
+		subtotal = subtotal.Add(product.Price.Mul(25))
+		promotions = append(promotions,
+			BuyXGetY{ID: code + "_2X1", Code: code, BuyQuantity: 2, GetFreeQuantity: 1},
+			BulkPercentageDiscount{ID: code + "_BULK", Code: code, BuyQuantity: 3, DiscountPercentage: 25},
+			BulkPercentageDiscount{ID: code + "_TEN", Code: code, BuyQuantity: 1, DiscountPercentage: 10},
+		)
+	}
+	discounts, err := applyPromotions(items, promotions, subtotal)
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	// per line: 24 units in 2x1 (12.00) and the last one 10% off (0.10)
+	expected := merchandise.Cents(40 * 1210)
+	if total(discounts) != expected {
+		t.Errorf("wrong discount expected %s got %s", expected, total(discounts))
+	}
+}
+
+func TestApplyPromotionsFewUnits(t *testing.T) {
+	// fewer pens than the other promotions need, only 2x1 applies
+	buy3 := BuyXGetY{ID: "PEN_3X2", Code: merchandise.PEN, BuyQuantity: 3, GetFreeQuantity: 1}
+	buy4 := BuyXGetY{ID: "PEN_4X1", Code: merchandise.PEN, BuyQuantity: 4, GetFreeQuantity: 3}
+	discounts, _ := applyPromotions(pens(2), []Promotion{PenBuy2Get1, buy3, buy4}, merchandise.Cents(1000))
+	if total(discounts) != merchandise.Cents(500) {
+		t.Errorf("wrong discount expected 5.00 EUR got %s (%v)", total(discounts), discountIDs(discounts))
+	}
+}
+
+func TestApplyPromotionsManyUnits(t *testing.T) {
+	tenPercent := BulkPercentageDiscount{ID: "PEN_10", Code: merchandise.PEN, BuyQuantity: 1, DiscountPercentage: 10}
+	start := time.Now()
+	discounts, err := applyPromotions(pens(MaxItemCount-1), []Promotion{penBulk, PenBuy2Get1, tenPercent}, merchandise.Cents(500*(MaxItemCount-1)))
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	// 2x1 on every pen but the last one, which gets 10% off
+	expected := merchandise.Cents((MaxItemCount-2)/2*500 + 50)
+	if total(discounts) != expected {
+		t.Errorf("wrong discount expected %s got %s (%v)", expected, total(discounts), discountIDs(discounts))
+		return
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("applyPromotions took %s", elapsed)
+	}
+}
+
+func TestApplyPromotionsManyCompetingPromotions(t *testing.T) {
+	promotions := make([]Promotion, 0)
+	for i := 0; i < maxSearchedPromotions; i++ {
+		promotions = append(promotions, BuyXGetY{ID: fmt.Sprintf("PEN_%d", i), Code: merchandise.PEN, BuyQuantity: int64(i + 2), GetFreeQuantity: 1})
+	}
+	start := time.Now()
+	discounts, err := applyPromotions(pens(MaxItemCount), promotions, merchandise.Cents(500*MaxItemCount))
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	// the search gives up and the first promotion (2x1) takes every pen
+	if expected := merchandise.Cents(MaxItemCount / 2 * 500); total(discounts) != expected {
+		t.Errorf("wrong discount expected %s got %s (%v)", expected, total(discounts), discountIDs(discounts))
+		return
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("applyPromotions took %s", elapsed)
+	}
+}
+
+func TestApplyPromotionsGreedyFallback(t *testing.T) {
+	promotions := make([]Promotion, 0)
+	for i := 0; i <= maxSearchedPromotions; i++ {
+		promotions = append(promotions, BulkPercentageDiscount{ID: fmt.Sprintf("PEN_%d", i), Code: merchandise.PEN, BuyQuantity: 1, DiscountPercentage: int64(i + 1)})
+	}
+	discounts, err := applyPromotions(pens(2), promotions, merchandise.Cents(1000))
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	// too many competing promotions, the first one takes every pen
+	if len(discounts) != 1 || discounts[0].Promotion != "PEN_0" {
+		t.Errorf("wrong discounts %v", discountIDs(discounts))
+	}
+}
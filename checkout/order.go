@@ -0,0 +1,174 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOrderNotFound - returned by stores when an order id is unknown
+var ErrOrderNotFound = fmt.Errorf("Order not found")
+
+// Order - model, immutable snapshot of a basket taken at checkout
+// the receipt keeps prices, discounts and totals of that moment so later
+// catalog or promotion changes don't alter it, only its state can change
+type Order struct {
+	ID       string `json:"id"`
+	BasketID string `json:"basket_id"`
+	// Owner - owner of the basket, orders are only available to it like the basket was
+	Owner     string            `json:"owner,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Receipt   Receipt           `json:"receipt"`
+	State     OrderState        `json:"state"`
+	History   []OrderTransition `json:"history"`
+	// PaymentReference - provider reference of the captured payment
+	PaymentReference string          `json:"payment_reference,omitempty"`
+	Payments         []PaymentRecord `json:"payments"`
+	// Coupons - codes redeemed by the order, their uses are counted from stored orders on startup
+	Coupons []string `json:"coupons,omitempty"`
+}
+
+// OrderStore - interface to plug order storage backends
+type OrderStore interface {
+	// Get - get an order by id, ErrOrderNotFound if it does not exist
+	Get(id string) (Order, error)
+	// Put - store an order, replacing any previous one with the same id
+	Put(Order) error
+	// List - get all stored orders
+	List() ([]Order, error)
+	// Update - call fn with exclusive access to the order and store the result
+	// nothing is stored if fn returns an error
+	Update(id string, fn func(*Order) error) error
+}
+
+// MemoryOrderStore - OrderStore backed by a map, contents are lost on restart
+type MemoryOrderStore struct {
+	lock   sync.RWMutex
+	orders map[string]Order
+}
+
+// NewMemoryOrderStore - creates an empty in-memory order store
+func NewMemoryOrderStore() *MemoryOrderStore {
+	return &MemoryOrderStore{orders: make(map[string]Order)}
+}
+
+// Get - get an order
+func (s *MemoryOrderStore) Get(id string) (Order, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// Put - store an order
+func (s *MemoryOrderStore) Put(order Order) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orders[order.ID] = order
+	return nil
+}
+
+// List - get every order (in no particular order)
+func (s *MemoryOrderStore) List() ([]Order, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]Order, 0, len(s.orders))
+	for _, order := range s.orders {
+		list = append(list, order)
+	}
+	return list, nil
+}
+
+// Update - modify an order while holding the store write lock
+func (s *MemoryOrderStore) Update(id string, fn func(*Order) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	order.History = append([]OrderTransition(nil), order.History...)
+	order.Payments = append([]PaymentRecord(nil), order.Payments...)
+	if err := fn(&order); err != nil {
+		return err
+	}
+	s.orders[id] = order
+	return nil
+}
+
+// store used by package level order functions
+var orders OrderStore = NewMemoryOrderStore()
+
+// SetOrderStore - replace the storage backend used for orders
+// it is meant to be called on startup (or in tests) before serving requests
+func SetOrderStore(s OrderStore) {
+	orders = s
+}
+
+// Checkout - freeze the basket into an order, after this the basket can't be modified
+func (b BasketWrapper) Checkout() (order Order, err error) {
+	err = b.update(func(basket *BasketData) error {
+		if err := basket.editable(); err != nil {
+			return err
+		}
+		if len(basket.Items) == 0 {
+			return fmt.Errorf("Basket is empty")
+		}
+		items, err := basket.items()
+		if err != nil {
+			return err
+		}
+		receipt, err := computeReceipt(items, basket.promotions())
+		if err != nil {
+			return err
+		}
+		// coupons are used once the order exists, an exhausted or expired coupon
+		// fails checkout so the customer is not charged a different total
+		if err := redeemCoupons(basket.Coupons); err != nil {
+			return err
+		}
+		createdAt := now().UTC()
+		order = Order{
+			ID:        uuid.Must(uuid.NewRandom()).String(),
+			BasketID:  basket.ID,
+			Owner:     basket.Owner,
+			CreatedAt: createdAt,
+			Receipt:   receipt,
+			State:     PendingPayment,
+			History:   []OrderTransition{{State: PendingPayment, At: createdAt}},
+			Payments:  []PaymentRecord{},
+			Coupons:   append([]string(nil), basket.Coupons...),
+		}
+		if err := orders.Put(order); err != nil {
+			releaseCoupons(basket.Coupons)
+			return err
+		}
+		basket.CheckedOut = true
+		basket.OrderID = order.ID
+		return nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// GetOrder - Get order by id
+func GetOrder(id string) (Order, error) {
+	return orders.Get(id)
+}
+
+// ListOrders - Get every order sorted by creation time
+func ListOrders() ([]Order, error) {
+	list, err := orders.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
@@ -0,0 +1,151 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestCheckout(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	order, err := b.Checkout()
+	if err != nil {
+		t.Errorf("Checkout returned an error %s", err.Error())
+		return
+	}
+	if order.ID == "" || order.BasketID != b.GetID() {
+		t.Errorf("wrong order %v", order)
+		return
+	}
+	if order.Receipt.Total != merchandise.Cents(500) {
+		t.Errorf("wrong order total expected 5.00 EUR got %s", order.Receipt.Total)
+		return
+	}
+	stored, err := GetOrder(order.ID)
+	if err != nil {
+		t.Errorf("GetOrder returned an error %s", err.Error())
+		return
+	}
+	if stored.ID != order.ID {
+		t.Errorf("wrong order stored %v", stored)
+	}
+}
+
+func TestCheckoutLocksBasket(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	_, _ = b.Checkout()
+
+	expected := "Basket is checked out"
+	_, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	if err == nil || err.Error() != expected {
+		t.Errorf("AddItem should have returned %s got %v", expected, err)
+	}
+	_, err = b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: 1})
+	if err == nil || err.Error() != expected {
+		t.Errorf("SetQuantity should have returned %s got %v", expected, err)
+	}
+	err = b.RemoveItem(merchandise.PEN)
+	if err == nil || err.Error() != expected {
+		t.Errorf("RemoveItem should have returned %s got %v", expected, err)
+	}
+	err = b.Clear()
+	if err == nil || err.Error() != expected {
+		t.Errorf("Clear should have returned %s got %v", expected, err)
+	}
+	_, err = b.Checkout()
+	if err == nil || err.Error() != expected {
+		t.Errorf("Checkout should have returned %s got %v", expected, err)
+	}
+	// items are still readable
+	items, _ := b.GetItems()
+	if len(items) != 1 {
+		t.Errorf("checked out basket should keep its items")
+	}
+}
+
+func TestCheckoutErrors(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	b, _ := NewBasket()
+	_, err := b.Checkout()
+	if err == nil || err.Error() != "Basket is empty" {
+		t.Errorf("Checkout should have returned Basket is empty got %v", err)
+	}
+	_ = store.Update(b.GetID(), func(basket *BasketData) error {
+		basket.Promotions = append(basket.Promotions, FailingPromo{})
+		return nil
+	})
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, err = b.Checkout()
+	if err == nil || err.Error() != "some random error" {
+		t.Errorf("Checkout should have returned promotion error got %v", err)
+	}
+	// basket must remain editable after a failed checkout
+	if _, err := b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1}); err != nil {
+		t.Errorf("AddItem returned an error %s", err.Error())
+	}
+	list, _ := ListOrders()
+	if len(list) != 0 {
+		t.Errorf("failed checkouts should not create orders")
+	}
+	SetBasketStore(NewMemoryBasketStore())
+	_, err = b.Checkout()
+	if err != ErrBasketNotFound {
+		t.Errorf("Checkout should have returned ErrBasketNotFound got %v", err)
+	}
+}
+
+func TestOrderKeepsPricesOfCheckoutTime(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 1})
+	order, _ := b.Checkout()
+
+	mug := merchandise.GetProduct(merchandise.MUG)
+	defer merchandise.UpdateProduct(mug)
+	_ = merchandise.UpdateProduct(merchandise.Product{Code: mug.Code, Name: mug.Name, Price: merchandise.Cents(1000)})
+
+	stored, _ := GetOrder(order.ID)
+	if stored.Receipt.Total != merchandise.Cents(750) {
+		t.Errorf("order total should not change expected 7.50 EUR got %s", stored.Receipt.Total)
+	}
+	if stored.Receipt.Lines[0].UnitPrice != merchandise.Cents(750) {
+		t.Errorf("order unit price should not change expected 7.50 EUR got %s", stored.Receipt.Lines[0].UnitPrice)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	SetOrderStore(NewMemoryOrderStore())
+	ids := make([]string, 3)
+	for i := range ids {
+		b, _ := NewBasket()
+		_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+		order, _ := b.Checkout()
+		ids[i] = order.ID
+	}
+	list, err := ListOrders()
+	if err != nil {
+		t.Errorf("ListOrders returned an error %s", err.Error())
+		return
+	}
+	if len(list) != 3 {
+		t.Errorf("wrong number of orders expected 3 got %d", len(list))
+		return
+	}
+	for i := range ids {
+		if list[i].ID != ids[i] {
+			t.Errorf("orders should be sorted by creation time")
+			return
+		}
+	}
+	if _, err := GetOrder("1234"); err != ErrOrderNotFound {
+		t.Errorf("GetOrder should have returned ErrOrderNotFound got %v", err)
+	}
+}
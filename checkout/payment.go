@@ -0,0 +1,224 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// payment errors providers must return so callers can tell them apart
+var (
+	ErrPaymentDeclined = fmt.Errorf("Payment declined")
+	ErrPaymentTimeout  = fmt.Errorf("Payment provider timed out")
+)
+
+// PaymentError - any other provider failure (i.e. a request it considers invalid), the
+// order is fine so it is not an internal error but the provider's
+type PaymentError struct {
+	Err error
+}
+
+func (err PaymentError) Error() string {
+	return err.Err.Error()
+}
+
+// provider errors other than declines and timeouts are wrapped in a PaymentError
+func providerError(err error) error {
+	if err == nil || err == ErrPaymentDeclined || err == ErrPaymentTimeout {
+		return err
+	}
+	return PaymentError{Err: err}
+}
+
+// PaymentCard - card used to pay an order
+type PaymentCard struct {
+	Number string `json:"card_number"`
+}
+
+// PaymentProvider - interface to plug payment processors
+// Authorize reserves an amount on a card and returns a reference used for
+// the other operations, Capture charges (part of) the authorized amount, Void
+// releases an authorization that was not captured and Refund returns (part of)
+// a captured amount
+type PaymentProvider interface {
+	Authorize(card PaymentCard, amount merchandise.Money) (string, error)
+	Capture(reference string, amount merchandise.Money) error
+	Void(reference string) error
+	Refund(reference string, amount merchandise.Money) error
+}
+
+// payment operations
+const (
+	PaymentAuthorize = "authorize"
+	PaymentCapture   = "capture"
+	PaymentVoid      = "void"
+	PaymentRefund    = "refund"
+)
+
+// payment operation outcomes
+const (
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// PaymentRecord - model, one operation made against the payment provider for an order
+type PaymentRecord struct {
+	Operation string            `json:"operation"`
+	Reference string            `json:"reference,omitempty"`
+	Amount    merchandise.Money `json:"amount"`
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	At        time.Time         `json:"at"`
+}
+
+// provider used to charge orders
+var paymentProvider PaymentProvider = NewMockPaymentGateway()
+
+// SetPaymentProvider - replace the provider used to charge orders
+// it is meant to be called on startup (or in tests) before serving requests
+func SetPaymentProvider(p PaymentProvider) {
+	paymentProvider = p
+}
+
+// payments of the same order are serialized so it can't be charged twice,
+// the order store lock is not held while talking to the provider.
+// Orders share a fixed set of locks so memory doesn't grow with orders
+var paymentLocks [64]sync.Mutex
+
+func lockOrderPayments(id string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	lock := &paymentLocks[h.Sum32()%uint32(len(paymentLocks))]
+	lock.Lock()
+	return lock.Unlock
+}
+
+func paymentRecord(operation, reference string, amount merchandise.Money, err error) PaymentRecord {
+	record := PaymentRecord{
+		Operation: operation,
+		Reference: reference,
+		Amount:    amount,
+		Status:    PaymentSucceeded,
+		At:        now().UTC(),
+	}
+	if err != nil {
+		record.Status = PaymentFailed
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// store provider results in the order and move it to a new state if everything went fine
+func recordPayments(id string, records []PaymentRecord, reference string, to OrderState) (order Order, err error) {
+	err = orders.Update(id, func(o *Order) error {
+		o.Payments = append(o.Payments, records...)
+		if reference != "" {
+			o.PaymentReference = reference
+		}
+		if to != "" {
+			if err := o.transition(to); err != nil {
+				return err
+			}
+		}
+		order = *o
+		return nil
+	})
+	return
+}
+
+// get an order checking it can move to state to before contacting the provider
+func orderFor(id string, to OrderState) (Order, error) {
+	order, err := orders.Get(id)
+	if err != nil {
+		return Order{}, err
+	}
+	if !CanTransition(order.State, to) {
+		return Order{}, TransitionError{From: order.State, To: to}
+	}
+	return order, nil
+}
+
+// PayOrder - authorize and capture the order total on a card, the order is marked as paid
+// on success. Provider errors are recorded in the order and returned, the order stays
+// pending payment so it can be retried. Orders with nothing to pay (i.e. fully discounted)
+// are marked as paid without a card nor contacting the provider
+func PayOrder(id string, card PaymentCard) (Order, error) {
+	unlock := lockOrderPayments(id)
+	defer unlock()
+	order, err := orderFor(id, Paid)
+	if err != nil {
+		return Order{}, err
+	}
+	amount := order.Receipt.Total
+	if amount.IsZero() {
+		return recordPayments(id, nil, "", Paid)
+	}
+	if card.Number == "" {
+		return Order{}, fmt.Errorf("Card number is required")
+	}
+	reference, err := paymentProvider.Authorize(card, amount)
+	err = providerError(err)
+	records := []PaymentRecord{paymentRecord(PaymentAuthorize, reference, amount, err)}
+	if err != nil {
+		order, recordErr := recordPayments(id, records, "", "")
+		if recordErr != nil {
+			return Order{}, recordErr
+		}
+		return order, err
+	}
+	err = providerError(paymentProvider.Capture(reference, amount))
+	records = append(records, paymentRecord(PaymentCapture, reference, amount, err))
+	if err != nil {
+		// release the money, it won't be captured
+		voidErr := paymentProvider.Void(reference)
+		records = append(records, paymentRecord(PaymentVoid, reference, amount, voidErr))
+		order, recordErr := recordPayments(id, records, "", "")
+		if recordErr != nil {
+			return Order{}, recordErr
+		}
+		return order, err
+	}
+	return recordPayments(id, records, reference, Paid)
+}
+
+// CancelOrder - cancel an order pending payment
+func CancelOrder(id string) (Order, error) {
+	unlock := lockOrderPayments(id)
+	defer unlock()
+	if _, err := orderFor(id, Cancelled); err != nil {
+		return Order{}, err
+	}
+	return recordPayments(id, nil, "", Cancelled)
+}
+
+// RefundOrder - return the captured amount of a paid order to the customer
+// orders marked as paid without going through the provider are only transitioned
+func RefundOrder(id string) (Order, error) {
+	unlock := lockOrderPayments(id)
+	defer unlock()
+	order, err := orderFor(id, Refunded)
+	if err != nil {
+		return Order{}, err
+	}
+	if order.PaymentReference == "" {
+		return recordPayments(id, nil, "", Refunded)
+	}
+	amount := order.Receipt.Total
+	err = providerError(paymentProvider.Refund(order.PaymentReference, amount))
+	records := []PaymentRecord{paymentRecord(PaymentRefund, order.PaymentReference, amount, err)}
+	if err != nil {
+		order, recordErr := recordPayments(id, records, "", "")
+		if recordErr != nil {
+			return Order{}, recordErr
+		}
+		return order, err
+	}
+	return recordPayments(id, records, "", Refunded)
+}
+
+// FulfillOrder - mark a paid order as delivered
+func FulfillOrder(id string) (Order, error) {
+	return TransitionOrder(id, Fulfilled)
+}
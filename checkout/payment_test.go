@@ -0,0 +1,216 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"testing"
+	"time"
+)
+
+// provider whose captures always fail, records voids
+type failingCaptureProvider struct {
+	*MockPaymentGateway
+	voided []string
+}
+
+func (p *failingCaptureProvider) Capture(reference string, amount merchandise.Money) error {
+	return fmt.Errorf("capture failed")
+}
+
+func (p *failingCaptureProvider) Void(reference string) error {
+	p.voided = append(p.voided, reference)
+	return p.MockPaymentGateway.Void(reference)
+}
+
+func TestPayOrder(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(NewMockPaymentGateway())
+	order := newTestOrder()
+	paid, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	if err != nil {
+		t.Errorf("PayOrder returned an error %s", err.Error())
+		return
+	}
+	if paid.State != Paid || paid.PaymentReference != "auth_1" {
+		t.Errorf("wrong paid order state %s reference %s", paid.State, paid.PaymentReference)
+		return
+	}
+	if len(paid.Payments) != 2 || paid.Payments[0].Operation != PaymentAuthorize || paid.Payments[1].Operation != PaymentCapture {
+		t.Errorf("wrong payment records %v", paid.Payments)
+		return
+	}
+	if paid.Payments[1].Amount != order.Receipt.Total || paid.Payments[1].Status != PaymentSucceeded {
+		t.Errorf("wrong capture record %v", paid.Payments[1])
+		return
+	}
+	// can't be charged twice
+	if _, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved}); err == nil {
+		t.Errorf("PayOrder should fail for paid orders")
+	}
+}
+
+func TestOrderTimesFollowTheClock(t *testing.T) {
+	defer SetClock(SystemClock)
+	at := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	SetClock(FixedClock(at))
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(NewMockPaymentGateway())
+	order := newTestOrder()
+	paid, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	if err != nil {
+		t.Errorf("PayOrder returned an error %s", err.Error())
+		return
+	}
+	if !paid.CreatedAt.Equal(at) {
+		t.Errorf("wrong created_at expected %s got %s", at, paid.CreatedAt)
+		return
+	}
+	for _, transition := range paid.History {
+		if !transition.At.Equal(at) {
+			t.Errorf("wrong %s transition time expected %s got %s", transition.State, at, transition.At)
+			return
+		}
+	}
+	for _, payment := range paid.Payments {
+		if !payment.At.Equal(at) {
+			t.Errorf("wrong %s payment time expected %s got %s", payment.Operation, at, payment.At)
+			return
+		}
+	}
+}
+
+func TestPayOrderFailures(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(NewMockPaymentGateway())
+	order := newTestOrder()
+	cases := []struct {
+		card     string
+		expected error
+	}{
+		{MockCardDeclined, ErrPaymentDeclined},
+		{MockCardTimeout, ErrPaymentTimeout},
+	}
+	for i, tc := range cases {
+		failed, err := PayOrder(order.ID, PaymentCard{Number: tc.card})
+		if err != tc.expected {
+			t.Errorf("PayOrder expected %v got %v", tc.expected, err)
+			return
+		}
+		if failed.State != PendingPayment {
+			t.Errorf("failed payments should leave the order pending got %s", failed.State)
+			return
+		}
+		if len(failed.Payments) != i+1 || failed.Payments[i].Status != PaymentFailed || failed.Payments[i].Error != tc.expected.Error() {
+			t.Errorf("failed payment was not recorded %v", failed.Payments)
+			return
+		}
+	}
+	// a failed payment can be retried
+	if _, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved}); err != nil {
+		t.Errorf("PayOrder retry returned an error %s", err.Error())
+	}
+	if _, err := PayOrder(newTestOrder().ID, PaymentCard{}); err == nil || err.Error() != "Card number is required" {
+		t.Errorf("PayOrder should require a card number got %v", err)
+	}
+}
+
+// provider rejecting every authorization as invalid
+type invalidRequestProvider struct {
+	*MockPaymentGateway
+}
+
+func (p invalidRequestProvider) Authorize(card PaymentCard, amount merchandise.Money) (string, error) {
+	return "", fmt.Errorf("Invalid payment amount %s", amount)
+}
+
+func TestPayOrderProviderErrors(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(invalidRequestProvider{NewMockPaymentGateway()})
+	order := newTestOrder()
+	failed, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	if _, ok := err.(PaymentError); !ok {
+		t.Errorf("PayOrder expected a PaymentError got %v", err)
+		return
+	}
+	if failed.State != PendingPayment || len(failed.Payments) != 1 || failed.Payments[0].Error != err.Error() {
+		t.Errorf("failed payment was not recorded %v", failed.Payments)
+		return
+	}
+}
+
+func TestPayZeroTotalOrder(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(invalidRequestProvider{NewMockPaymentGateway()})
+	order := newTestOrder()
+	_ = orders.Update(order.ID, func(o *Order) error {
+		o.Receipt.Total = merchandise.Cents(0)
+		return nil
+	})
+	paid, err := PayOrder(order.ID, PaymentCard{})
+	if err != nil {
+		t.Errorf("PayOrder returned an error %s", err.Error())
+		return
+	}
+	if paid.State != Paid || paid.PaymentReference != "" || len(paid.Payments) != 0 {
+		t.Errorf("zero total orders should be paid without the provider got %s %v", paid.State, paid.Payments)
+		return
+	}
+	if refunded, err := RefundOrder(order.ID); err != nil || refunded.State != Refunded {
+		t.Errorf("RefundOrder wrong result %v %v", refunded.State, err)
+	}
+}
+
+func TestPayOrderVoidsFailedCaptures(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	provider := &failingCaptureProvider{MockPaymentGateway: NewMockPaymentGateway()}
+	SetPaymentProvider(provider)
+	order := newTestOrder()
+	failed, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	if err == nil {
+		t.Errorf("PayOrder should have returned an error")
+		return
+	}
+	if len(provider.voided) != 1 || provider.voided[0] != "auth_1" {
+		t.Errorf("authorization was not voided %v", provider.voided)
+		return
+	}
+	if len(failed.Payments) != 3 || failed.Payments[2].Operation != PaymentVoid || failed.State != PendingPayment {
+		t.Errorf("wrong payment records %v", failed.Payments)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	defer SetPaymentProvider(NewMockPaymentGateway())
+	SetPaymentProvider(NewMockPaymentGateway())
+	order := newTestOrder()
+	if _, err := RefundOrder(order.ID); err == nil {
+		t.Errorf("RefundOrder should fail for unpaid orders")
+		return
+	}
+	_, _ = PayOrder(order.ID, PaymentCard{Number: MockCardApproved})
+	refunded, err := RefundOrder(order.ID)
+	if err != nil {
+		t.Errorf("RefundOrder returned an error %s", err.Error())
+		return
+	}
+	last := refunded.Payments[len(refunded.Payments)-1]
+	if refunded.State != Refunded || last.Operation != PaymentRefund || last.Status != PaymentSucceeded {
+		t.Errorf("wrong refunded order state %s last payment %v", refunded.State, last)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	order := newTestOrder()
+	cancelled, err := CancelOrder(order.ID)
+	if err != nil {
+		t.Errorf("CancelOrder returned an error %s", err.Error())
+		return
+	}
+	if cancelled.State != Cancelled {
+		t.Errorf("wrong state expected %s got %s", Cancelled, cancelled.State)
+		return
+	}
+	if _, err := PayOrder(order.ID, PaymentCard{Number: MockCardApproved}); err == nil {
+		t.Errorf("PayOrder should fail for cancelled orders")
+	}
+}
@@ -0,0 +1,79 @@
+package checkout
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PromotionPolicy - how baskets get their promotions
+type PromotionPolicy string
+
+// promotion policies
+const (
+	// PinnedPromotions - baskets keep the promotion set (and its version) they were created with
+	PinnedPromotions PromotionPolicy = "pinned"
+	// LivePromotions - baskets get the current promotion set every time their total is computed
+	LivePromotions PromotionPolicy = "live"
+)
+
+// Mutex to syncronize access to the promotion policy
+var policyLock = sync.RWMutex{}
+
+// policy given to new baskets
+var promotionPolicy = PinnedPromotions
+
+// SetPromotionPolicy - change the policy given to new baskets, existing baskets keep theirs
+func SetPromotionPolicy(policy PromotionPolicy) error {
+	if policy != PinnedPromotions && policy != LivePromotions {
+		return fmt.Errorf("unknown promotion policy %q", policy)
+	}
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	promotionPolicy = policy
+	return nil
+}
+
+func defaultPromotionPolicy() PromotionPolicy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return promotionPolicy
+}
+
+// BasketPromotions - DTO, promotion policy of a basket and version of the promotion set it gets
+type BasketPromotions struct {
+	Policy  PromotionPolicy `json:"promotion_policy"`
+	Version int64           `json:"promotions_version"`
+}
+
+// policy of the basket, baskets stored before policies existed are pinned
+func (data BasketData) promotionPolicy() PromotionPolicy {
+	if data.PromotionPolicy == "" {
+		return PinnedPromotions
+	}
+	return data.PromotionPolicy
+}
+
+// promotion set the basket gets
+func (data BasketData) promotionSet() []Promotion {
+	if data.promotionPolicy() == LivePromotions {
+		return defaultPromotions()
+	}
+	return data.Promotions
+}
+
+// GetPromotions - promotion policy of the basket and version of the promotion set it gets
+func (b BasketWrapper) GetPromotions() (BasketPromotions, error) {
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return BasketPromotions{}, err
+	}
+	return basket.promotionsInfo(), nil
+}
+
+func (data BasketData) promotionsInfo() BasketPromotions {
+	policy := data.promotionPolicy()
+	if policy == LivePromotions {
+		return BasketPromotions{Policy: policy, Version: PromotionsVersion()}
+	}
+	return BasketPromotions{Policy: policy, Version: data.PromotionsVersion}
+}
@@ -0,0 +1,72 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestPromotionPolicies(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	defer SetPromotionPolicy(PinnedPromotions)
+	pinned, _ := NewBasket()
+	_ = SetPromotionPolicy(LivePromotions)
+	live, _ := NewBasket()
+	version := PromotionsVersion()
+	for _, b := range []Basket{pinned, live} {
+		_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	}
+
+	rule := PromotionRule{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 3, GetFreeQuantity: 2}
+	if _, err := UpdatePromotion(PenBuy2Get1.ID, ManagedPromotion{PromotionRule: rule, Enabled: true}); err != nil {
+		t.Errorf("UpdatePromotion returned an error %s", err.Error())
+		return
+	}
+	if PromotionsVersion() != version+1 {
+		t.Errorf("version should increase when a rule changes expected %d got %d", version+1, PromotionsVersion())
+		return
+	}
+	// toggles don't create a new version
+	_, _ = EnablePromotion(TshirtBuy3Get25OFF.ID, false)
+	if PromotionsVersion() != version+1 {
+		t.Errorf("version should not change on toggles got %d", PromotionsVersion())
+		return
+	}
+
+	cases := []struct {
+		name    string
+		basket  Basket
+		policy  PromotionPolicy
+		version int64
+		total   int64
+	}{
+		{"pinned", pinned, PinnedPromotions, version, 1000},
+		{"live", live, LivePromotions, version + 1, 500},
+	}
+	for _, tc := range cases {
+		promotions, err := tc.basket.GetPromotions()
+		if err != nil {
+			t.Errorf("%s: GetPromotions returned an error %s", tc.name, err.Error())
+			return
+		}
+		if promotions.Policy != tc.policy || promotions.Version != tc.version {
+			t.Errorf("%s: expected policy %s version %d got %v", tc.name, tc.policy, tc.version, promotions)
+		}
+		if total, _ := tc.basket.GetTotal(); total != merchandise.Cents(tc.total) {
+			t.Errorf("%s: expected total %d got %s", tc.name, tc.total, total)
+		}
+	}
+	if _, err := (BasketWrapper{id: "nope"}).GetPromotions(); err != ErrBasketNotFound {
+		t.Errorf("GetPromotions should have returned Basket not found got %v", err)
+	}
+}
+
+func TestSetPromotionPolicy(t *testing.T) {
+	err := SetPromotionPolicy("sometimes")
+	if err == nil || err.Error() != "unknown promotion policy \"sometimes\"" {
+		t.Errorf("SetPromotionPolicy should reject unknown policies got %v", err)
+	}
+	// baskets stored before policies existed are pinned
+	if (BasketData{}).promotionPolicy() != PinnedPromotions {
+		t.Errorf("baskets without policy should be pinned")
+	}
+}
@@ -6,9 +6,13 @@ import (
 )
 
 // Discount - model, one discount line
+// Promotion is the ID of the promotion that generated it and Units the units of each
+// product it used, those units are not offered to other promotions
 type Discount struct {
-	Description string
-	Amount      float64
+	Description string            `json:"description"`
+	Amount      merchandise.Money `json:"amount"`
+	Promotion   string            `json:"promotion"`
+	Units       map[string]int64  `json:"units,omitempty"`
 }
 
 // Promotion - interface that apply to items and generates Discounts
@@ -18,6 +22,7 @@ type Promotion interface {
 
 // BuyXGetY - buy 2 get 1 free promotion type (BuyQuantity must be greater than GetFreeQuantity)
 type BuyXGetY struct {
+	ID              string
 	BuyQuantity     int64
 	GetFreeQuantity int64
 	Code            string
@@ -25,11 +30,32 @@ type BuyXGetY struct {
 
 // BulkPercentageDiscount - Buy 3 or more to get a 25% on price per unit promotion type
 type BulkPercentageDiscount struct {
+	ID                 string
 	BuyQuantity        int64
 	DiscountPercentage int64
 	Code               string
 }
 
+// Products - products used by the promotion
+func (promotion BuyXGetY) Products() []string {
+	return []string{promotion.Code}
+}
+
+// Products - products used by the promotion
+func (promotion BulkPercentageDiscount) Products() []string {
+	return []string{promotion.Code}
+}
+
+// QuantityStep - free units are given every BuyQuantity units
+func (promotion BuyXGetY) QuantityStep() (int64, int64) {
+	return promotion.BuyQuantity, promotion.BuyQuantity
+}
+
+// QuantityStep - every unit from BuyQuantity on is discounted
+func (promotion BulkPercentageDiscount) QuantityStep() (int64, int64) {
+	return promotion.BuyQuantity, 1
+}
+
 // Apply - Buy X get Y
 func (promotion BuyXGetY) Apply(Items map[string]item) (discounts []Discount, err error) {
 	item, ok := Items[promotion.Code]
@@ -39,10 +65,12 @@ func (promotion BuyXGetY) Apply(Items map[string]item) (discounts []Discount, er
 	}
 	// Discount Y items every X items bought
 	m := item.Count / promotion.BuyQuantity
-	d := item.Product.Price * float64(promotion.GetFreeQuantity*m)
+	d := item.Product.Price.Mul(promotion.GetFreeQuantity * m)
 	discounts = append(discounts, Discount{
 		Description: fmt.Sprintf("Buy %d %s and get %d Free", promotion.BuyQuantity, merchandise.GetProduct(promotion.Code).Name, promotion.GetFreeQuantity),
 		Amount:      d,
+		Promotion:   promotion.ID,
+		Units:       map[string]int64{promotion.Code: m * promotion.BuyQuantity},
 	})
 	return
 }
@@ -54,17 +82,19 @@ func (promotion BulkPercentageDiscount) Apply(Items map[string]item) (discounts
 		// No items of type CODE or not enough of them
 		return
 	}
-	p := float64(promotion.DiscountPercentage) / 100
-	d := item.Product.Price * p * float64(item.Count)
+	// percentage is applied to the whole line so it is rounded only once
+	d := item.Product.Price.Mul(item.Count).Percent(promotion.DiscountPercentage)
 	discounts = append(discounts, Discount{
 		Description: fmt.Sprintf("Buy %d or more %s get %d%% off", promotion.BuyQuantity, merchandise.GetProduct(promotion.Code).Name, promotion.DiscountPercentage),
 		Amount:      d,
+		Promotion:   promotion.ID,
+		Units:       map[string]int64{promotion.Code: item.Count},
 	})
 	return
 }
 
 // PenBuy2Get1 - Buy 2 Pens get 1 Free Promotion
-var PenBuy2Get1 = BuyXGetY{Code: merchandise.PEN, BuyQuantity: 2, GetFreeQuantity: 1}
+var PenBuy2Get1 = BuyXGetY{ID: "PEN_BUY2_GET1", Code: merchandise.PEN, BuyQuantity: 2, GetFreeQuantity: 1}
 
 // TshirtBuy3Get25OFF - Buy 3 or more shirts get 25% off
-var TshirtBuy3Get25OFF = BulkPercentageDiscount{Code: merchandise.TSHIRT, BuyQuantity: 3, DiscountPercentage: 25}
+var TshirtBuy3Get25OFF = BulkPercentageDiscount{ID: "TSHIRT_BUY3_GET25OFF", Code: merchandise.TSHIRT, BuyQuantity: 3, DiscountPercentage: 25}
@@ -19,7 +19,7 @@ func TestBuy2PenGet1FreePromotion(t *testing.T) {
 	}
 	expectedAmount := merchandise.GetProduct(merchandise.PEN).Price
 	if discount[0].Amount != expectedAmount {
-		t.Errorf("Discount should be %.2f but was %.2f", expectedAmount, discount[0].Amount)
+		t.Errorf("Discount should be %s but was %s", expectedAmount, discount[0].Amount)
 	}
 	expected := "Buy 2 Lana Pen and get 1 Free"
 	if discount[0].Description != expected {
@@ -38,9 +38,9 @@ func TestBuy2PenGet1FreePromotionMulti(t *testing.T) {
 		t.Errorf("PEN Discount was not applied")
 		return
 	}
-	expectedAmount := merchandise.GetProduct(merchandise.PEN).Price * float64(10)
+	expectedAmount := merchandise.GetProduct(merchandise.PEN).Price.Mul(10)
 	if discount[0].Amount != expectedAmount {
-		t.Errorf("Discount should be %.2f but was %.2f", expectedAmount, discount[0].Amount)
+		t.Errorf("Discount should be %s but was %s", expectedAmount, discount[0].Amount)
 	}
 	expected := "Buy 2 Lana Pen and get 1 Free"
 	if discount[0].Description != expected {
@@ -88,9 +88,9 @@ func TestBuyXGetYCalculations(t *testing.T) {
 		t.Errorf("Custom Discount was not applied")
 		return
 	}
-	expectedAmount := merchandise.GetProduct(merchandise.PEN).Price * float64(2)
+	expectedAmount := merchandise.GetProduct(merchandise.PEN).Price.Mul(2)
 	if discount[0].Amount != expectedAmount {
-		t.Errorf("Custom Discount should be %.2f but was %.2f", expectedAmount, discount[0].Amount)
+		t.Errorf("Custom Discount should be %s but was %s", expectedAmount, discount[0].Amount)
 	}
 
 	expected := "Buy 3 Lana Pen and get 2 Free"
@@ -111,9 +111,9 @@ func TestBuy3TshirtsGet25OffPromotion(t *testing.T) {
 		t.Errorf("Tshirt Discount was not applied")
 		return
 	}
-	expectedAmount := merchandise.GetProduct(merchandise.TSHIRT).Price * float64(3) * .25
+	expectedAmount := merchandise.GetProduct(merchandise.TSHIRT).Price.Mul(3).Percent(25)
 	if discount[0].Amount != expectedAmount {
-		t.Errorf("Discount should be %.2f but was %.2f", expectedAmount, discount[0].Amount)
+		t.Errorf("Discount should be %s but was %s", expectedAmount, discount[0].Amount)
 	}
 	expected := "Buy 3 or more Lana T-Shirt get 25% off"
 	if discount[0].Description != expected {
@@ -133,9 +133,9 @@ func TestBuy3TshirtsGet25OffPromotionMulti(t *testing.T) {
 		t.Errorf("Tshirt Discount was not applied")
 		return
 	}
-	expectedAmount := merchandise.GetProduct(merchandise.TSHIRT).Price * float64(8) * .25
+	expectedAmount := merchandise.GetProduct(merchandise.TSHIRT).Price.Mul(8).Percent(25)
 	if discount[0].Amount != expectedAmount {
-		t.Errorf("Discount should be %.2f but was %.2f", expectedAmount, discount[0].Amount)
+		t.Errorf("Discount should be %s but was %s", expectedAmount, discount[0].Amount)
 	}
 	expected := "Buy 3 or more Lana T-Shirt get 25% off"
 	if discount[0].Description != expected {
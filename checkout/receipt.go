@@ -0,0 +1,74 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"sort"
+)
+
+// ReceiptLine - model, one product line of a receipt
+type ReceiptLine struct {
+	Product   string            `json:"product"`
+	Name      string            `json:"name"`
+	UnitPrice merchandise.Money `json:"unit_price"`
+	Quantity  int64             `json:"quantity"`
+	Subtotal  merchandise.Money `json:"subtotal"`
+}
+
+// Receipt - model, itemized breakdown of what has to be paid for a basket
+type Receipt struct {
+	Lines         []ReceiptLine     `json:"lines"`
+	Discounts     []Discount        `json:"discounts"`
+	Subtotal      merchandise.Money `json:"subtotal"`
+	DiscountTotal merchandise.Money `json:"discount_total"`
+	Total         merchandise.Money `json:"total"`
+}
+
+// build a receipt applying promotions to items, lines are sorted by product code
+func computeReceipt(items map[string]item, promotions []Promotion) (Receipt, error) {
+	receipt := Receipt{
+		Lines:         make([]ReceiptLine, 0, len(items)),
+		Discounts:     make([]Discount, 0),
+		Subtotal:      merchandise.Cents(0),
+		DiscountTotal: merchandise.Cents(0),
+	}
+	// sumarize products
+	for code, item := range items {
+		subtotal := item.Product.Price.Mul(item.Count)
+		receipt.Lines = append(receipt.Lines, ReceiptLine{
+			Product:   code,
+			Name:      item.Product.Name,
+			UnitPrice: item.Product.Price,
+			Quantity:  item.Count,
+			Subtotal:  subtotal,
+		})
+		receipt.Subtotal = receipt.Subtotal.Add(subtotal)
+	}
+	sort.Slice(receipt.Lines, func(i, j int) bool { return receipt.Lines[i].Product < receipt.Lines[j].Product })
+	// calculate discounts, order level promotions go on the total left by product promotions
+	var productPromotions, orderPromotions []Promotion
+	for _, promotion := range promotions {
+		if isOrderPromotion(promotion) {
+			orderPromotions = append(orderPromotions, promotion)
+		} else {
+			productPromotions = append(productPromotions, promotion)
+		}
+	}
+	discounts, stackings, err := allocatePromotions(items, productPromotions, receipt.Subtotal)
+	if err != nil {
+		return Receipt{}, err
+	}
+	for _, discount := range discounts {
+		receipt.DiscountTotal = receipt.DiscountTotal.Add(discount.Amount)
+		receipt.Discounts = append(receipt.Discounts, discount)
+	}
+	discounts, err = applyOrderPromotions(orderPromotions, receipt.Subtotal.Sub(receipt.DiscountTotal), stackings)
+	if err != nil {
+		return Receipt{}, err
+	}
+	for _, discount := range discounts {
+		receipt.DiscountTotal = receipt.DiscountTotal.Add(discount.Amount)
+		receipt.Discounts = append(receipt.Discounts, discount)
+	}
+	receipt.Total = receipt.Subtotal.Sub(receipt.DiscountTotal)
+	return receipt, nil
+}
@@ -0,0 +1,118 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"reflect"
+	"testing"
+)
+
+func TestComputeReceipt(t *testing.T) {
+	// Items: PEN x3, TSHIRT x3, MUG x1
+	items := map[string]item{
+		merchandise.TSHIRT: {Product: merchandise.GetProduct(merchandise.TSHIRT), Count: 3},
+		merchandise.PEN:    {Product: merchandise.GetProduct(merchandise.PEN), Count: 3},
+		merchandise.MUG:    {Product: merchandise.GetProduct(merchandise.MUG), Count: 1},
+	}
+	receipt, err := computeReceipt(items, []Promotion{PenBuy2Get1, TshirtBuy3Get25OFF})
+	if err != nil {
+		t.Errorf("computeReceipt returned an error %s", err.Error())
+		return
+	}
+	if len(receipt.Lines) != 3 {
+		t.Errorf("wrong number of lines expected 3 got %d", len(receipt.Lines))
+		return
+	}
+	// lines are sorted by product
+	expectedLine := ReceiptLine{
+		Product:   merchandise.PEN,
+		Name:      "Lana Pen",
+		UnitPrice: merchandise.Cents(500),
+		Quantity:  3,
+		Subtotal:  merchandise.Cents(1500),
+	}
+	if receipt.Lines[1] != expectedLine {
+		t.Errorf("wrong line expected %v got %v", expectedLine, receipt.Lines[1])
+	}
+	if len(receipt.Discounts) != 2 {
+		t.Errorf("wrong number of discounts expected 2 got %d", len(receipt.Discounts))
+		return
+	}
+	expectedDiscount := Discount{
+		Description: "Buy 2 Lana Pen and get 1 Free",
+		Amount:      merchandise.Cents(500),
+		Promotion:   PenBuy2Get1.ID,
+		Units:       map[string]int64{merchandise.PEN: 2},
+	}
+	if !reflect.DeepEqual(receipt.Discounts[0], expectedDiscount) {
+		t.Errorf("wrong discount expected %v got %v", expectedDiscount, receipt.Discounts[0])
+	}
+	if receipt.Discounts[1].Promotion != TshirtBuy3Get25OFF.ID {
+		t.Errorf("wrong promotion expected %s got %s", TshirtBuy3Get25OFF.ID, receipt.Discounts[1].Promotion)
+	}
+	// 15.00 + 60.00 + 7.50 = 82.50, discounts 5.00 + 15.00
+	if receipt.Subtotal != merchandise.Cents(8250) {
+		t.Errorf("wrong subtotal expected 82.50 EUR got %s", receipt.Subtotal)
+	}
+	if receipt.DiscountTotal != merchandise.Cents(2000) {
+		t.Errorf("wrong discount total expected 20.00 EUR got %s", receipt.DiscountTotal)
+	}
+	if receipt.Total != merchandise.Cents(6250) {
+		t.Errorf("wrong total expected 62.50 EUR got %s", receipt.Total)
+	}
+}
+
+func TestComputeReceiptEmpty(t *testing.T) {
+	receipt, err := computeReceipt(map[string]item{}, []Promotion{PenBuy2Get1})
+	if err != nil {
+		t.Errorf("computeReceipt returned an error %s", err.Error())
+		return
+	}
+	if len(receipt.Lines) != 0 || len(receipt.Discounts) != 0 || !receipt.Total.IsZero() {
+		t.Errorf("empty basket should have an empty receipt got %v", receipt)
+	}
+}
+
+func TestComputeReceiptPromotionError(t *testing.T) {
+	items := map[string]item{merchandise.PEN: {Product: merchandise.GetProduct(merchandise.PEN), Count: 1}}
+	_, err := computeReceipt(items, []Promotion{FailingPromo{}})
+	if err == nil || err.Error() != "some random error" {
+		t.Errorf("computeReceipt should have returned promotion error got %v", err)
+	}
+}
+
+func TestReceiptVolumePricing(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules[0])()
+	pen := merchandise.GetProduct(merchandise.PEN)
+	defer merchandise.UpdateProduct(pen)
+	tiered := pen
+	tiered.PriceTiers = []merchandise.PriceTier{{MinQuantity: 10, Price: merchandise.Cents(400)}, {MinQuantity: 50, Price: merchandise.Cents(300)}}
+	_ = merchandise.UpdateProduct(tiered)
+
+	cases := []struct {
+		count     int64
+		unitPrice int64
+		total     int64
+	}{
+		// 3 pens at 5.00, one free
+		{3, 500, 1000},
+		// 12 pens at 4.00, 6 free
+		{12, 400, 2400},
+		// 51 pens at 3.00, 25 free
+		{51, 300, 7800},
+	}
+	for _, tc := range cases {
+		basket, _ := NewBasket()
+		_, _ = basket.AddItem(ProductItem{Product: merchandise.PEN, Count: tc.count})
+		receipt, err := basket.GetReceipt()
+		if err != nil {
+			t.Errorf("GetReceipt returned an error %s", err.Error())
+			return
+		}
+		if receipt.Lines[0].UnitPrice != merchandise.Cents(tc.unitPrice) || receipt.Lines[0].Subtotal != merchandise.Cents(tc.unitPrice*tc.count) {
+			t.Errorf("%d pens wrong line %v", tc.count, receipt.Lines[0])
+		}
+		if total, _ := basket.GetTotal(); total != merchandise.Cents(tc.total) {
+			t.Errorf("%d pens expected total %d got %s", tc.count, tc.total, total)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"sync"
+)
+
+// promotion management errors
+var (
+	ErrPromotionNotFound = fmt.Errorf("Promotion not found")
+	ErrPromotionExists   = fmt.Errorf("Promotion already exists")
+)
+
+// InvalidPromotionError - returned when a promotion rule is not valid, Reason tells why
+type InvalidPromotionError struct {
+	Reason string
+}
+
+func (err InvalidPromotionError) Error() string {
+	return "Invalid promotion: " + err.Reason
+}
+
+// ManagedPromotion - model, promotion rule given to baskets and whether it is enabled
+// disabled promotions stop applying to every basket, including existing ones
+type ManagedPromotion struct {
+	PromotionRule
+	Enabled bool `json:"enabled"`
+}
+
+// PromotionToggle - DTO to enable or disable a promotion
+type PromotionToggle struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// DefaultPromotionRules - promotions used when no config is loaded
+var DefaultPromotionRules = []PromotionRule{
+	{ID: PenBuy2Get1.ID, Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 2, GetFreeQuantity: 1},
+	{ID: TshirtBuy3Get25OFF.ID, Type: BulkPercentageDiscountType, Code: merchandise.TSHIRT, BuyQuantity: 3, DiscountPercentage: 25},
+}
+
+// managed promotion along with the promotion built from its rule
+type registeredPromotion struct {
+	ManagedPromotion
+	promotion Promotion
+}
+
+// Mutex to syncronize access to managed promotions
+var promotionsLock = sync.RWMutex{}
+
+// promotions given to new baskets, in the order they were added
+var registeredPromotions = mustRegister(DefaultPromotionRules)
+
+// version of the promotion set, increased every time a rule is added, changed or removed
+var promotionsVersion int64 = 1
+
+func mustRegister(rules []PromotionRule) []registeredPromotion {
+	list, err := registerRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+// validate rule and build its promotion, an empty id gets its default
+func register(promotion ManagedPromotion) (registeredPromotion, error) {
+	if promotion.ID == "" {
+		promotion.ID = promotion.defaultID()
+	}
+	built, err := promotion.Build()
+	if err != nil {
+		return registeredPromotion{}, InvalidPromotionError{Reason: err.Error()}
+	}
+	return registeredPromotion{ManagedPromotion: promotion, promotion: built}, nil
+}
+
+func registerRules(rules []PromotionRule) ([]registeredPromotion, error) {
+	list := make([]registeredPromotion, len(rules))
+	seen := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		registered, err := register(ManagedPromotion{PromotionRule: rule, Enabled: true})
+		if err != nil {
+			return nil, err
+		}
+		if seen[registered.ID] {
+			return nil, InvalidPromotionError{Reason: fmt.Sprintf("duplicated id %s", registered.ID)}
+		}
+		seen[registered.ID] = true
+		list[i] = registered
+	}
+	return list, nil
+}
+
+// position of the promotion with id, -1 if there is none. Lock must be held
+func findPromotion(id string) int {
+	for i, registered := range registeredPromotions {
+		if registered.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetPromotionRules - replace every managed promotion (i.e. on config reload)
+// promotions that are kept stay disabled if they were
+func SetPromotionRules(rules []PromotionRule) error {
+	list, err := registerRules(rules)
+	if err != nil {
+		return err
+	}
+	promotionsLock.Lock()
+	defer promotionsLock.Unlock()
+	for i := range list {
+		if previous := findPromotion(list[i].ID); previous >= 0 {
+			list[i].Enabled = registeredPromotions[previous].Enabled
+		}
+	}
+	registeredPromotions = list
+	promotionsVersion++
+	return nil
+}
+
+// ListPromotions - every managed promotion, enabled or not
+func ListPromotions() []ManagedPromotion {
+	promotionsLock.RLock()
+	defer promotionsLock.RUnlock()
+	list := make([]ManagedPromotion, len(registeredPromotions))
+	for i, registered := range registeredPromotions {
+		list[i] = registered.ManagedPromotion
+	}
+	return list
+}
+
+// GetPromotion - Get a managed promotion by id
+func GetPromotion(id string) (ManagedPromotion, error) {
+	promotionsLock.RLock()
+	defer promotionsLock.RUnlock()
+	i := findPromotion(id)
+	if i < 0 {
+		return ManagedPromotion{}, ErrPromotionNotFound
+	}
+	return registeredPromotions[i].ManagedPromotion, nil
+}
+
+// CreatePromotion - validate and add a promotion, it is given to baskets created from now on
+func CreatePromotion(promotion ManagedPromotion) (ManagedPromotion, error) {
+	registered, err := register(promotion)
+	if err != nil {
+		return ManagedPromotion{}, err
+	}
+	promotionsLock.Lock()
+	defer promotionsLock.Unlock()
+	if findPromotion(registered.ID) >= 0 {
+		return ManagedPromotion{}, ErrPromotionExists
+	}
+	registeredPromotions = append(registeredPromotions, registered)
+	promotionsVersion++
+	return registered.ManagedPromotion, nil
+}
+
+// UpdatePromotion - replace the rule of a promotion, baskets created from now on get the new one
+// and existing baskets keep the rule they were created with (unless their policy is LivePromotions)
+func UpdatePromotion(id string, promotion ManagedPromotion) (ManagedPromotion, error) {
+	promotion.ID = id
+	registered, err := register(promotion)
+	if err != nil {
+		return ManagedPromotion{}, err
+	}
+	promotionsLock.Lock()
+	defer promotionsLock.Unlock()
+	i := findPromotion(id)
+	if i < 0 {
+		return ManagedPromotion{}, ErrPromotionNotFound
+	}
+	registeredPromotions[i] = registered
+	promotionsVersion++
+	return registered.ManagedPromotion, nil
+}
+
+// DeletePromotion - remove a promotion, it stops applying to every basket
+func DeletePromotion(id string) error {
+	promotionsLock.Lock()
+	defer promotionsLock.Unlock()
+	i := findPromotion(id)
+	if i < 0 {
+		return ErrPromotionNotFound
+	}
+	registeredPromotions = append(registeredPromotions[:i], registeredPromotions[i+1:]...)
+	promotionsVersion++
+	return nil
+}
+
+// EnablePromotion - enable or disable a promotion, takes effect on every basket
+func EnablePromotion(id string, enabled bool) (ManagedPromotion, error) {
+	promotionsLock.Lock()
+	defer promotionsLock.Unlock()
+	i := findPromotion(id)
+	if i < 0 {
+		return ManagedPromotion{}, ErrPromotionNotFound
+	}
+	registeredPromotions[i].Enabled = enabled
+	return registeredPromotions[i].ManagedPromotion, nil
+}
+
+// true if the promotion with id is managed and enabled
+func promotionEnabled(id string) bool {
+	promotionsLock.RLock()
+	defer promotionsLock.RUnlock()
+	i := findPromotion(id)
+	return i >= 0 && registeredPromotions[i].Enabled
+}
+
+// PromotionsVersion - version of the current promotion set
+// enabling or disabling promotions doesn't change it, toggles apply to every version
+func PromotionsVersion() int64 {
+	promotionsLock.RLock()
+	defer promotionsLock.RUnlock()
+	return promotionsVersion
+}
+
+// current promotion set, the rules it is built from and its version, disabled promotions
+// are included so enabling them again makes them apply to baskets that have the set
+func currentPromotions() ([]Promotion, []PromotionRule, int64) {
+	promotionsLock.RLock()
+	defer promotionsLock.RUnlock()
+	promotions := make([]Promotion, len(registeredPromotions))
+	rules := make([]PromotionRule, len(registeredPromotions))
+	for i, registered := range registeredPromotions {
+		promotions[i] = toggledPromotion{Promotion: registered.promotion, id: registered.ID}
+		rules[i] = registered.PromotionRule
+	}
+	return promotions, rules, promotionsVersion
+}
+
+// promotions every basket gets on creation
+func defaultPromotions() []Promotion {
+	promotions, _, _ := currentPromotions()
+	return promotions
+}
+
+// promotion set built from the rules pinned by a basket, rules that are not valid
+// anymore (i.e. their product was removed from the catalog) are left out
+func pinnedPromotions(rules []PromotionRule) []Promotion {
+	promotions := make([]Promotion, 0, len(rules))
+	for _, rule := range rules {
+		registered, err := register(ManagedPromotion{PromotionRule: rule, Enabled: true})
+		if err != nil {
+			continue
+		}
+		promotions = append(promotions, toggledPromotion{Promotion: registered.promotion, id: registered.ID})
+	}
+	return promotions
+}
+
+// managed promotion, applies only while it is enabled
+type toggledPromotion struct {
+	Promotion
+	id string
+}
+
+// Apply - apply wrapped promotion only while it is enabled
+func (promotion toggledPromotion) Apply(Items map[string]item) ([]Discount, error) {
+	if !promotionEnabled(promotion.id) {
+		return nil, nil
+	}
+	return promotion.Promotion.Apply(Items)
+}
+
+// ApplyToTotal - apply wrapped promotion only while it is enabled
+func (promotion toggledPromotion) ApplyToTotal(total merchandise.Money) ([]Discount, error) {
+	if !promotionEnabled(promotion.id) {
+		return nil, nil
+	}
+	return applyToTotal(promotion.Promotion, total)
+}
+
+// Products - products of the wrapped promotion
+func (promotion toggledPromotion) Products() []string {
+	return productsOf(promotion.Promotion)
+}
+
+// QuantityStep - quantities that change the result of the wrapped promotion
+func (promotion toggledPromotion) QuantityStep() (int64, int64) {
+	return quantityStepOf(promotion.Promotion)
+}
+
+// Stacking - stacking rules of the wrapped promotion
+func (promotion toggledPromotion) Stacking() Stacking {
+	return stackingOf(promotion.Promotion)
+}
@@ -0,0 +1,161 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"reflect"
+	"testing"
+)
+
+// replace managed promotions with rules (all enabled), returns a function restoring previous ones
+func resetPromotions(rules ...PromotionRule) func() {
+	list := mustRegister(rules)
+	promotionsLock.Lock()
+	previous := registeredPromotions
+	registeredPromotions = list
+	promotionsLock.Unlock()
+	return func() {
+		promotionsLock.Lock()
+		defer promotionsLock.Unlock()
+		registeredPromotions = previous
+	}
+}
+
+var mugBuy3Get1 = PromotionRule{ID: "MUG_BUY3_GET1", Type: BuyXGetYType, Code: merchandise.MUG, BuyQuantity: 3, GetFreeQuantity: 1}
+
+func TestDefaultPromotions(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	list := ListPromotions()
+	if len(list) != 2 || list[0].ID != "PEN_BUY2_GET1" || list[1].ID != "TSHIRT_BUY3_GET25OFF" || !list[0].Enabled || !list[1].Enabled {
+		t.Errorf("wrong default promotions %v", list)
+		return
+	}
+	promotions := defaultPromotions()
+	if promotions[0].(toggledPromotion).Promotion != PenBuy2Get1 || promotions[1].(toggledPromotion).Promotion != TshirtBuy3Get25OFF {
+		t.Errorf("default rules should build the default promotions got %v", promotions)
+	}
+}
+
+func TestCreatePromotion(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	created, err := CreatePromotion(ManagedPromotion{PromotionRule: PromotionRule{Type: BuyXGetYType, Code: merchandise.MUG, BuyQuantity: 3, GetFreeQuantity: 1}, Enabled: true})
+	if err != nil {
+		t.Errorf("CreatePromotion returned an error %s", err.Error())
+		return
+	}
+	if created.ID != "buy_x_get_y:MUG" {
+		t.Errorf("id should default to type and products got %s", created.ID)
+		return
+	}
+	if got, err := GetPromotion(created.ID); err != nil || !reflect.DeepEqual(got, created) {
+		t.Errorf("GetPromotion expected %v got %v %v", created, got, err)
+		return
+	}
+	if _, err := CreatePromotion(created); err != ErrPromotionExists {
+		t.Errorf("CreatePromotion should not allow duplicated ids got %v", err)
+	}
+	if len(ListPromotions()) != 3 {
+		t.Errorf("wrong number of promotions expected 3 got %d", len(ListPromotions()))
+	}
+}
+
+func TestPromotionValidation(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	cases := []struct {
+		rule     PromotionRule
+		expected string
+	}{
+		{PromotionRule{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 1, GetFreeQuantity: 1}, "Invalid promotion: buy_quantity must be greater than get_free_quantity"},
+		{PromotionRule{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 2}, "Invalid promotion: get_free_quantity must be positive"},
+		{PromotionRule{Type: BulkPercentageDiscountType, Code: merchandise.PEN, BuyQuantity: 2, DiscountPercentage: 120}, "Invalid promotion: discount_percentage must be between 1 and 100"},
+		{PromotionRule{Type: "free_lunch"}, "Invalid promotion: unknown promotion type \"free_lunch\""},
+	}
+	for _, tc := range cases {
+		_, err := CreatePromotion(ManagedPromotion{PromotionRule: tc.rule, Enabled: true})
+		if _, ok := err.(InvalidPromotionError); !ok || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+		_, err = UpdatePromotion(PenBuy2Get1.ID, ManagedPromotion{PromotionRule: tc.rule, Enabled: true})
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+	if len(ListPromotions()) != 2 {
+		t.Errorf("invalid promotions should not be added")
+	}
+	if err := SetPromotionRules([]PromotionRule{mugBuy3Get1, mugBuy3Get1}); err == nil || err.Error() != "Invalid promotion: duplicated id MUG_BUY3_GET1" {
+		t.Errorf("SetPromotionRules should reject duplicated ids got %v", err)
+	}
+}
+
+func TestUpdatePromotion(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	old, _ := NewBasket()
+	_, _ = old.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	rule := PromotionRule{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 3, GetFreeQuantity: 2}
+	updated, err := UpdatePromotion(PenBuy2Get1.ID, ManagedPromotion{PromotionRule: rule, Enabled: true})
+	if err != nil {
+		t.Errorf("UpdatePromotion returned an error %s", err.Error())
+		return
+	}
+	if updated.ID != PenBuy2Get1.ID || updated.GetFreeQuantity != 2 {
+		t.Errorf("wrong updated promotion %v", updated)
+		return
+	}
+	// new baskets get the new rule, existing ones keep theirs
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+	if total, _ := b.GetTotal(); total != merchandise.Cents(500) {
+		t.Errorf("new basket should use the updated rule got %s", total)
+	}
+	if total, _ := old.GetTotal(); total != merchandise.Cents(1000) {
+		t.Errorf("existing basket should keep its rule got %s", total)
+	}
+	if _, err := UpdatePromotion("NOPE", ManagedPromotion{PromotionRule: rule}); err != ErrPromotionNotFound {
+		t.Errorf("UpdatePromotion should have returned Promotion not found got %v", err)
+	}
+}
+
+func TestEnablePromotion(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	if _, err := EnablePromotion(PenBuy2Get1.ID, false); err != nil {
+		t.Errorf("EnablePromotion returned an error %s", err.Error())
+		return
+	}
+	// takes effect on existing baskets
+	if total, _ := b.GetTotal(); total != merchandise.Cents(1000) {
+		t.Errorf("disabled promotion should not apply got %s", total)
+		return
+	}
+	// reloading rules keeps the promotion disabled
+	_ = SetPromotionRules(DefaultPromotionRules)
+	if p, _ := GetPromotion(PenBuy2Get1.ID); p.Enabled {
+		t.Errorf("promotion should stay disabled after a reload")
+		return
+	}
+	_, _ = EnablePromotion(PenBuy2Get1.ID, true)
+	if total, _ := b.GetTotal(); total != merchandise.Cents(500) {
+		t.Errorf("enabled promotion should apply got %s", total)
+		return
+	}
+	if _, err := EnablePromotion("NOPE", true); err != ErrPromotionNotFound {
+		t.Errorf("EnablePromotion should have returned Promotion not found got %v", err)
+	}
+}
+
+func TestDeletePromotion(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 2})
+	if err := DeletePromotion(PenBuy2Get1.ID); err != nil {
+		t.Errorf("DeletePromotion returned an error %s", err.Error())
+		return
+	}
+	if total, _ := b.GetTotal(); total != merchandise.Cents(1000) {
+		t.Errorf("deleted promotion should not apply got %s", total)
+	}
+	if err := DeletePromotion(PenBuy2Get1.ID); err != ErrPromotionNotFound {
+		t.Errorf("DeletePromotion should have returned Promotion not found got %v", err)
+	}
+}
@@ -8,19 +8,39 @@ import (
 // AddRoutes - add routes for basket and checkout management
 func AddRoutes(rg *gin.RouterGroup) {
 
-	r := rg.Group("/basket")
+	// baskets of customers are only available to them
+	r := rg.Group("/basket", Authentication())
 
 	r.GET("/:id", func(c *gin.Context) {
 		id := c.Params.ByName("id")
 		HandleGetByID(c, id)
 	})
 
-	r.POST("/", func(c *gin.Context) {
-		HandleCreateEmtpyBasket(c)
+	r.GET("/:id/receipt", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleGetReceipt(c, id)
+	})
+
+	// optional body with basket options, retries with the same Idempotency-Key get the same basket
+	r.POST("/", Idempotent(), func(c *gin.Context) {
+		var options BasketOptions
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&options); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+		HandleCreateEmtpyBasket(c, options)
 	})
 
+	// Route list baskets, query string carries filters and page
 	r.GET("/", func(c *gin.Context) {
-		HandleGetAllBaskets(c)
+		var query BasketQuery
+		if err := c.BindQuery(&query); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleGetAllBaskets(c, query)
 	})
 
 	r.DELETE("/:id", func(c *gin.Context) {
@@ -28,8 +48,8 @@ func AddRoutes(rg *gin.RouterGroup) {
 		HandleDeleteBasket(c, id)
 	})
 
-	// Route add product
-	r.POST("/:id", func(c *gin.Context) {
+	// Route add product, retries with the same Idempotency-Key are not added twice
+	r.POST("/:id", Idempotent(), func(c *gin.Context) {
 		var _item ProductItem
 		id := c.Params.ByName("id")
 		if err := c.BindJSON(&_item); err != nil {
@@ -38,4 +58,181 @@ func AddRoutes(rg *gin.RouterGroup) {
 		}
 		HandleAddProduct(c, id, _item)
 	})
+
+	// Route set product count (PUT and PATCH share semantics, body only carries count)
+	setQuantity := func(c *gin.Context) {
+		var _item ProductItem
+		id := c.Params.ByName("id")
+		if err := c.BindJSON(&_item); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		_item.Product = c.Params.ByName("product")
+		HandleSetQuantity(c, id, _item)
+	}
+	r.PUT("/:id/items/:product", setQuantity)
+	r.PATCH("/:id/items/:product", setQuantity)
+
+	// Route remove product
+	r.DELETE("/:id/items/:product", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleRemoveProduct(c, id, c.Params.ByName("product"))
+	})
+
+	// Route clear basket
+	r.DELETE("/:id/items", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleClearBasket(c, id)
+	})
+
+	// Routes attach and detach coupons, body carries the code
+	coupon := func(handler func(*gin.Context, string, CouponCode)) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			var coupon CouponCode
+			id := c.Params.ByName("id")
+			if err := c.BindJSON(&coupon); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			handler(c, id, coupon)
+		}
+	}
+	r.POST("/:id/coupons", coupon(HandleApplyCoupon))
+	r.DELETE("/:id/coupons", coupon(HandleRemoveCoupon))
+
+	// Route checkout
+	// optional body with a card to pay the order on checkout, retries with the same
+	// Idempotency-Key get the same order
+	r.POST("/:id/checkout", Idempotent(), func(c *gin.Context) {
+		var card *PaymentCard
+		id := c.Params.ByName("id")
+		if c.Request.ContentLength != 0 {
+			card = &PaymentCard{}
+			if err := c.BindJSON(card); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+		HandleCheckout(c, id, card)
+	})
+
+	cu := rg.Group("/customers", Authentication())
+
+	cu.POST("/", func(c *gin.Context) {
+		var registration CustomerRegistration
+		if err := c.BindJSON(&registration); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleRegisterCustomer(c, registration)
+	})
+
+	cu.GET("/me", func(c *gin.Context) {
+		HandleGetCurrentCustomer(c)
+	})
+
+	// Route login, optional body with the anonymous basket to merge
+	cu.POST("/login", func(c *gin.Context) {
+		var login Login
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&login); err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+		}
+		HandleLogin(c, login)
+	})
+
+	// orders of customers are only available to them
+	o := rg.Group("/orders", Authentication())
+
+	o.GET("/", func(c *gin.Context) {
+		HandleGetAllOrders(c)
+	})
+
+	o.GET("/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleGetOrder(c, id)
+	})
+
+	// Route pay order, body carries the card
+	o.POST("/:id/pay", func(c *gin.Context) {
+		var card PaymentCard
+		id := c.Params.ByName("id")
+		if err := c.BindJSON(&card); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandlePayOrder(c, id, card)
+	})
+
+	// Routes order lifecycle, each action moves the order to a state
+	// customers can cancel their orders, fulfilling and refunding them is for admins
+	transition := func(state OrderState) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id := c.Params.ByName("id")
+			HandleOrderTransition(c, id, state)
+		}
+	}
+	o.POST("/:id/cancel", transition(Cancelled))
+	o.POST("/:id/fulfill", AdminOnly(), transition(Fulfilled))
+	o.POST("/:id/refund", AdminOnly(), transition(Refunded))
+
+	// anyone can see promotions, changing them is for admins
+	p := rg.Group("/promotions", Authentication())
+
+	p.GET("/", func(c *gin.Context) {
+		HandleGetAllPromotions(c)
+	})
+
+	p.GET("/:id", func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleGetPromotion(c, id)
+	})
+
+	// Routes create and replace promotions, enabled defaults to true
+	p.POST("/", AdminOnly(), func(c *gin.Context) {
+		promotion := ManagedPromotion{Enabled: true}
+		if err := c.BindJSON(&promotion); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleCreatePromotion(c, promotion)
+	})
+
+	// Route preview the receipt of items with a set of promotions, admins only
+	p.POST("/simulate", AdminOnly(), func(c *gin.Context) {
+		var simulation Simulation
+		if err := c.BindJSON(&simulation); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleSimulatePromotions(c, simulation)
+	})
+
+	p.PUT("/:id", AdminOnly(), func(c *gin.Context) {
+		promotion := ManagedPromotion{Enabled: true}
+		id := c.Params.ByName("id")
+		if err := c.BindJSON(&promotion); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleUpdatePromotion(c, id, promotion)
+	})
+
+	p.DELETE("/:id", AdminOnly(), func(c *gin.Context) {
+		id := c.Params.ByName("id")
+		HandleDeletePromotion(c, id)
+	})
+
+	// Route enable or disable a promotion, body only carries enabled
+	p.PATCH("/:id", AdminOnly(), func(c *gin.Context) {
+		var toggle PromotionToggle
+		id := c.Params.ByName("id")
+		if err := c.BindJSON(&toggle); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleTogglePromotion(c, id, toggle)
+	})
 }
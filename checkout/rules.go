@@ -0,0 +1,496 @@
+package checkout
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PromotionRule - model, declarative definition of a promotion
+// only the fields used by Type are taken into account, prices are in cents
+// ID defaults to type and products (i.e. buy_x_get_y:PEN) when empty
+type PromotionRule struct {
+	ID                 string           `json:"id,omitempty" yaml:"id"`
+	Type               string           `json:"type" yaml:"type"`
+	Code               string           `json:"code,omitempty" yaml:"code"`
+	Codes              []string         `json:"codes,omitempty" yaml:"codes"`
+	Items              map[string]int64 `json:"items,omitempty" yaml:"items"`
+	GetCode            string           `json:"get_code,omitempty" yaml:"get_code"`
+	BuyQuantity        int64            `json:"buy_quantity,omitempty" yaml:"buy_quantity"`
+	GetFreeQuantity    int64            `json:"get_free_quantity,omitempty" yaml:"get_free_quantity"`
+	DiscountPercentage int64            `json:"discount_percentage,omitempty" yaml:"discount_percentage"`
+	Price              int64            `json:"price,omitempty" yaml:"price"`
+	Threshold          int64            `json:"threshold,omitempty" yaml:"threshold"`
+	AmountOff          int64            `json:"amount_off,omitempty" yaml:"amount_off"`
+	Tiers              []SpendTierRule  `json:"tiers,omitempty" yaml:"tiers"`
+	ValidFrom          *time.Time       `json:"valid_from,omitempty" yaml:"valid_from"`
+	ValidUntil         *time.Time       `json:"valid_until,omitempty" yaml:"valid_until"`
+	// Days - days of the week the promotion is active (i.e. [saturday, sunday]), every day when empty
+	Days []string `json:"days,omitempty" yaml:"days"`
+	// StartTime and EndTime - time of day (HH:MM) the promotion is active, the whole day when empty
+	StartTime string `json:"start_time,omitempty" yaml:"start_time"`
+	EndTime   string `json:"end_time,omitempty" yaml:"end_time"`
+	// Timezone - IANA name (i.e. Europe/Madrid) days and times are evaluated in, defaults to UTC
+	Timezone string `json:"timezone,omitempty" yaml:"timezone"`
+	// Priority - higher priority promotions are applied first
+	Priority int64 `json:"priority,omitempty" yaml:"priority"`
+	// Group - exclusivity group, only one promotion of a group is applied
+	Group string `json:"group,omitempty" yaml:"group"`
+	// Stackable - false means the promotion is never combined with others, defaults to true
+	Stackable *bool `json:"stackable,omitempty" yaml:"stackable"`
+}
+
+// SpendTierRule - model, one tier of a spend discount, prices are in cents
+type SpendTierRule struct {
+	Threshold          int64 `json:"threshold" yaml:"threshold"`
+	DiscountPercentage int64 `json:"discount_percentage,omitempty" yaml:"discount_percentage"`
+	AmountOff          int64 `json:"amount_off,omitempty" yaml:"amount_off"`
+}
+
+// PromotionConfig - layout of promotion config files
+type PromotionConfig struct {
+	Promotions []PromotionRule `json:"promotions" yaml:"promotions"`
+	Coupons    []CouponRule    `json:"coupons,omitempty" yaml:"coupons"`
+}
+
+// PromotionFactory - builds a Promotion from its rule, must validate rule parameters
+type PromotionFactory func(PromotionRule) (Promotion, error)
+
+// rule type names
+const (
+	BuyXGetYType               = "buy_x_get_y"
+	BulkPercentageDiscountType = "bulk_percentage_discount"
+	BundleType                 = "bundle"
+	MixAndMatchType            = "mix_and_match"
+	CrossProductType           = "cross_product"
+	SpendThresholdType         = "spend_threshold"
+	TieredSpendType            = "tiered_spend"
+)
+
+var promotionTypes = map[string]PromotionFactory{
+	BuyXGetYType:               newBuyXGetY,
+	BulkPercentageDiscountType: newBulkPercentageDiscount,
+	BundleType:                 newBundle,
+	MixAndMatchType:            newMixAndMatch,
+	CrossProductType:           newCrossProduct,
+	SpendThresholdType:         newSpendThreshold,
+	TieredSpendType:            newTieredSpend,
+}
+
+// RegisterPromotionType - make a new promotion type available to rules
+func RegisterPromotionType(name string, factory PromotionFactory) {
+	promotionTypes[name] = factory
+}
+
+// promotion restricted to a validity window and a recurring schedule, zero times mean no limit
+type windowedPromotion struct {
+	Promotion
+	from     time.Time
+	until    time.Time
+	schedule Schedule
+}
+
+func (promotion windowedPromotion) active(t time.Time) bool {
+	if !promotion.from.IsZero() && t.Before(promotion.from) {
+		return false
+	}
+	if !promotion.until.IsZero() && !t.Before(promotion.until) {
+		return false
+	}
+	return promotion.schedule.Active(t)
+}
+
+// Apply - apply wrapped promotion only while it is valid
+func (promotion windowedPromotion) Apply(Items map[string]item) ([]Discount, error) {
+	if !promotion.active(now()) {
+		return nil, nil
+	}
+	return promotion.Promotion.Apply(Items)
+}
+
+func validateCode(code string) error {
+	if code == "" {
+		return fmt.Errorf("code is required")
+	}
+	if !merchandise.IsValidProduct(code) {
+		return fmt.Errorf("unknown product %s", code)
+	}
+	return nil
+}
+
+func newBuyXGetY(rule PromotionRule) (Promotion, error) {
+	if err := validateCode(rule.Code); err != nil {
+		return nil, err
+	}
+	if rule.GetFreeQuantity <= 0 {
+		return nil, fmt.Errorf("get_free_quantity must be positive")
+	}
+	if rule.BuyQuantity <= rule.GetFreeQuantity {
+		return nil, fmt.Errorf("buy_quantity must be greater than get_free_quantity")
+	}
+	return BuyXGetY{ID: rule.ID, Code: rule.Code, BuyQuantity: rule.BuyQuantity, GetFreeQuantity: rule.GetFreeQuantity}, nil
+}
+
+func newBulkPercentageDiscount(rule PromotionRule) (Promotion, error) {
+	if err := validateCode(rule.Code); err != nil {
+		return nil, err
+	}
+	if rule.BuyQuantity <= 0 {
+		return nil, fmt.Errorf("buy_quantity must be positive")
+	}
+	if rule.DiscountPercentage <= 0 || rule.DiscountPercentage > 100 {
+		return nil, fmt.Errorf("discount_percentage must be between 1 and 100")
+	}
+	return BulkPercentageDiscount{ID: rule.ID, Code: rule.Code, BuyQuantity: rule.BuyQuantity, DiscountPercentage: rule.DiscountPercentage}, nil
+}
+
+func validatePrice(price int64) error {
+	if price <= 0 {
+		return fmt.Errorf("price must be positive")
+	}
+	return nil
+}
+
+func newBundle(rule PromotionRule) (Promotion, error) {
+	if len(rule.Items) < 2 {
+		return nil, fmt.Errorf("items must have at least 2 products")
+	}
+	items := make(map[string]int64, len(rule.Items))
+	for code, quantity := range rule.Items {
+		if err := validateCode(code); err != nil {
+			return nil, err
+		}
+		if quantity <= 0 {
+			return nil, fmt.Errorf("quantity of %s must be positive", code)
+		}
+		items[code] = quantity
+	}
+	if err := validatePrice(rule.Price); err != nil {
+		return nil, err
+	}
+	return Bundle{ID: rule.ID, Items: items, Price: merchandise.Cents(rule.Price)}, nil
+}
+
+func newMixAndMatch(rule PromotionRule) (Promotion, error) {
+	if len(rule.Codes) == 0 {
+		return nil, fmt.Errorf("codes are required")
+	}
+	seen := make(map[string]bool, len(rule.Codes))
+	for _, code := range rule.Codes {
+		if err := validateCode(code); err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			return nil, fmt.Errorf("duplicated code %s", code)
+		}
+		seen[code] = true
+	}
+	if rule.BuyQuantity < 2 {
+		return nil, fmt.Errorf("buy_quantity must be at least 2")
+	}
+	if err := validatePrice(rule.Price); err != nil {
+		return nil, err
+	}
+	codes := append([]string(nil), rule.Codes...)
+	return MixAndMatch{ID: rule.ID, Codes: codes, Quantity: rule.BuyQuantity, Price: merchandise.Cents(rule.Price)}, nil
+}
+
+func newCrossProduct(rule PromotionRule) (Promotion, error) {
+	if err := validateCode(rule.Code); err != nil {
+		return nil, err
+	}
+	if err := validateCode(rule.GetCode); err != nil {
+		return nil, fmt.Errorf("get_%s", err.Error())
+	}
+	if rule.Code == rule.GetCode {
+		return nil, fmt.Errorf("get_code must be a different product, use buy_x_get_y instead")
+	}
+	if rule.BuyQuantity <= 0 {
+		return nil, fmt.Errorf("buy_quantity must be positive")
+	}
+	if rule.GetFreeQuantity <= 0 {
+		return nil, fmt.Errorf("get_free_quantity must be positive")
+	}
+	percentage := rule.DiscountPercentage
+	if percentage == 0 {
+		percentage = 100
+	}
+	if percentage < 0 || percentage > 100 {
+		return nil, fmt.Errorf("discount_percentage must be between 1 and 100")
+	}
+	return CrossProduct{
+		ID:                 rule.ID,
+		BuyCode:            rule.Code,
+		BuyQuantity:        rule.BuyQuantity,
+		GetCode:            rule.GetCode,
+		GetQuantity:        rule.GetFreeQuantity,
+		DiscountPercentage: percentage,
+	}, nil
+}
+
+func (tier SpendTierRule) build() (SpendTier, error) {
+	if tier.Threshold < 0 {
+		return SpendTier{}, fmt.Errorf("threshold can't be negative")
+	}
+	if (tier.DiscountPercentage == 0) == (tier.AmountOff == 0) {
+		return SpendTier{}, fmt.Errorf("either discount_percentage or amount_off is required")
+	}
+	if tier.DiscountPercentage < 0 || tier.DiscountPercentage > 100 {
+		return SpendTier{}, fmt.Errorf("discount_percentage must be between 1 and 100")
+	}
+	if tier.AmountOff < 0 {
+		return SpendTier{}, fmt.Errorf("amount_off must be positive")
+	}
+	return SpendTier{
+		Threshold:  merchandise.Cents(tier.Threshold),
+		Percentage: tier.DiscountPercentage,
+		Amount:     merchandise.Cents(tier.AmountOff),
+	}, nil
+}
+
+func newSpendThreshold(rule PromotionRule) (Promotion, error) {
+	tier, err := SpendTierRule{Threshold: rule.Threshold, DiscountPercentage: rule.DiscountPercentage, AmountOff: rule.AmountOff}.build()
+	if err != nil {
+		return nil, err
+	}
+	return SpendDiscount{ID: rule.ID, Tiers: []SpendTier{tier}}, nil
+}
+
+func newTieredSpend(rule PromotionRule) (Promotion, error) {
+	if len(rule.Tiers) == 0 {
+		return nil, fmt.Errorf("tiers are required")
+	}
+	tiers := make([]SpendTier, len(rule.Tiers))
+	seen := make(map[int64]bool, len(rule.Tiers))
+	for i, tierRule := range rule.Tiers {
+		tier, err := tierRule.build()
+		if err != nil {
+			return nil, fmt.Errorf("tier %d: %s", i+1, err.Error())
+		}
+		if seen[tierRule.Threshold] {
+			return nil, fmt.Errorf("tier %d: duplicated threshold %d", i+1, tierRule.Threshold)
+		}
+		seen[tierRule.Threshold] = true
+		tiers[i] = tier
+	}
+	return SpendDiscount{ID: rule.ID, Tiers: tiers}, nil
+}
+
+// default rule id, type and the products it uses
+func (rule PromotionRule) defaultID() string {
+	codes := rule.Codes
+	switch {
+	case rule.Code != "" && rule.GetCode != "":
+		codes = []string{rule.Code, rule.GetCode}
+	case rule.Code != "":
+		codes = []string{rule.Code}
+	case len(rule.Items) > 0:
+		codes = make([]string, 0, len(rule.Items))
+		for code := range rule.Items {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+	}
+	if len(codes) == 0 {
+		// basket-wide promotions
+		if rule.Threshold > 0 {
+			return fmt.Sprintf("%s:%d", rule.Type, rule.Threshold)
+		}
+		return rule.Type
+	}
+	return rule.Type + ":" + strings.Join(codes, "+")
+}
+
+// Build - validate the rule and create the promotion it describes
+func (rule PromotionRule) Build() (Promotion, error) {
+	factory, ok := promotionTypes[rule.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown promotion type %q", rule.Type)
+	}
+	if rule.ID == "" {
+		rule.ID = rule.defaultID()
+	}
+	promotion, err := factory(rule)
+	if err != nil {
+		return nil, err
+	}
+	promotion, err = rule.window(promotion)
+	if err != nil {
+		return nil, err
+	}
+	stacking := Stacking{Priority: rule.Priority, Group: rule.Group, Stackable: rule.Stackable == nil || *rule.Stackable}
+	if stacking == DefaultStacking {
+		return promotion, nil
+	}
+	return stackedPromotion{Promotion: promotion, stacking: stacking}, nil
+}
+
+// restrict promotion to the rule validity window and schedule
+func (rule PromotionRule) window(promotion Promotion) (Promotion, error) {
+	schedule, err := rule.schedule()
+	if err != nil {
+		return nil, err
+	}
+	if rule.ValidFrom == nil && rule.ValidUntil == nil && schedule == nil {
+		return promotion, nil
+	}
+	windowed := windowedPromotion{Promotion: promotion}
+	if rule.ValidFrom != nil {
+		windowed.from = *rule.ValidFrom
+	}
+	if rule.ValidUntil != nil {
+		windowed.until = *rule.ValidUntil
+	}
+	if !windowed.from.IsZero() && !windowed.until.IsZero() && !windowed.from.Before(windowed.until) {
+		return nil, fmt.Errorf("valid_from must be before valid_until")
+	}
+	if schedule != nil {
+		windowed.schedule = *schedule
+	}
+	return windowed, nil
+}
+
+// recurring schedule of the rule, nil if it is active every day at any time
+func (rule PromotionRule) schedule() (*Schedule, error) {
+	if len(rule.Days) == 0 && rule.StartTime == "" && rule.EndTime == "" {
+		if rule.Timezone != "" {
+			return nil, fmt.Errorf("timezone is only used with days, start_time or end_time")
+		}
+		return nil, nil
+	}
+	schedule := Schedule{Location: time.UTC}
+	var err error
+	if schedule.Days, err = parseWeekdays(rule.Days); err != nil {
+		return nil, err
+	}
+	if rule.StartTime != "" {
+		if schedule.Start, err = parseTimeOfDay(rule.StartTime); err != nil {
+			return nil, fmt.Errorf("start_time: %s", err.Error())
+		}
+		if schedule.Start >= 24*time.Hour {
+			return nil, fmt.Errorf("start_time must be before 24:00")
+		}
+	}
+	if rule.EndTime != "" {
+		if schedule.End, err = parseTimeOfDay(rule.EndTime); err != nil {
+			return nil, fmt.Errorf("end_time: %s", err.Error())
+		}
+	}
+	if rule.StartTime != "" && rule.EndTime != "" && schedule.Start == schedule.End%(24*time.Hour) {
+		return nil, fmt.Errorf("start_time and end_time can't be the same")
+	}
+	if rule.Timezone != "" {
+		if schedule.Location, err = time.LoadLocation(rule.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", rule.Timezone)
+		}
+	}
+	return &schedule, nil
+}
+
+func parseConfig(data []byte, format string) (config PromotionConfig, err error) {
+	switch format {
+	case "json":
+		// unknown fields are rejected like in yaml so typos don't go unnoticed
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	case "yaml", "yml":
+		err = yaml.UnmarshalStrict(data, &config)
+	default:
+		err = fmt.Errorf("unsupported promotion config format %q", format)
+	}
+	return
+}
+
+// ParsePromotions - parse a promotion config (json or yaml) and build its promotions
+// any invalid rule makes the whole config invalid
+func ParsePromotions(data []byte, format string) ([]Promotion, error) {
+	_, promotions, err := parsePromotions(data, format)
+	return promotions, err
+}
+
+// ParsePromotionRules - parse and validate the promotion rules of a config (json or yaml)
+// any invalid rule makes the whole config invalid, empty ids get their default
+func ParsePromotionRules(data []byte, format string) ([]PromotionRule, error) {
+	rules, _, err := parsePromotions(data, format)
+	return rules, err
+}
+
+func parsePromotions(data []byte, format string) ([]PromotionRule, []Promotion, error) {
+	config, err := parseConfig(data, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules := make([]PromotionRule, len(config.Promotions))
+	promotions := make([]Promotion, len(config.Promotions))
+	seen := make(map[string]bool, len(config.Promotions))
+	for i, rule := range config.Promotions {
+		if rule.ID == "" {
+			rule.ID = rule.defaultID()
+		}
+		promotion, err := rule.Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("promotion %d: %s", i+1, err.Error())
+		}
+		if seen[rule.ID] {
+			return nil, nil, fmt.Errorf("promotion %d: duplicated id %s", i+1, rule.ID)
+		}
+		seen[rule.ID] = true
+		rules[i] = rule
+		promotions[i] = promotion
+	}
+	return rules, promotions, nil
+}
+
+// ParseCoupons - parse the coupons of a promotion config (json or yaml)
+// any invalid coupon makes the whole config invalid
+func ParseCoupons(data []byte, format string) ([]Coupon, error) {
+	config, err := parseConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Coupon, len(config.Coupons))
+	seen := make(map[string]bool, len(config.Coupons))
+	for i, rule := range config.Coupons {
+		coupon, err := rule.Build()
+		if err != nil {
+			return nil, fmt.Errorf("coupon %d: %s", i+1, err.Error())
+		}
+		if seen[coupon.Code] {
+			return nil, fmt.Errorf("coupon %d: duplicated code %s", i+1, coupon.Code)
+		}
+		seen[coupon.Code] = true
+		list[i] = coupon
+	}
+	return list, nil
+}
+
+// LoadPromotionsFile - read a promotion config file and make its promotions and coupons active
+// on error the active promotions and coupons are left untouched
+func LoadPromotionsFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	rules, err := ParsePromotionRules(data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	list, err := ParseCoupons(data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := SetPromotionRules(rules); err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	SetCoupons(list)
+	return nil
+}
@@ -0,0 +1,342 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlPromotions = `
+promotions:
+  - type: buy_x_get_y
+    code: MUG
+    buy_quantity: 3
+    get_free_quantity: 1
+  - type: bulk_percentage_discount
+    code: PEN
+    buy_quantity: 10
+    discount_percentage: 10
+    valid_from: 2020-01-01T00:00:00Z
+    valid_until: 2020-02-01T00:00:00Z
+coupons:
+  - code: welcome
+    single_use: true
+    promotion:
+      type: bulk_percentage_discount
+      code: MUG
+      buy_quantity: 1
+      discount_percentage: 10
+`
+
+const jsonPromotions = `{"promotions": [
+	{"type": "buy_x_get_y", "code": "MUG", "buy_quantity": 3, "get_free_quantity": 1}
+]}`
+
+func TestParsePromotionsYaml(t *testing.T) {
+	promotions, err := ParsePromotions([]byte(yamlPromotions), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	if len(promotions) != 2 {
+		t.Errorf("wrong number of promotions expected 2 got %d", len(promotions))
+		return
+	}
+	expected := BuyXGetY{ID: "buy_x_get_y:MUG", Code: merchandise.MUG, BuyQuantity: 3, GetFreeQuantity: 1}
+	if promotions[0] != expected {
+		t.Errorf("wrong promotion expected %v got %v", expected, promotions[0])
+		return
+	}
+	windowed, ok := promotions[1].(windowedPromotion)
+	if !ok {
+		t.Errorf("promotion with validity window should be windowed got %T", promotions[1])
+		return
+	}
+	if !windowed.from.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong valid_from %s", windowed.from)
+	}
+}
+
+func TestParsePromotionsJSON(t *testing.T) {
+	promotions, err := ParsePromotions([]byte(jsonPromotions), "json")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	if len(promotions) != 1 {
+		t.Errorf("wrong number of promotions expected 1 got %d", len(promotions))
+	}
+}
+
+func TestParsePromotionsInvalidRules(t *testing.T) {
+	cases := []struct {
+		config   string
+		expected string
+	}{
+		{"promotions: [{type: free_lunch, code: PEN}]", "promotion 1: unknown promotion type \"free_lunch\""},
+		{"promotions: [{type: buy_x_get_y, buy_quantity: 2, get_free_quantity: 1}]", "promotion 1: code is required"},
+		{"promotions: [{type: buy_x_get_y, code: FUEL, buy_quantity: 2, get_free_quantity: 1}]", "promotion 1: unknown product FUEL"},
+		{"promotions: [{type: buy_x_get_y, code: PEN, buy_quantity: 1, get_free_quantity: 1}]", "promotion 1: buy_quantity must be greater than get_free_quantity"},
+		{"promotions: [{type: buy_x_get_y, code: PEN, buy_quantity: 2}]", "promotion 1: get_free_quantity must be positive"},
+		{"promotions: [{type: bulk_percentage_discount, code: PEN, discount_percentage: 10}]", "promotion 1: buy_quantity must be positive"},
+		{"promotions: [{type: bulk_percentage_discount, code: PEN, buy_quantity: 3, discount_percentage: 101}]", "promotion 1: discount_percentage must be between 1 and 100"},
+		{"promotions: [{type: bulk_percentage_discount, code: PEN, buy_quantity: 3, discount_percentage: 10, valid_from: 2020-02-01T00:00:00Z, valid_until: 2020-01-01T00:00:00Z}]", "promotion 1: valid_from must be before valid_until"},
+	}
+	for _, tc := range cases {
+		_, err := ParsePromotions([]byte(tc.config), "yaml")
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+	// unknown fields are most likely typos
+	if _, err := ParsePromotions([]byte("promotions: [{type: buy_x_get_y, code: PEN, buy: 2}]"), "yaml"); err == nil {
+		t.Errorf("ParsePromotions should reject unknown fields")
+	}
+	if _, err := ParsePromotions([]byte(jsonPromotions), "toml"); err == nil {
+		t.Errorf("ParsePromotions should reject unknown formats")
+	}
+}
+
+func TestWindowedPromotion(t *testing.T) {
+	defer SetClock(SystemClock)
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	promotion := windowedPromotion{Promotion: PenBuy2Get1, from: from, until: until}
+	items := map[string]item{merchandise.PEN: {Product: merchandise.GetProduct(merchandise.PEN), Count: 2}}
+
+	cases := []struct {
+		at      time.Time
+		applies bool
+	}{
+		{from.Add(-time.Second), false},
+		{from, true},
+		{until.Add(-time.Second), true},
+		{until, false},
+	}
+	for _, tc := range cases {
+		SetClock(FixedClock(tc.at))
+		discounts, _ := promotion.Apply(items)
+		if (len(discounts) == 1) != tc.applies {
+			t.Errorf("promotion at %s should apply: %t", tc.at, tc.applies)
+		}
+	}
+}
+
+func TestLoadPromotionsFile(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	defer resetCoupons()()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "promotions.yaml")
+	_ = ioutil.WriteFile(path, []byte(yamlPromotions), 0644)
+	if err := LoadPromotionsFile(path); err != nil {
+		t.Errorf("LoadPromotionsFile returned an error %s", err.Error())
+		return
+	}
+	if len(defaultPromotions()) != 2 {
+		t.Errorf("wrong number of promotions expected 2 got %d", len(defaultPromotions()))
+		return
+	}
+	// new baskets get loaded promotions: buy 3 mugs get 1 free
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.MUG, Count: 3})
+	total, _ := b.GetTotal()
+	expected := merchandise.Cents(1500)
+	if total != expected {
+		t.Errorf("invalid total expected %s got %s", expected, total)
+		return
+	}
+
+	if _, err := GetCoupon("WELCOME"); err != nil {
+		t.Errorf("coupons were not loaded")
+		return
+	}
+
+	// an invalid file keeps current promotions
+	bad := filepath.Join(dir, "bad.json")
+	_ = ioutil.WriteFile(bad, []byte("{\"promotions\": [{\"type\": \"nope\"}]}"), 0644)
+	if err := LoadPromotionsFile(bad); err == nil {
+		t.Errorf("LoadPromotionsFile should have returned an error")
+		return
+	}
+	if len(defaultPromotions()) != 2 {
+		t.Errorf("promotions should not change after a failed load")
+	}
+	// as well as unknown fields
+	typo := filepath.Join(dir, "typo.json")
+	_ = ioutil.WriteFile(typo, []byte("{\"promotions\": [{\"type\": \"buy_x_get_y\", \"code\": \"MUG\", \"buy_quantity\": 1, \"get_free_quantity\": 1, \"valid_untill\": \"2020-01-01T00:00:00Z\"}]}"), 0644)
+	if err := LoadPromotionsFile(typo); err == nil || !strings.Contains(err.Error(), "valid_untill") {
+		t.Errorf("LoadPromotionsFile should have rejected unknown fields got %v", err)
+		return
+	}
+	if err := LoadPromotionsFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("LoadPromotionsFile should have returned an error for missing files")
+	}
+}
+
+func TestParsePromotionsStacking(t *testing.T) {
+	config := `
+promotions:
+  - type: buy_x_get_y
+    code: PEN
+    buy_quantity: 2
+    get_free_quantity: 1
+    priority: 5
+    group: pens
+    stackable: false
+`
+	promotions, err := ParsePromotions([]byte(config), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	expected := Stacking{Priority: 5, Group: "pens", Stackable: false}
+	if stacking := stackingOf(promotions[0]); stacking != expected {
+		t.Errorf("wrong stacking expected %v got %v", expected, stacking)
+	}
+}
+
+func TestParsePromotionsMultiProduct(t *testing.T) {
+	config := `
+promotions:
+  - type: bundle
+    items: {TSHIRT: 1, MUG: 1}
+    price: 2500
+  - type: mix_and_match
+    codes: [PEN, MUG]
+    buy_quantity: 3
+    price: 1500
+  - type: cross_product
+    code: TSHIRT
+    buy_quantity: 1
+    get_code: PEN
+    get_free_quantity: 1
+`
+	promotions, err := ParsePromotions([]byte(config), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	expected := []Promotion{
+		Bundle{ID: "bundle:MUG+TSHIRT", Items: map[string]int64{merchandise.TSHIRT: 1, merchandise.MUG: 1}, Price: merchandise.Cents(2500)},
+		MixAndMatch{ID: "mix_and_match:PEN+MUG", Codes: []string{merchandise.PEN, merchandise.MUG}, Quantity: 3, Price: merchandise.Cents(1500)},
+		CrossProduct{ID: "cross_product:TSHIRT+PEN", BuyCode: merchandise.TSHIRT, BuyQuantity: 1, GetCode: merchandise.PEN, GetQuantity: 1, DiscountPercentage: 100},
+	}
+	if !reflect.DeepEqual(promotions, expected) {
+		t.Errorf("wrong promotions expected %v got %v", expected, promotions)
+	}
+
+	cases := []struct {
+		config   string
+		expected string
+	}{
+		{"promotions: [{type: bundle, items: {TSHIRT: 1}, price: 100}]", "promotion 1: items must have at least 2 products"},
+		{"promotions: [{type: bundle, items: {TSHIRT: 1, FUEL: 1}, price: 100}]", "promotion 1: unknown product FUEL"},
+		{"promotions: [{type: bundle, items: {TSHIRT: 1, MUG: 0}, price: 100}]", "promotion 1: quantity of MUG must be positive"},
+		{"promotions: [{type: bundle, items: {TSHIRT: 1, MUG: 1}}]", "promotion 1: price must be positive"},
+		{"promotions: [{type: mix_and_match, buy_quantity: 3, price: 100}]", "promotion 1: codes are required"},
+		{"promotions: [{type: mix_and_match, codes: [PEN, PEN], buy_quantity: 3, price: 100}]", "promotion 1: duplicated code PEN"},
+		{"promotions: [{type: mix_and_match, codes: [PEN, MUG], buy_quantity: 1, price: 100}]", "promotion 1: buy_quantity must be at least 2"},
+		{"promotions: [{type: cross_product, code: TSHIRT, buy_quantity: 1, get_free_quantity: 1}]", "promotion 1: get_code is required"},
+		{"promotions: [{type: cross_product, code: PEN, get_code: PEN, buy_quantity: 1, get_free_quantity: 1}]", "promotion 1: get_code must be a different product, use buy_x_get_y instead"},
+		{"promotions: [{type: cross_product, code: TSHIRT, get_code: PEN, buy_quantity: 1}]", "promotion 1: get_free_quantity must be positive"},
+	}
+	for _, tc := range cases {
+		_, err := ParsePromotions([]byte(tc.config), "yaml")
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+}
+
+func TestParsePromotionsSpend(t *testing.T) {
+	config := `
+promotions:
+  - type: spend_threshold
+    threshold: 5000
+    discount_percentage: 10
+  - type: tiered_spend
+    id: SPEND_TIERS
+    tiers:
+      - {threshold: 3000, amount_off: 300}
+      - {threshold: 5000, amount_off: 700}
+`
+	promotions, err := ParsePromotions([]byte(config), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	expected := []Promotion{
+		SpendDiscount{ID: "spend_threshold:5000", Tiers: []SpendTier{{Threshold: merchandise.Cents(5000), Percentage: 10, Amount: merchandise.Cents(0)}}},
+		SpendDiscount{ID: "SPEND_TIERS", Tiers: []SpendTier{
+			{Threshold: merchandise.Cents(3000), Amount: merchandise.Cents(300)},
+			{Threshold: merchandise.Cents(5000), Amount: merchandise.Cents(700)},
+		}},
+	}
+	if !reflect.DeepEqual(promotions, expected) {
+		t.Errorf("wrong promotions expected %v got %v", expected, promotions)
+	}
+	cases := []struct {
+		config   string
+		expected string
+	}{
+		{"promotions: [{type: spend_threshold, threshold: 5000}]", "promotion 1: either discount_percentage or amount_off is required"},
+		{"promotions: [{type: spend_threshold, threshold: 5000, discount_percentage: 10, amount_off: 100}]", "promotion 1: either discount_percentage or amount_off is required"},
+		{"promotions: [{type: spend_threshold, threshold: -1, amount_off: 100}]", "promotion 1: threshold can't be negative"},
+		{"promotions: [{type: tiered_spend}]", "promotion 1: tiers are required"},
+		{"promotions: [{type: tiered_spend, tiers: [{threshold: 10, amount_off: 1}, {threshold: 10, amount_off: 2}]}]", "promotion 1: tier 2: duplicated threshold 10"},
+	}
+	for _, tc := range cases {
+		_, err := ParsePromotions([]byte(tc.config), "yaml")
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+}
+
+func TestParseCoupons(t *testing.T) {
+	list, err := ParseCoupons([]byte(yamlPromotions), "yaml")
+	if err != nil {
+		t.Errorf("ParseCoupons returned an error %s", err.Error())
+		return
+	}
+	if len(list) != 1 || list[0].Code != "WELCOME" || !list[0].SingleUse {
+		t.Errorf("wrong coupons %v", list)
+		return
+	}
+	config := "coupons: [{code: A, promotion: {type: nope}}]"
+	expected := "coupon 1: unknown promotion type \"nope\""
+	if _, err := ParseCoupons([]byte(config), "yaml"); err == nil || err.Error() != expected {
+		t.Errorf("Wrong error expected %s but got %v", expected, err)
+	}
+	config = "coupons: [{code: A, promotion: {type: buy_x_get_y, code: PEN, buy_quantity: 2, get_free_quantity: 1}}, {code: a, promotion: {type: buy_x_get_y, code: PEN, buy_quantity: 2, get_free_quantity: 1}}]"
+	expected = "coupon 2: duplicated code A"
+	if _, err := ParseCoupons([]byte(config), "yaml"); err == nil || err.Error() != expected {
+		t.Errorf("Wrong error expected %s but got %v", expected, err)
+	}
+}
+
+type doubleMugs struct{}
+
+func (promotion doubleMugs) Apply(Items map[string]item) (discounts []Discount, err error) {
+	return
+}
+
+func TestRegisterPromotionType(t *testing.T) {
+	defer delete(promotionTypes, "double_mugs")
+	RegisterPromotionType("double_mugs", func(PromotionRule) (Promotion, error) {
+		return doubleMugs{}, nil
+	})
+	promotions, err := ParsePromotions([]byte("promotions: [{type: double_mugs}]"), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotions returned an error %s", err.Error())
+		return
+	}
+	if _, ok := promotions[0].(doubleMugs); !ok {
+		t.Errorf("registered promotion type was not used")
+	}
+}
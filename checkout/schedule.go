@@ -0,0 +1,87 @@
+package checkout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule - recurring window a promotion is active in, evaluated in Location (UTC when nil)
+// Days empty means every day. Start and End are the time of day since midnight, End 0 means
+// until midnight and a Start after End is a window crossing midnight (i.e. 22:00 to 02:00),
+// in that case Days are the days the window starts on
+type Schedule struct {
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// Active - true if t falls in the schedule
+func (schedule Schedule) Active(t time.Time) bool {
+	location := schedule.Location
+	if location == nil {
+		location = time.UTC
+	}
+	local := t.In(location)
+	hour, min, sec := local.Clock()
+	since := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	since += time.Duration(local.Nanosecond())
+	end := schedule.End
+	if end == 0 {
+		end = 24 * time.Hour
+	}
+	if schedule.Start < end {
+		return since >= schedule.Start && since < end && schedule.on(local.Weekday())
+	}
+	if since >= schedule.Start {
+		return schedule.on(local.Weekday())
+	}
+	// early hours belong to the window started the day before
+	return since < end && schedule.on((local.Weekday()+6)%7)
+}
+
+func (schedule Schedule) on(day time.Weekday) bool {
+	if len(schedule.Days) == 0 {
+		return true
+	}
+	for _, d := range schedule.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parse day names, full or abbreviated (i.e. monday or mon) and case insensitive
+func parseWeekdays(names []string) ([]time.Weekday, error) {
+	days := make([]time.Weekday, 0, len(names))
+	for _, name := range names {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			full := strings.ToLower(d.String())
+			if n := strings.ToLower(strings.TrimSpace(name)); n == full || n == full[:3] {
+				days = append(days, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+	}
+	return days, nil
+}
+
+// parse a time of day as HH:MM, 24:00 is accepted as midnight at the end of the day
+func parseTimeOfDay(value string) (time.Duration, error) {
+	var hour, min int
+	var rest string
+	if n, _ := fmt.Sscanf(value, "%d:%d%s", &hour, &min, &rest); n != 2 || len(value) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	if hour < 0 || hour > 24 || min < 0 || min > 59 || (hour == 24 && min != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute, nil
+}
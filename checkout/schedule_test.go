@@ -0,0 +1,107 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	madrid := time.FixedZone("CET", 3600)
+	weekend := Schedule{Days: []time.Weekday{time.Saturday, time.Sunday}, Location: madrid}
+	happyHour := Schedule{Start: 17 * time.Hour, End: 19 * time.Hour}
+	overnight := Schedule{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 2 * time.Hour}
+	evening := Schedule{Start: 20 * time.Hour}
+
+	cases := []struct {
+		name     string
+		schedule Schedule
+		at       time.Time
+		active   bool
+	}{
+		{"always", Schedule{}, time.Date(2020, 11, 18, 3, 0, 0, 0, time.UTC), true},
+		// 2020-11-20 is a Friday, 23:30 UTC is already Saturday in Madrid
+		{"weekend in UTC friday", weekend, time.Date(2020, 11, 20, 22, 30, 0, 0, time.UTC), false},
+		{"weekend local saturday", weekend, time.Date(2020, 11, 20, 23, 30, 0, 0, time.UTC), true},
+		{"weekend local monday", weekend, time.Date(2020, 11, 22, 23, 0, 0, 0, time.UTC), false},
+		{"happy hour start", happyHour, time.Date(2020, 11, 18, 17, 0, 0, 0, time.UTC), true},
+		{"happy hour before", happyHour, time.Date(2020, 11, 18, 16, 59, 59, 0, time.UTC), false},
+		{"happy hour end", happyHour, time.Date(2020, 11, 18, 19, 0, 0, 0, time.UTC), false},
+		{"overnight friday", overnight, time.Date(2020, 11, 20, 23, 0, 0, 0, time.UTC), true},
+		{"overnight saturday early", overnight, time.Date(2020, 11, 21, 1, 0, 0, 0, time.UTC), true},
+		{"overnight saturday night", overnight, time.Date(2020, 11, 21, 23, 0, 0, 0, time.UTC), false},
+		{"overnight friday early", overnight, time.Date(2020, 11, 20, 1, 0, 0, 0, time.UTC), false},
+		{"until midnight", evening, time.Date(2020, 11, 18, 23, 59, 59, 0, time.UTC), true},
+		{"after midnight", evening, time.Date(2020, 11, 19, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, tc := range cases {
+		if tc.schedule.Active(tc.at) != tc.active {
+			t.Errorf("%s: schedule at %s should be active: %t", tc.name, tc.at, tc.active)
+		}
+	}
+}
+
+func TestScheduledPromotionTotal(t *testing.T) {
+	defer SetClock(SystemClock)
+	config := `
+promotions:
+  - type: buy_x_get_y
+    code: PEN
+    buy_quantity: 2
+    get_free_quantity: 1
+    days: [sat, Sunday]
+    start_time: "10:00"
+    end_time: "14:00"
+    timezone: America/New_York
+`
+	rules, err := ParsePromotionRules([]byte(config), "yaml")
+	if err != nil {
+		t.Errorf("ParsePromotionRules returned an error %s", err.Error())
+		return
+	}
+	defer resetPromotions(rules...)()
+	basket, _ := NewBasket()
+	_, _ = basket.AddItem(ProductItem{Product: merchandise.PEN, Count: 3})
+
+	cases := []struct {
+		at    time.Time
+		total int64
+	}{
+		// saturday 11:00 in New York
+		{time.Date(2020, 11, 21, 16, 0, 0, 0, time.UTC), 1000},
+		// saturday 11:00 UTC is still early in New York
+		{time.Date(2020, 11, 21, 11, 0, 0, 0, time.UTC), 1500},
+		// monday 11:00 in New York
+		{time.Date(2020, 11, 23, 16, 0, 0, 0, time.UTC), 1500},
+	}
+	for _, tc := range cases {
+		SetClock(FixedClock(tc.at))
+		total, _ := basket.GetTotal()
+		if total != merchandise.Cents(tc.total) {
+			t.Errorf("total at %s expected %d got %s", tc.at, tc.total, total)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	cases := []struct {
+		rule     string
+		expected string
+	}{
+		{"days: [caturday]", "unknown day \"caturday\""},
+		{"start_time: \"9:00\"", "start_time: invalid time \"9:00\", expected HH:MM"},
+		{"end_time: \"25:00\"", "end_time: invalid time \"25:00\", expected HH:MM"},
+		{"start_time: \"24:00\"", "start_time must be before 24:00"},
+		{"start_time: \"10:00\", end_time: \"10:00\"", "start_time and end_time can't be the same"},
+		{"days: [mon], timezone: Mars/Olympus", "unknown timezone \"Mars/Olympus\""},
+		{"timezone: UTC", "timezone is only used with days, start_time or end_time"},
+	}
+	for _, tc := range cases {
+		config := "promotions: [{type: buy_x_get_y, code: PEN, buy_quantity: 2, get_free_quantity: 1, " + tc.rule + "}]"
+		_, err := ParsePromotions([]byte(config), "yaml")
+		expected := "promotion 1: " + tc.expected
+		if err == nil || err.Error() != expected {
+			t.Errorf("Wrong error expected %s but got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+)
+
+// MaxSimulatedPromotions - promotions a simulation can carry, every one of them may compete
+// with the others for the best allocation
+const MaxSimulatedPromotions = 16
+
+// Simulation - DTO, hypothetical basket contents and promotions to preview the receipt they get
+// Promotions missing means the managed promotions (only enabled ones apply) and Coupons are
+// codes of existing coupons, nothing is stored and coupon uses are not counted
+type Simulation struct {
+	Items      []ProductItem   `json:"items"`
+	Promotions []PromotionRule `json:"promotions,omitempty"`
+	Coupons    []string        `json:"coupons,omitempty"`
+}
+
+// Simulate - receipt a basket with the simulation items would get, computed the same way
+// basket totals are
+func Simulate(simulation Simulation) (Receipt, error) {
+	basket := BasketData{Items: make(map[string]int64, len(simulation.Items))}
+	for _, _item := range simulation.Items {
+		if !merchandise.IsValidProduct(_item.Product) {
+			return Receipt{}, fmt.Errorf("Invalid product")
+		}
+		if _item.Count <= 0 || _item.Count > MaxItemCount-basket.Items[_item.Product] {
+			return Receipt{}, fmt.Errorf("Invalid count")
+		}
+		basket.Items[_item.Product] += _item.Count
+	}
+	if simulation.Promotions == nil {
+		basket.Promotions = defaultPromotions()
+	} else {
+		if len(simulation.Promotions) > MaxSimulatedPromotions {
+			return Receipt{}, fmt.Errorf("Too many promotions")
+		}
+		registered, err := registerRules(simulation.Promotions)
+		if err != nil {
+			return Receipt{}, err
+		}
+		basket.Promotions = make([]Promotion, len(registered))
+		for i, r := range registered {
+			basket.Promotions[i] = r.promotion
+		}
+	}
+	for _, code := range simulation.Coupons {
+		coupon, err := GetCoupon(code)
+		if err != nil {
+			return Receipt{}, err
+		}
+		basket.Coupons = append(basket.Coupons, coupon.Code)
+	}
+	items, err := basket.items()
+	if err != nil {
+		return Receipt{}, err
+	}
+	return computeReceipt(items, basket.promotions())
+}
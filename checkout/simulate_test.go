@@ -0,0 +1,74 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	defer resetPromotions(DefaultPromotionRules...)()
+	defer resetCoupons(mugCoupon)()
+	before, _ := ListBaskets()
+	items := []ProductItem{{Product: merchandise.PEN, Count: 2}, {Product: merchandise.MUG, Count: 1}, {Product: merchandise.PEN, Count: 1}}
+
+	cases := []struct {
+		name       string
+		simulation Simulation
+		total      int64
+		discounts  []string
+	}{
+		{"managed promotions", Simulation{Items: items}, 1750, []string{"PEN_BUY2_GET1"}},
+		{"no promotions", Simulation{Items: items, Promotions: []PromotionRule{}}, 2250, []string{}},
+		{"candidate promotions", Simulation{Items: items, Promotions: []PromotionRule{
+			{Type: BundleType, Items: map[string]int64{merchandise.PEN: 1, merchandise.MUG: 1}, Price: 1000},
+		}}, 2000, []string{"bundle:MUG+PEN"}},
+		{"coupons", Simulation{Items: items, Coupons: []string{"mugs4less"}}, 1375, []string{"PEN_BUY2_GET1", "coupon:MUGS4LESS"}},
+	}
+	for _, tc := range cases {
+		receipt, err := Simulate(tc.simulation)
+		if err != nil {
+			t.Errorf("%s: Simulate returned an error %s", tc.name, err.Error())
+			return
+		}
+		if receipt.Total != merchandise.Cents(tc.total) || len(receipt.Lines) != 2 || receipt.Lines[1].Quantity != 3 {
+			t.Errorf("%s: wrong receipt expected total %d got %v", tc.name, tc.total, receipt)
+		}
+		if ids := discountIDs(receipt.Discounts); len(ids) != len(tc.discounts) || (len(ids) > 0 && ids[0] != tc.discounts[0]) {
+			t.Errorf("%s: wrong discounts expected %v got %v", tc.name, tc.discounts, ids)
+		}
+	}
+	// disabled promotions don't apply
+	_, _ = EnablePromotion(PenBuy2Get1.ID, false)
+	if receipt, _ := Simulate(Simulation{Items: items}); receipt.Total != merchandise.Cents(2250) {
+		t.Errorf("disabled promotions should not apply got %s", receipt.Total)
+	}
+	if after, _ := ListBaskets(); len(after) != len(before) {
+		t.Errorf("Simulate should not create baskets")
+	}
+}
+
+func TestSimulateErrors(t *testing.T) {
+	tooMany := make([]PromotionRule, MaxSimulatedPromotions+1)
+	for i := range tooMany {
+		tooMany[i] = PromotionRule{ID: fmt.Sprintf("PEN_%d", i), Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: int64(i + 2), GetFreeQuantity: 1}
+	}
+	cases := []struct {
+		simulation Simulation
+		expected   string
+	}{
+		{Simulation{Items: []ProductItem{{Product: "FUEL", Count: 1}}}, "Invalid product"},
+		{Simulation{Items: []ProductItem{{Product: merchandise.PEN, Count: 0}}}, "Invalid count"},
+		{Simulation{Items: []ProductItem{{Product: merchandise.PEN, Count: MaxItemCount}, {Product: merchandise.PEN, Count: 1}}}, "Invalid count"},
+		{Simulation{Promotions: []PromotionRule{{Type: BuyXGetYType, Code: merchandise.PEN, BuyQuantity: 1, GetFreeQuantity: 1}}},
+			"Invalid promotion: buy_quantity must be greater than get_free_quantity"},
+		{Simulation{Coupons: []string{"NOPE"}}, "Coupon not found"},
+		{Simulation{Promotions: tooMany}, "Too many promotions"},
+	}
+	for _, tc := range cases {
+		_, err := Simulate(tc.simulation)
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+}
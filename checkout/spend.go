@@ -0,0 +1,143 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"sort"
+)
+
+// OrderPromotion - promotion applied to the basket total once product promotions are applied
+// its Apply is not used for products so it should return no discounts
+type OrderPromotion interface {
+	Promotion
+	ApplyToTotal(total merchandise.Money) ([]Discount, error)
+}
+
+// SpendTier - discount given when the total reaches Threshold, either a Percentage of
+// the total or a fixed Amount
+type SpendTier struct {
+	Threshold  merchandise.Money
+	Percentage int64
+	Amount     merchandise.Money
+}
+
+// SpendDiscount - basket-wide discount depending on the total spent
+// only the highest tier reached is applied
+type SpendDiscount struct {
+	ID    string
+	Tiers []SpendTier
+}
+
+// Apply - spend discounts don't apply to products
+func (promotion SpendDiscount) Apply(Items map[string]item) ([]Discount, error) {
+	return nil, nil
+}
+
+// ApplyToTotal - discount of the highest tier reached by total
+func (promotion SpendDiscount) ApplyToTotal(total merchandise.Money) (discounts []Discount, err error) {
+	tiers := append([]SpendTier(nil), promotion.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Threshold.Amount > tiers[j].Threshold.Amount })
+	for _, tier := range tiers {
+		if total.Amount < tier.Threshold.Amount {
+			continue
+		}
+		amount := tier.Amount
+		description := fmt.Sprintf("%s off when you spend %s", tier.Amount, tier.Threshold)
+		if tier.Percentage > 0 {
+			amount = total.Percent(tier.Percentage)
+			description = fmt.Sprintf("%d%% off orders over %s", tier.Percentage, tier.Threshold)
+		}
+		if !amount.IsPositive() {
+			return
+		}
+		discounts = append(discounts, Discount{Description: description, Amount: amount, Promotion: promotion.ID})
+		return
+	}
+	return
+}
+
+// ApplyToTotal - apply wrapped promotion only while it is valid
+func (promotion windowedPromotion) ApplyToTotal(total merchandise.Money) ([]Discount, error) {
+	if !promotion.active(now()) {
+		return nil, nil
+	}
+	return applyToTotal(promotion.Promotion, total)
+}
+
+// ApplyToTotal - apply wrapped promotion
+func (promotion stackedPromotion) ApplyToTotal(total merchandise.Money) ([]Discount, error) {
+	return applyToTotal(promotion.Promotion, total)
+}
+
+func applyToTotal(promotion Promotion, total merchandise.Money) ([]Discount, error) {
+	if p, ok := promotion.(OrderPromotion); ok {
+		return p.ApplyToTotal(total)
+	}
+	return nil, nil
+}
+
+// true for order level promotions, even when wrapped
+func isOrderPromotion(promotion Promotion) bool {
+	switch wrapper := promotion.(type) {
+	case windowedPromotion:
+		return isOrderPromotion(wrapper.Promotion)
+	case stackedPromotion:
+		return isOrderPromotion(wrapper.Promotion)
+	case toggledPromotion:
+		return isOrderPromotion(wrapper.Promotion)
+	}
+	_, ok := promotion.(OrderPromotion)
+	return ok
+}
+
+// apply order level promotions in priority order, each one to the total left by the previous ones
+// following the same stacking rules as product promotions, taking into account the stacking of
+// promotions that already gave a discount (previous): one promotion per group and promotions
+// that are not stackable only when no other discount was applied
+func applyOrderPromotions(promotions []Promotion, total merchandise.Money, previous []Stacking) ([]Discount, error) {
+	groups := make(map[string]bool)
+	for _, stacking := range previous {
+		if !stacking.Stackable {
+			// a product promotion that can't be combined was applied
+			return []Discount{}, nil
+		}
+		if stacking.Group != "" {
+			groups[stacking.Group] = true
+		}
+	}
+	ordered := append([]Promotion(nil), promotions...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return stackingOf(ordered[i]).Priority > stackingOf(ordered[j]).Priority
+	})
+	discounts := make([]Discount, 0)
+	for _, promotion := range ordered {
+		stacking := stackingOf(promotion)
+		if stacking.Group != "" && groups[stacking.Group] {
+			continue
+		}
+		if !stacking.Stackable && len(previous)+len(discounts) > 0 {
+			continue
+		}
+		result, err := applyToTotal(promotion, total)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 {
+			continue
+		}
+		for _, discount := range result {
+			if discount.Amount.Amount > total.Amount {
+				discount.Amount = total
+			}
+			total = total.Sub(discount.Amount)
+			discounts = append(discounts, discount)
+		}
+		if stacking.Group != "" {
+			groups[stacking.Group] = true
+		}
+		if !stacking.Stackable {
+			break
+		}
+	}
+	return discounts, nil
+}
@@ -0,0 +1,114 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+var tenPercentOver50 = SpendDiscount{ID: "SPEND50", Tiers: []SpendTier{{Threshold: merchandise.Cents(5000), Percentage: 10}}}
+
+var fiveOffOver30 = SpendDiscount{ID: "SPEND30", Tiers: []SpendTier{{Threshold: merchandise.Cents(3000), Amount: merchandise.Cents(500)}}}
+
+func TestSpendDiscount(t *testing.T) {
+	cases := []struct {
+		promotion   SpendDiscount
+		total       int64
+		amount      int64
+		description string
+	}{
+		{tenPercentOver50, 4999, 0, ""},
+		{tenPercentOver50, 5000, 500, "10% off orders over 50.00 EUR"},
+		{tenPercentOver50, 6250, 625, "10% off orders over 50.00 EUR"},
+		{fiveOffOver30, 2999, 0, ""},
+		{fiveOffOver30, 3000, 500, "5.00 EUR off when you spend 30.00 EUR"},
+	}
+	for _, tc := range cases {
+		discounts, err := tc.promotion.ApplyToTotal(merchandise.Cents(tc.total))
+		if err != nil {
+			t.Errorf("ApplyToTotal returned an error %s", err.Error())
+			return
+		}
+		if tc.amount == 0 {
+			if len(discounts) != 0 {
+				t.Errorf("%s should not apply to %d got %v", tc.promotion.ID, tc.total, discounts)
+			}
+			continue
+		}
+		if len(discounts) != 1 || discounts[0].Amount != merchandise.Cents(tc.amount) || discounts[0].Description != tc.description {
+			t.Errorf("%s on %d expected %d %s got %v", tc.promotion.ID, tc.total, tc.amount, tc.description, discounts)
+		}
+	}
+}
+
+func TestTieredSpendDiscount(t *testing.T) {
+	tiered := SpendDiscount{ID: "TIERS", Tiers: []SpendTier{
+		{Threshold: merchandise.Cents(3000), Amount: merchandise.Cents(300)},
+		{Threshold: merchandise.Cents(10000), Percentage: 15},
+		{Threshold: merchandise.Cents(5000), Amount: merchandise.Cents(700)},
+	}}
+	cases := []struct {
+		total  int64
+		amount int64
+	}{
+		{2000, 0},
+		{3000, 300},
+		{6000, 700},
+		{10000, 1500},
+	}
+	for _, tc := range cases {
+		discounts, _ := tiered.ApplyToTotal(merchandise.Cents(tc.total))
+		amount := int64(0)
+		if len(discounts) > 0 {
+			amount = discounts[0].Amount.Amount
+		}
+		if len(discounts) > 1 || amount != tc.amount {
+			t.Errorf("tiered discount on %d expected %d got %v", tc.total, tc.amount, discounts)
+		}
+	}
+}
+
+func TestOrderPromotionsAfterProductPromotions(t *testing.T) {
+	// 3 shirts: 60.00 - 15.00 (25% off) = 45.00, not enough for 10% off over 50
+	items := basketItems(map[string]int64{merchandise.TSHIRT: 3})
+	receipt, _ := computeReceipt(items, []Promotion{tenPercentOver50, TshirtBuy3Get25OFF, fiveOffOver30})
+	if receipt.Total != merchandise.Cents(4000) {
+		t.Errorf("wrong total expected 40.00 EUR got %s", receipt.Total)
+		return
+	}
+	if len(receipt.Discounts) != 2 || receipt.Discounts[0].Promotion != TshirtBuy3Get25OFF.ID || receipt.Discounts[1].Promotion != "SPEND30" {
+		t.Errorf("order discounts should go after product ones %v", discountIDs(receipt.Discounts))
+		return
+	}
+	// 4 shirts: 80.00 - 20.00 = 60.00, 10% off (6.00) then 5.00 off the 54.00 left
+	items = basketItems(map[string]int64{merchandise.TSHIRT: 4})
+	receipt, _ = computeReceipt(items, []Promotion{tenPercentOver50, TshirtBuy3Get25OFF, fiveOffOver30})
+	if receipt.Total != merchandise.Cents(4900) {
+		t.Errorf("wrong total expected 49.00 EUR got %s (%v)", receipt.Total, discountIDs(receipt.Discounts))
+	}
+}
+
+func TestOrderPromotionsStacking(t *testing.T) {
+	items := basketItems(map[string]int64{merchandise.TSHIRT: 4})
+	sameGroup := []Promotion{
+		stackedPromotion{Promotion: tenPercentOver50, stacking: Stacking{Group: "spend", Stackable: true}},
+		stackedPromotion{Promotion: fiveOffOver30, stacking: Stacking{Group: "spend", Stackable: true}},
+	}
+	receipt, _ := computeReceipt(items, sameGroup)
+	if len(receipt.Discounts) != 1 || receipt.Discounts[0].Promotion != "SPEND50" {
+		t.Errorf("only one promotion of a group should apply got %v", discountIDs(receipt.Discounts))
+		return
+	}
+	// not stackable spend discount is skipped when products got discounts
+	alone := stackedPromotion{Promotion: fiveOffOver30, stacking: Stacking{}}
+	receipt, _ = computeReceipt(items, []Promotion{TshirtBuy3Get25OFF, alone})
+	if len(receipt.Discounts) != 1 || receipt.Discounts[0].Promotion != TshirtBuy3Get25OFF.ID {
+		t.Errorf("not stackable promotion should not be combined got %v", discountIDs(receipt.Discounts))
+		return
+	}
+	// and spend discounts are skipped after a product promotion that is not stackable
+	shirtsAlone := stackedPromotion{Promotion: TshirtBuy3Get25OFF, stacking: Stacking{}}
+	receipt, _ = computeReceipt(items, []Promotion{shirtsAlone, fiveOffOver30})
+	if len(receipt.Discounts) != 1 || receipt.Discounts[0].Promotion != TshirtBuy3Get25OFF.ID {
+		t.Errorf("nothing should be combined with a not stackable promotion got %v", discountIDs(receipt.Discounts))
+	}
+}
@@ -0,0 +1,50 @@
+package checkout
+
+// Stacking - how a promotion combines with others in the same basket
+// only one promotion of each exclusivity Group is applied and a promotion that is
+// not Stackable is only applied alone. When several combinations give the same
+// total the one favouring higher Priority promotions wins (ties keep their order)
+type Stacking struct {
+	Priority  int64  `json:"priority,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Stackable bool   `json:"stackable"`
+}
+
+// DefaultStacking - used for promotions that don't define their own
+var DefaultStacking = Stacking{Stackable: true}
+
+// StackedPromotion - implemented by promotions that define how they combine with others
+type StackedPromotion interface {
+	Promotion
+	Stacking() Stacking
+}
+
+// promotion with stacking rules
+type stackedPromotion struct {
+	Promotion
+	stacking Stacking
+}
+
+// Stacking - rules given to the wrapped promotion
+func (promotion stackedPromotion) Stacking() Stacking {
+	return promotion.stacking
+}
+
+func stackingOf(promotion Promotion) Stacking {
+	if stacked, ok := promotion.(StackedPromotion); ok {
+		return stacked.Stacking()
+	}
+	return DefaultStacking
+}
+
+// items without the units already claimed by other promotions
+func remainingItems(items map[string]item, claimed map[string]int64) map[string]item {
+	remaining := make(map[string]item, len(items))
+	for code, item := range items {
+		item.Count -= claimed[code]
+		if item.Count > 0 {
+			remaining[code] = item
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,109 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+var penHalfPrice = BulkPercentageDiscount{ID: "PEN_HALF", Code: merchandise.PEN, BuyQuantity: 1, DiscountPercentage: 50}
+
+func pens(count int64) map[string]item {
+	return map[string]item{merchandise.PEN: {Product: merchandise.GetProduct(merchandise.PEN), Count: count}}
+}
+
+func discountIDs(discounts []Discount) []string {
+	ids := make([]string, len(discounts))
+	for i, discount := range discounts {
+		ids[i] = discount.Promotion
+	}
+	return ids
+}
+
+func TestApplyPromotionsNeverDiscountsUnitTwice(t *testing.T) {
+	// 5 pens: 2x1 uses 4 of them, half price only gets the last one
+	discounts, err := applyPromotions(pens(5), []Promotion{PenBuy2Get1, penHalfPrice}, merchandise.Cents(2500))
+	if err != nil {
+		t.Errorf("applyPromotions returned an error %s", err.Error())
+		return
+	}
+	if len(discounts) != 2 {
+		t.Errorf("wrong discounts %v", discounts)
+		return
+	}
+	if discounts[0].Amount != merchandise.Cents(1000) || discounts[1].Amount != merchandise.Cents(250) {
+		t.Errorf("wrong discount amounts %s and %s", discounts[0].Amount, discounts[1].Amount)
+	}
+}
+
+func TestApplyPromotionsPriority(t *testing.T) {
+	halfFirst := stackedPromotion{Promotion: penHalfPrice, stacking: Stacking{Priority: 10, Stackable: true}}
+	discounts, _ := applyPromotions(pens(4), []Promotion{PenBuy2Get1, halfFirst}, merchandise.Cents(2000))
+	// half price claims every pen before 2x1 is evaluated
+	if len(discounts) != 1 || discounts[0].Promotion != "PEN_HALF" || discounts[0].Amount != merchandise.Cents(1000) {
+		t.Errorf("wrong discounts %v", discountIDs(discounts))
+	}
+}
+
+func TestApplyPromotionsGroups(t *testing.T) {
+	mugs := map[string]item{
+		merchandise.PEN: {Product: merchandise.GetProduct(merchandise.PEN), Count: 1},
+		merchandise.MUG: {Product: merchandise.GetProduct(merchandise.MUG), Count: 1},
+	}
+	penDeal := stackedPromotion{Promotion: penHalfPrice, stacking: Stacking{Group: "welcome", Stackable: true}}
+	mugDeal := stackedPromotion{
+		Promotion: BulkPercentageDiscount{ID: "MUG_HALF", Code: merchandise.MUG, BuyQuantity: 1, DiscountPercentage: 50},
+		stacking:  Stacking{Group: "welcome", Stackable: true},
+	}
+	// the mug deal is worth more
+	discounts, _ := applyPromotions(mugs, []Promotion{penDeal, mugDeal}, merchandise.Cents(1250))
+	if len(discounts) != 1 || discounts[0].Promotion != "MUG_HALF" {
+		t.Errorf("only one promotion of a group should apply got %v", discountIDs(discounts))
+		return
+	}
+	// a group is only taken by promotions that applied
+	discounts, _ = applyPromotions(mugs, []Promotion{PenBuy2Get1, mugDeal}, merchandise.Cents(1250))
+	if len(discounts) != 1 || discounts[0].Promotion != "MUG_HALF" {
+		t.Errorf("wrong discounts %v", discountIDs(discounts))
+	}
+}
+
+func TestApplyPromotionsNotStackable(t *testing.T) {
+	alone := stackedPromotion{Promotion: penHalfPrice, stacking: Stacking{Priority: 1}}
+	// applied alone when it goes first
+	discounts, _ := applyPromotions(pens(5), []Promotion{PenBuy2Get1, alone}, merchandise.Cents(2500))
+	if len(discounts) != 1 || discounts[0].Promotion != "PEN_HALF" {
+		t.Errorf("not stackable promotion should be applied alone got %v", discountIDs(discounts))
+		return
+	}
+	// skipped when the combination of the others is worth more
+	tenPercent := stackedPromotion{
+		Promotion: BulkPercentageDiscount{ID: "PEN_10", Code: merchandise.PEN, BuyQuantity: 1, DiscountPercentage: 10},
+		stacking:  Stacking{Priority: 1},
+	}
+	discounts, _ = applyPromotions(pens(5), []Promotion{PenBuy2Get1, penHalfPrice, tenPercent}, merchandise.Cents(2500))
+	if len(discounts) != 2 || discounts[0].Promotion != PenBuy2Get1.ID || discounts[1].Promotion != "PEN_HALF" {
+		t.Errorf("not stackable promotion should not be combined got %v", discountIDs(discounts))
+	}
+}
+
+// promotion that doesn't report units and discounts more than the basket is worth
+type generousPromo struct{}
+
+func (promotion generousPromo) Apply(Items map[string]item) ([]Discount, error) {
+	return []Discount{{Description: "Everything free and more", Amount: merchandise.Cents(100000), Promotion: "GENEROUS"}}, nil
+}
+
+func TestApplyPromotionsNeverBelowZero(t *testing.T) {
+	receipt, err := computeReceipt(pens(3), []Promotion{PenBuy2Get1, generousPromo{}})
+	if err != nil {
+		t.Errorf("computeReceipt returned an error %s", err.Error())
+		return
+	}
+	if !receipt.Total.IsZero() || receipt.DiscountTotal != receipt.Subtotal {
+		t.Errorf("total should be capped at zero got %s", receipt.Total)
+		return
+	}
+	if receipt.Discounts[1].Amount != merchandise.Cents(1000) {
+		t.Errorf("last discount should be capped got %s", receipt.Discounts[1].Amount)
+	}
+}
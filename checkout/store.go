@@ -0,0 +1,160 @@
+package checkout
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBasketNotFound - returned by stores when a basket id is unknown
+var ErrBasketNotFound = fmt.Errorf("Basket not found")
+
+// BasketData - model, storable representation of a basket
+// once checked out it is kept for reference but can't be modified
+type BasketData struct {
+	ID string `json:"id"`
+	// Version - increased on every change, starting at 1
+	Version    int64            `json:"version"`
+	Items      map[string]int64 `json:"items"`
+	Promotions []Promotion      `json:"-"`
+	// PromotionPolicy and PromotionsVersion - how the basket gets its promotions and the
+	// version of the promotion set pinned on creation
+	PromotionPolicy   PromotionPolicy `json:"promotion_policy,omitempty"`
+	PromotionsVersion int64           `json:"promotions_version,omitempty"`
+	// PromotionRules - rules of the pinned promotion set so it survives restarts, nil for
+	// live baskets (empty, not nil, when no promotions were pinned). Never modified in place
+	PromotionRules []PromotionRule `json:"promotion_rules"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	// TTL and ExpiresAt - idle time before the basket expires (0 never) and when it does
+	TTL       time.Duration `json:"ttl,omitempty"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	// Owner - who the basket belongs to, empty for anonymous baskets
+	Owner      string   `json:"owner,omitempty"`
+	Coupons    []string `json:"coupons,omitempty"`
+	CheckedOut bool     `json:"checked_out,omitempty"`
+	OrderID    string   `json:"order_id,omitempty"`
+}
+
+// BasketStore - interface to plug basket storage backends
+// implementations must be safe for concurrent use and must return copies
+// so callers can't modify stored state outside Update
+type BasketStore interface {
+	// Get - get a basket by id, ErrBasketNotFound if it does not exist
+	Get(id string) (BasketData, error)
+	// Put - store a basket, replacing any previous one with the same id
+	Put(BasketData) error
+	// Delete - remove a basket, ErrBasketNotFound if it does not exist
+	Delete(id string) error
+	// DeleteIf - call fn with exclusive access to the basket and remove it if fn returns nil
+	// nothing is removed if fn returns an error
+	DeleteIf(id string, fn func(BasketData) error) error
+	// List - get all stored baskets
+	List() ([]BasketData, error)
+	// Update - call fn with exclusive access to the basket and store the result
+	// nothing is stored if fn returns an error
+	Update(id string, fn func(*BasketData) error) error
+}
+
+func (data BasketData) copy() BasketData {
+	items := make(map[string]int64, len(data.Items))
+	for code, count := range data.Items {
+		items[code] = count
+	}
+	data.Items = items
+	data.Promotions = append([]Promotion(nil), data.Promotions...)
+	data.Coupons = append([]string(nil), data.Coupons...)
+	return data
+}
+
+// MemoryBasketStore - BasketStore backed by a map, contents are lost on restart
+type MemoryBasketStore struct {
+	lock    sync.RWMutex
+	baskets map[string]BasketData
+}
+
+// NewMemoryBasketStore - creates an empty in-memory store
+func NewMemoryBasketStore() *MemoryBasketStore {
+	return &MemoryBasketStore{baskets: make(map[string]BasketData)}
+}
+
+// Get - get a copy of a basket
+func (s *MemoryBasketStore) Get(id string) (BasketData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return BasketData{}, ErrBasketNotFound
+	}
+	return data.copy(), nil
+}
+
+// Put - store a copy of a basket
+func (s *MemoryBasketStore) Put(data BasketData) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.baskets[data.ID] = data.copy()
+	return nil
+}
+
+// Delete - remove a basket
+func (s *MemoryBasketStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.baskets[id]; !ok {
+		return ErrBasketNotFound
+	}
+	delete(s.baskets, id)
+	return nil
+}
+
+// DeleteIf - remove a basket if fn allows it while holding the store write lock
+func (s *MemoryBasketStore) DeleteIf(id string, fn func(BasketData) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return ErrBasketNotFound
+	}
+	if err := fn(data.copy()); err != nil {
+		return err
+	}
+	delete(s.baskets, id)
+	return nil
+}
+
+// List - get a copy of every basket (in no particular order)
+func (s *MemoryBasketStore) List() ([]BasketData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]BasketData, 0, len(s.baskets))
+	for _, data := range s.baskets {
+		list = append(list, data.copy())
+	}
+	return list, nil
+}
+
+// Update - modify a basket while holding the store write lock
+func (s *MemoryBasketStore) Update(id string, fn func(*BasketData) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.baskets[id]
+	if !ok {
+		return ErrBasketNotFound
+	}
+	data = data.copy()
+	if err := fn(&data); err != nil {
+		return err
+	}
+	s.baskets[id] = data
+	return nil
+}
+
+// store used by package level basket functions
+var store BasketStore = NewMemoryBasketStore()
+
+// SetBasketStore - replace the storage backend used for baskets
+// it is meant to be called on startup (or in tests) before serving requests
+func SetBasketStore(s BasketStore) {
+	store = s
+}
@@ -0,0 +1,123 @@
+package checkout
+
+import (
+	"fmt"
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestMemoryBasketStoreGetReturnsCopy(t *testing.T) {
+	s := NewMemoryBasketStore()
+	_ = s.Put(BasketData{ID: "1", Items: map[string]int64{merchandise.PEN: 1}})
+	data, err := s.Get("1")
+	if err != nil {
+		t.Errorf("Get returned an error %s", err.Error())
+		return
+	}
+	// modifying returned data must not change stored basket
+	data.Items[merchandise.PEN] = 10
+	data, _ = s.Get("1")
+	if data.Items[merchandise.PEN] != 1 {
+		t.Errorf("stored basket was modified outside Update, count is %d", data.Items[merchandise.PEN])
+	}
+}
+
+func TestMemoryBasketStoreNotFound(t *testing.T) {
+	s := NewMemoryBasketStore()
+	if _, err := s.Get("1"); err != ErrBasketNotFound {
+		t.Errorf("Get should have returned ErrBasketNotFound got %v", err)
+	}
+	if err := s.Delete("1"); err != ErrBasketNotFound {
+		t.Errorf("Delete should have returned ErrBasketNotFound got %v", err)
+	}
+	err := s.Update("1", func(*BasketData) error { return nil })
+	if err != ErrBasketNotFound {
+		t.Errorf("Update should have returned ErrBasketNotFound got %v", err)
+	}
+}
+
+func TestMemoryBasketStoreUpdate(t *testing.T) {
+	s := NewMemoryBasketStore()
+	_ = s.Put(BasketData{ID: "1", Items: map[string]int64{}})
+	err := s.Update("1", func(data *BasketData) error {
+		data.Items[merchandise.MUG] = 2
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Update returned an error %s", err.Error())
+		return
+	}
+	// a failing update must leave the basket untouched
+	err = s.Update("1", func(data *BasketData) error {
+		data.Items[merchandise.MUG] = 5
+		return fmt.Errorf("some random error")
+	})
+	if err == nil || err.Error() != "some random error" {
+		t.Errorf("Update should have returned fn error got %v", err)
+		return
+	}
+	data, _ := s.Get("1")
+	if data.Items[merchandise.MUG] != 2 {
+		t.Errorf("wrong number of items expected 2 got %d", data.Items[merchandise.MUG])
+	}
+}
+
+func TestMemoryBasketStoreListAndDelete(t *testing.T) {
+	s := NewMemoryBasketStore()
+	_ = s.Put(BasketData{ID: "1"})
+	_ = s.Put(BasketData{ID: "2"})
+	list, _ := s.List()
+	if len(list) != 2 {
+		t.Errorf("wrong number of baskets expected 2 got %d", len(list))
+		return
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Errorf("Delete returned an error %s", err.Error())
+		return
+	}
+	list, _ = s.List()
+	if len(list) != 1 || list[0].ID != "2" {
+		t.Errorf("only basket 2 should remain got %v", list)
+	}
+}
+
+func TestMemoryBasketStoreDeleteIf(t *testing.T) {
+	s := NewMemoryBasketStore()
+	_ = s.Put(BasketData{ID: "1", Version: 2})
+	refuse := func(data BasketData) error {
+		if data.Version != 1 {
+			return ErrBasketModified
+		}
+		return nil
+	}
+	if err := s.DeleteIf("1", refuse); err != ErrBasketModified {
+		t.Errorf("expected %v got %v", ErrBasketModified, err)
+		return
+	}
+	if _, err := s.Get("1"); err != nil {
+		t.Errorf("refused delete should keep the basket got %v", err)
+		return
+	}
+	if err := s.DeleteIf("1", func(BasketData) error { return nil }); err != nil {
+		t.Errorf("DeleteIf returned an error %s", err.Error())
+		return
+	}
+	if err := s.DeleteIf("1", refuse); err != ErrBasketNotFound {
+		t.Errorf("expected %v got %v", ErrBasketNotFound, err)
+	}
+}
+
+func TestSetBasketStoreIsolatesBaskets(t *testing.T) {
+	first := NewMemoryBasketStore()
+	SetBasketStore(first)
+	b, _ := NewBasket()
+	SetBasketStore(NewMemoryBasketStore())
+	if _, err := GetBasket(b.GetID()); err == nil {
+		t.Errorf("basket should not be visible from a different store")
+		return
+	}
+	SetBasketStore(first)
+	if _, err := GetBasket(b.GetID()); err != nil {
+		t.Errorf("GetBasket returned an error %s", err.Error())
+	}
+}
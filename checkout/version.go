@@ -0,0 +1,61 @@
+package checkout
+
+import "fmt"
+
+// ErrBasketModified - returned when a basket changed since the version a change was based on
+var ErrBasketModified = fmt.Errorf("Basket was modified")
+
+// GetBasketIfMatch - like GetBasket but changes made through the returned basket fail with
+// ErrBasketModified unless the basket is at one of versions, so clients can detect
+// concurrent edits
+func GetBasketIfMatch(id string, versions []int64) (Basket, error) {
+	if err := touchBasket(id); err != nil {
+		return nil, err
+	}
+	return newBasketWrapper(id, append([]int64{}, versions...)), nil
+}
+
+// DeleteBasketIfMatch - remove a basket if it is at one of versions, ErrBasketModified if not
+func DeleteBasketIfMatch(id string, versions []int64) error {
+	return deleteBasket(id, append([]int64{}, versions...))
+}
+
+// GetVersion - version of the basket, every change increases it. After a change made through
+// this basket it is the version that change left even if others changed it later
+func (b BasketWrapper) GetVersion() (int64, error) {
+	if b.version != nil && *b.version != 0 {
+		return *b.version, nil
+	}
+	basket, err := loadBasket(b.id)
+	if err != nil {
+		return 0, err
+	}
+	return basket.Version, nil
+}
+
+// true when there are no version preconditions or version is one of them
+func (b BasketWrapper) matches(version int64) bool {
+	if b.ifMatch == nil {
+		return true
+	}
+	for _, v := range b.ifMatch {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// modify the basket checking its version and record the version left by the change
+func (b BasketWrapper) update(fn func(*BasketData) error) error {
+	version, err := updateBasket(b.id, func(basket *BasketData) error {
+		if !b.matches(basket.Version) {
+			return ErrBasketModified
+		}
+		return fn(basket)
+	})
+	if err == nil && b.version != nil {
+		*b.version = version
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package checkout
+
+import (
+	"github.com/gato/lana/merchandise"
+	"testing"
+)
+
+func TestBasketVersion(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	if version, _ := b.GetVersion(); version != 1 {
+		t.Errorf("new baskets should be at version 1 got %d", version)
+		return
+	}
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	_, _ = b.SetQuantity(ProductItem{Product: merchandise.PEN, Count: 3})
+	if version, _ := b.GetVersion(); version != 3 {
+		t.Errorf("every change should increase the version expected 3 got %d", version)
+		return
+	}
+	// failed changes don't
+	if err := b.RemoveItem(merchandise.MUG); err == nil {
+		t.Errorf("An error was expected")
+		return
+	}
+	// reads don't either
+	_, _ = GetBasket(b.GetID())
+	_, _ = b.GetReceipt()
+	if version, _ := b.GetVersion(); version != 3 {
+		t.Errorf("only changes should increase the version expected 3 got %d", version)
+		return
+	}
+}
+
+func TestGetBasketIfMatch(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	tab1, _ := GetBasketIfMatch(b.GetID(), []int64{1})
+	tab2, _ := GetBasketIfMatch(b.GetID(), []int64{1})
+	if _, err := tab1.AddItem(ProductItem{Product: merchandise.PEN, Count: 1}); err != nil {
+		t.Errorf("AddItem returned an error %s", err.Error())
+		return
+	}
+	if version, _ := tab1.GetVersion(); version != 2 {
+		t.Errorf("wrong version after change expected 2 got %d", version)
+		return
+	}
+	if _, err := tab2.AddItem(ProductItem{Product: merchandise.MUG, Count: 1}); err != ErrBasketModified {
+		t.Errorf("concurrent change should fail with %v got %v", ErrBasketModified, err)
+		return
+	}
+	if items, _ := b.GetItems(); len(items) != 1 || items[0].Product != merchandise.PEN {
+		t.Errorf("rejected change should not modify the basket %v", items)
+		return
+	}
+	if _, err := tab2.ApplyCoupon("NOPE"); err == nil {
+		t.Errorf("An error was expected")
+		return
+	}
+	if err := tab2.Clear(); err != ErrBasketModified {
+		t.Errorf("expected %v got %v", ErrBasketModified, err)
+		return
+	}
+	if _, err := tab2.Checkout(); err != ErrBasketModified {
+		t.Errorf("expected %v got %v", ErrBasketModified, err)
+		return
+	}
+	// any of the versions given matches
+	tab3, _ := GetBasketIfMatch(b.GetID(), []int64{1, 2})
+	if err := tab3.Clear(); err != nil {
+		t.Errorf("Clear returned an error %s", err.Error())
+		return
+	}
+}
+
+func TestDeleteBasketIfMatch(t *testing.T) {
+	SetBasketStore(NewMemoryBasketStore())
+	b, _ := NewBasket()
+	_, _ = b.AddItem(ProductItem{Product: merchandise.PEN, Count: 1})
+	if err := DeleteBasketIfMatch(b.GetID(), []int64{1}); err != ErrBasketModified {
+		t.Errorf("expected %v got %v", ErrBasketModified, err)
+		return
+	}
+	if err := DeleteBasketIfMatch(b.GetID(), []int64{2}); err != nil {
+		t.Errorf("DeleteBasketIfMatch returned an error %s", err.Error())
+		return
+	}
+	if _, err := GetBasket(b.GetID()); err != ErrBasketNotFound {
+		t.Errorf("basket should be deleted got %v", err)
+		return
+	}
+}
@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/gin-gonic/gin v1.6.3
 	github.com/google/uuid v1.1.2
+	gopkg.in/yaml.v2 v2.2.8
 )
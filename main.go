@@ -4,19 +4,116 @@ import (
 	"flag"
 	"fmt"
 	"github.com/gato/lana/checkout"
+	"github.com/gato/lana/merchandise"
 	"github.com/gin-gonic/gin"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
-	port = flag.Int64("port", 8080, "port to listen to")
+	port       = flag.Int64("port", 8080, "port to listen to")
+	dataDir    = flag.String("data-dir", "", "directory where baskets, orders and customers are persisted (in memory if empty)")
+	promotions = flag.String("promotions", "", "promotion rules file (json or yaml), reloaded on SIGHUP")
+	policy     = flag.String("promotion-policy", "pinned", "pinned: baskets keep the promotions they were created with, live: baskets get current promotions")
+	basketTTL  = flag.Duration("basket-ttl", 0, "idle time before baskets expire (i.e. 24h), 0 never expires")
+	touch      = flag.Bool("touch-on-read", false, "reading a basket also extends its life")
+	reapEvery  = flag.Duration("reap-every", time.Minute, "how often expired baskets are removed")
+	archive    = flag.String("archive", "", "file where expired baskets are appended as json lines before removing them")
+	adminToken = flag.String("admin-token", "", "bearer token giving access to every basket (i.e. dashboards), no admin access if empty")
+	retention  = flag.Duration("idempotency-retention", checkout.DefaultIdempotencyRetention, "how long responses are replayed to requests repeating an Idempotency-Key")
 )
 
 func main() {
 	flag.Parse()
+	if err := checkout.SetPromotionPolicy(checkout.PromotionPolicy(*policy)); err != nil {
+		log.Fatalf("Invalid promotion policy: %s", err.Error())
+	}
+	// promotions are loaded first so baskets stored before they were pinned get them
+	if *promotions != "" {
+		if err := checkout.LoadPromotionsFile(*promotions); err != nil {
+			log.Fatalf("Unable to load promotions: %s", err.Error())
+		}
+		go reloadPromotionsOnHangup(*promotions)
+	}
+	if *dataDir != "" {
+		store, err := checkout.OpenFileBasketStore(*dataDir)
+		if err != nil {
+			log.Fatalf("Unable to open data dir %s: %s", *dataDir, err.Error())
+		}
+		defer store.Close()
+		checkout.SetBasketStore(store)
+		orders, err := checkout.OpenFileOrderStore(*dataDir)
+		if err != nil {
+			log.Fatalf("Unable to open data dir %s: %s", *dataDir, err.Error())
+		}
+		defer orders.Close()
+		checkout.SetOrderStore(orders)
+		if err := checkout.RestoreCouponUses(); err != nil {
+			log.Fatalf("Unable to restore coupon uses: %s", err.Error())
+		}
+		closeCustomers, err := checkout.PersistCustomers(*dataDir)
+		if err != nil {
+			log.Fatalf("Unable to open data dir %s: %s", *dataDir, err.Error())
+		}
+		defer closeCustomers()
+	}
+	if err := checkout.SetBasketExpiry(checkout.BasketExpiry{TTL: *basketTTL, TouchOnRead: *touch}); err != nil {
+		log.Fatalf("Invalid basket expiry: %s", err.Error())
+	}
+	checkout.SetAdminToken(*adminToken)
+	if err := checkout.SetIdempotencyRetention(*retention); err != nil {
+		log.Fatalf("Invalid idempotency retention: %s", err.Error())
+	}
+	var basketArchive checkout.BasketArchive
+	if *archive != "" {
+		f, err := os.OpenFile(*archive, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Unable to open archive %s: %s", *archive, err.Error())
+		}
+		defer f.Close()
+		basketArchive = checkout.NewJSONBasketArchive(f)
+	}
+	if *reapEvery > 0 {
+		go reapExpiredBaskets(*reapEvery, basketArchive)
+	}
 	r := gin.Default()
 	apiv1 := r.Group("/api/v1/")
 	checkout.AddRoutes(apiv1)
+	// anyone can see the catalog, changing it is for admins
+	merchandise.AddRoutes(apiv1.Group("", checkout.Authentication()), checkout.AdminOnly())
 	runPort := fmt.Sprintf(":%d", *port)
 	fmt.Printf("Api listening on port %d\n", *port)
 	r.Run(runPort)
 }
+
+// reload promotion rules every time a SIGHUP is received
+// an invalid file is reported and current promotions are kept
+func reloadPromotionsOnHangup(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := checkout.LoadPromotionsFile(path); err != nil {
+			log.Printf("Promotions not reloaded: %s", err.Error())
+			continue
+		}
+		log.Printf("Promotions reloaded from %s", path)
+	}
+}
+
+// remove expired baskets periodically, failures are reported and retried on the next run
+func reapExpiredBaskets(every time.Duration, archive checkout.BasketArchive) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for range ticker.C {
+		reaped, err := checkout.ReapExpiredBaskets(archive)
+		if err != nil {
+			log.Printf("Expired baskets not reaped: %s", err.Error())
+		}
+		if reaped > 0 {
+			log.Printf("%d expired baskets reaped", reaped)
+		}
+	}
+}
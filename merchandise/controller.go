@@ -0,0 +1,61 @@
+package merchandise
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func abort(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	switch err.Error() {
+	case "Product not found":
+		status = http.StatusNotFound
+	case "Product already exists":
+		status = http.StatusConflict
+	}
+	http.Error(c.Writer, err.Error(), status)
+}
+
+// HandleGetAllProducts - http handler listing the whole catalog
+func HandleGetAllProducts(c *gin.Context) {
+	c.JSON(http.StatusOK, ListProducts())
+}
+
+// HandleGetProduct - http handler for getting a product by code
+func HandleGetProduct(c *gin.Context, code string) {
+	if !IsValidProduct(code) {
+		http.Error(c.Writer, "Product not found", http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, GetProduct(code))
+}
+
+// HandleCreateProduct - http handler for adding a product to the catalog
+func HandleCreateProduct(c *gin.Context, product Product) {
+	if err := CreateProduct(product); err != nil {
+		abort(c, err)
+		return
+	}
+	// TODO: build using url tools
+	location := c.Request.Host + c.Request.RequestURI + product.Code
+	c.Header("Location", location)
+	c.JSON(http.StatusCreated, GetProduct(product.Code))
+}
+
+// HandleUpdateProduct - http handler for changing an existing product
+func HandleUpdateProduct(c *gin.Context, product Product) {
+	if err := UpdateProduct(product); err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, GetProduct(product.Code))
+}
+
+// HandleDeleteProduct - http handler for removing a product from the catalog
+func HandleDeleteProduct(c *gin.Context, code string) {
+	if err := DeleteProduct(code); err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
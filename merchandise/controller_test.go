@@ -0,0 +1,175 @@
+package merchandise
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getRouter() *gin.Engine {
+	r := gin.Default()
+	apiv1 := r.Group("/api/v1/")
+	AddRoutes(apiv1)
+	return r
+}
+
+func TestHandleGetAllProducts(t *testing.T) {
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/products/", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusOK
+	if w.Code != expected {
+		t.Errorf("HandleGetAllProducts wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "[{\"code\":\"MUG\",\"name\":\"Lana Coffee Mug\",\"price\":{\"minor_units\":750,\"currency\":\"EUR\",\"formatted\":\"7.50 EUR\"}}," +
+		"{\"code\":\"PEN\",\"name\":\"Lana Pen\",\"price\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}}," +
+		"{\"code\":\"TSHIRT\",\"name\":\"Lana T-Shirt\",\"price\":{\"minor_units\":2000,\"currency\":\"EUR\",\"formatted\":\"20.00 EUR\"}}]"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleGetAllProducts wrong response body expected %s got %s", expectedBody, w.Body.String())
+	}
+}
+
+func TestHandleGetProduct(t *testing.T) {
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/products/PEN", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusOK
+	if w.Code != expected {
+		t.Errorf("HandleGetProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	expectedBody := "{\"code\":\"PEN\",\"name\":\"Lana Pen\",\"price\":{\"minor_units\":500,\"currency\":\"EUR\",\"formatted\":\"5.00 EUR\"}}"
+	if w.Body.String() != expectedBody {
+		t.Errorf("HandleGetProduct wrong response body expected %s got %s", expectedBody, w.Body.String())
+		return
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/products/FUEL", nil)
+	r.ServeHTTP(w, req)
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleGetProduct wrong http status expected %d got %d", expected, w.Code)
+	}
+}
+
+func TestHandleCreateProduct(t *testing.T) {
+	defer resetProducts()()
+	r := getRouter()
+	cases := []struct {
+		payload      string
+		status       int
+		expectedBody string
+	}{
+		{"{\"code\":\"CAP\",\"name\":\"Lana Cap\",\"price\":{\"minor_units\":1200}}", http.StatusCreated, "{\"code\":\"CAP\",\"name\":\"Lana Cap\",\"price\":{\"minor_units\":1200,\"currency\":\"EUR\",\"formatted\":\"12.00 EUR\"}}"},
+		{"{\"code\":\"CAP\",\"name\":\"Lana Cap\",\"price\":{\"minor_units\":1200}}", http.StatusConflict, "Product already exists\n"},
+		{"{\"code\":\"HAT\",\"price\":{\"minor_units\":1200}}", http.StatusBadRequest, "Product name is required\n"},
+		{"{\"code\":\"HAT\",\"name\":\"Lana Hat\",\"price\":{\"minor_units\":1200},\"price_tiers\":[{\"min_quantity\":50,\"price\":{\"minor_units\":900}},{\"min_quantity\":10,\"price\":{\"minor_units\":1000}}]}", http.StatusCreated,
+			"{\"code\":\"HAT\",\"name\":\"Lana Hat\",\"price\":{\"minor_units\":1200,\"currency\":\"EUR\",\"formatted\":\"12.00 EUR\"}," +
+				"\"price_tiers\":[{\"min_quantity\":10,\"price\":{\"minor_units\":1000,\"currency\":\"EUR\",\"formatted\":\"10.00 EUR\"}}," +
+				"{\"min_quantity\":50,\"price\":{\"minor_units\":900,\"currency\":\"EUR\",\"formatted\":\"9.00 EUR\"}}]}"},
+		{"{\"code\":\"HAT\"", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/products/", strings.NewReader(tc.payload))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("HandleCreateProduct wrong http status expected %d got %d", tc.status, w.Code)
+			return
+		}
+		if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+			t.Errorf("HandleCreateProduct wrong response body expected %s got %s", tc.expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleUpdateProduct(t *testing.T) {
+	defer resetProducts()()
+	r := getRouter()
+	cases := []struct {
+		url          string
+		payload      string
+		status       int
+		expectedBody string
+	}{
+		{"/api/v1/products/PEN", "{\"name\":\"Lana Pen\",\"price\":{\"minor_units\":600}}", http.StatusOK, "{\"code\":\"PEN\",\"name\":\"Lana Pen\",\"price\":{\"minor_units\":600,\"currency\":\"EUR\",\"formatted\":\"6.00 EUR\"}}"},
+		{"/api/v1/products/CAP", "{\"name\":\"Lana Cap\",\"price\":{\"minor_units\":600}}", http.StatusNotFound, "Product not found\n"},
+		{"/api/v1/products/PEN", "{\"name\":\"Lana Pen\",\"price\":{\"minor_units\":0}}", http.StatusBadRequest, "Product price must be positive\n"},
+		{"/api/v1/products/PEN", "{\"name\"", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", tc.url, strings.NewReader(tc.payload))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("HandleUpdateProduct wrong http status expected %d got %d", tc.status, w.Code)
+			return
+		}
+		if tc.expectedBody != "" && w.Body.String() != tc.expectedBody {
+			t.Errorf("HandleUpdateProduct wrong response body expected %s got %s", tc.expectedBody, w.Body.String())
+			return
+		}
+	}
+}
+
+func TestHandleDeleteProduct(t *testing.T) {
+	defer resetProducts()()
+	r := getRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/products/MUG", nil)
+	r.ServeHTTP(w, req)
+
+	expected := http.StatusNoContent
+	if w.Code != expected {
+		t.Errorf("HandleDeleteProduct wrong http status expected %d got %d", expected, w.Code)
+		return
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/products/MUG", nil)
+	r.ServeHTTP(w, req)
+
+	expected = http.StatusNotFound
+	if w.Code != expected {
+		t.Errorf("HandleDeleteProduct wrong http status expected %d got %d", expected, w.Code)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	defer resetProducts()()
+	r := gin.Default()
+	AddRoutes(r.Group("/api/v1/"), func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+	cases := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", "/api/v1/products/", http.StatusOK},
+		{"GET", "/api/v1/products/PEN", http.StatusOK},
+		{"POST", "/api/v1/products/", http.StatusForbidden},
+		{"PUT", "/api/v1/products/PEN", http.StatusForbidden},
+		{"DELETE", "/api/v1/products/PEN", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader("{\"code\":\"CAP\",\"name\":\"Lana Cap\",\"price\":{\"minor_units\":1200}}"))
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s wrong http status expected %d got %d", tc.method, tc.url, tc.status, w.Code)
+			return
+		}
+	}
+	if !IsValidProduct(PEN) {
+		t.Errorf("product should not be deleted")
+	}
+}
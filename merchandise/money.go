@@ -0,0 +1,147 @@
+package merchandise
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultCurrency - ISO 4217 code of the currency used by the catalog
+const DefaultCurrency = "EUR"
+
+// digits after the decimal point for currencies not using 2
+var currencyExponents = map[string]int{
+	"BHD": 3,
+	"CLP": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+}
+
+// Money - model, exact amount expressed in minor units (cents) of an ISO 4217 currency
+// Arithmetic is done in integers, the only operation that needs rounding is Percent
+// which rounds half away from zero (2.5 cents -> 3 cents, -2.5 cents -> -3 cents).
+// Mixing currencies is a programming error and panics, a zero Money without currency
+// takes the currency of the other operand.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney - create an amount of minor units of a currency
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Cents - create an amount of minor units of DefaultCurrency
+func Cents(amount int64) Money {
+	return Money{Amount: amount, Currency: DefaultCurrency}
+}
+
+func (m Money) currency(other Money) string {
+	if m.Currency == "" {
+		return other.Currency
+	}
+	if other.Currency != "" && other.Currency != m.Currency {
+		panic(fmt.Sprintf("currency mismatch %s and %s", m.Currency, other.Currency))
+	}
+	return m.Currency
+}
+
+// Add - sum two amounts of the same currency
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.currency(other)}
+}
+
+// Sub - substract two amounts of the same currency
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.currency(other)}
+}
+
+// Mul - multiply amount by a quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+// Percent - percentage of the amount, rounded half away from zero
+func (m Money) Percent(percentage int64) Money {
+	return Money{Amount: divRound(m.Amount*percentage, 100), Currency: m.Currency}
+}
+
+// integer division rounding half away from zero
+func divRound(n, d int64) int64 {
+	q := n / d
+	r := n % d
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+// IsZero - true if amount is 0
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsPositive - true if amount is greater than 0
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// String - amount with its decimal point and currency code, i.e. "32.50 EUR"
+func (m Money) String() string {
+	exponent, ok := currencyExponents[m.Currency]
+	if !ok {
+		exponent = 2
+	}
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := fmt.Sprintf("%0*d", exponent+1, amount)
+	formatted := digits
+	if exponent > 0 {
+		formatted = digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+	}
+	return strings.TrimSpace(sign + formatted + " " + m.Currency)
+}
+
+// json representation, formatted is ignored when reading
+type moneyJSON struct {
+	MinorUnits int64  `json:"minor_units"`
+	Currency   string `json:"currency"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// MarshalJSON - serialize as minor units plus a formatted string
+func (m Money) MarshalJSON() ([]byte, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	m.Currency = currency
+	return json.Marshal(moneyJSON{MinorUnits: m.Amount, Currency: currency, Formatted: m.String()})
+}
+
+// UnmarshalJSON - read minor units and currency (DefaultCurrency if missing)
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var value moneyJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	m.Amount = value.MinorUnits
+	m.Currency = strings.ToUpper(value.Currency)
+	if m.Currency == "" {
+		m.Currency = DefaultCurrency
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package merchandise
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	// 3 mugs with 25% off: 22.50 - 5.625 = 16.875 -> 16.87 (discount rounded to 5.63)
+	line := Cents(750).Mul(3)
+	discount := line.Percent(25)
+	if discount != Cents(563) {
+		t.Errorf("wrong discount expected 5.63 EUR got %s", discount)
+	}
+	total := line.Sub(discount)
+	if total != Cents(1687) {
+		t.Errorf("wrong total expected 16.87 EUR got %s", total)
+	}
+	if Cents(100).Add(Cents(250)) != Cents(350) {
+		t.Errorf("wrong sum expected 3.50 EUR")
+	}
+	// zero value takes the currency of the other operand
+	if (Money{}).Add(Cents(100)) != Cents(100) {
+		t.Errorf("zero Money should adopt currency")
+	}
+}
+
+func TestMoneyPercentRounding(t *testing.T) {
+	cases := []struct {
+		amount     int64
+		percentage int64
+		expected   int64
+	}{
+		{1000, 25, 250},
+		{10, 25, 3},   // 2.5 rounds up
+		{9, 25, 2},    // 2.25 rounds down
+		{-10, 25, -3}, // -2.5 rounds away from zero
+		{-9, 25, -2},
+		{333, 50, 167},
+	}
+	for _, tc := range cases {
+		result := Cents(tc.amount).Percent(tc.percentage)
+		if result.Amount != tc.expected {
+			t.Errorf("%d%% of %d expected %d got %d", tc.percentage, tc.amount, tc.expected, result.Amount)
+		}
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("adding different currencies should panic")
+		}
+	}()
+	Cents(100).Add(NewMoney(100, "USD"))
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := []struct {
+		money    Money
+		expected string
+	}{
+		{Cents(3250), "32.50 EUR"},
+		{Cents(5), "0.05 EUR"},
+		{Cents(-150), "-1.50 EUR"},
+		{Cents(0), "0.00 EUR"},
+		{NewMoney(500, "JPY"), "500 JPY"},
+		{NewMoney(1234, "KWD"), "1.234 KWD"},
+	}
+	for _, tc := range cases {
+		if tc.money.String() != tc.expected {
+			t.Errorf("wrong format expected %s got %s", tc.expected, tc.money.String())
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(Cents(750))
+	if err != nil {
+		t.Errorf("Marshal returned an error %s", err.Error())
+		return
+	}
+	expected := "{\"minor_units\":750,\"currency\":\"EUR\",\"formatted\":\"7.50 EUR\"}"
+	if string(data) != expected {
+		t.Errorf("wrong json expected %s got %s", expected, string(data))
+	}
+	var m Money
+	if err := json.Unmarshal([]byte("{\"minor_units\":199,\"currency\":\"usd\"}"), &m); err != nil {
+		t.Errorf("Unmarshal returned an error %s", err.Error())
+		return
+	}
+	if m != NewMoney(199, "USD") {
+		t.Errorf("wrong money expected 1.99 USD got %s", m)
+	}
+	// currency defaults to catalog currency
+	_ = json.Unmarshal([]byte("{\"minor_units\":199}"), &m)
+	if m != Cents(199) {
+		t.Errorf("wrong money expected 1.99 EUR got %s", m)
+	}
+	if err := json.Unmarshal([]byte("\"1.99\""), &m); err == nil {
+		t.Errorf("Unmarshal should only accept objects")
+	}
+}
@@ -1,17 +1,36 @@
 package merchandise
 
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
 // Product - model, Lana's awesome merchandise item (PEN, TSHIRT, MUG)
 // Code         | Name              |  Price
 // -----------------------------------------------
 // PEN          | Lana Pen          |   5.00€
 // TSHIRT       | Lana T-Shirt      |  20.00€
 // MUG          | Lana Coffee Mug   |   7.50€
+// PriceTiers are volume prices, every unit costs the price of the highest tier reached by the
+// quantity bought (i.e. 1-9 at Price, 10-49 at the 10 units tier price, 50+ at the 50 units one)
 type Product struct {
-	Code  string
-	Name  string
-	Price float64
+	Code       string      `json:"code"`
+	Name       string      `json:"name"`
+	Price      Money       `json:"price"`
+	PriceTiers []PriceTier `json:"price_tiers,omitempty"`
+}
+
+// PriceTier - model, unit price when buying at least MinQuantity units of a product
+type PriceTier struct {
+	MinQuantity int64 `json:"min_quantity"`
+	Price       Money `json:"price"`
 }
 
+// MaxPrice - highest price of a product in minor units (1000000.00 EUR), keeps the amounts
+// of baskets holding many units far from overflowing
+const MaxPrice int64 = 100000000
+
 // PEN constant for lookup
 const PEN string = "PEN"
 
@@ -21,19 +40,141 @@ const TSHIRT string = "TSHIRT"
 // MUG constant for lookup
 const MUG string = "MUG"
 
+// UnitPrice - price of each unit when buying quantity units
+func (product Product) UnitPrice(quantity int64) Money {
+	price := product.Price
+	// tiers are kept sorted by quantity
+	for _, tier := range product.PriceTiers {
+		if quantity < tier.MinQuantity {
+			break
+		}
+		price = tier.Price
+	}
+	return price
+}
+
+// Mutex to syncronize access to the catalog
+var productsLock = sync.RWMutex{}
+
 var products = map[string]Product{
-	PEN:    Product{Code: PEN, Name: "Lana Pen", Price: 5.00},
-	TSHIRT: Product{Code: TSHIRT, Name: "Lana T-Shirt", Price: 20.00},
-	MUG:    Product{Code: MUG, Name: "Lana Coffee Mug", Price: 7.50},
+	PEN:    Product{Code: PEN, Name: "Lana Pen", Price: Cents(500)},
+	TSHIRT: Product{Code: TSHIRT, Name: "Lana T-Shirt", Price: Cents(2000)},
+	MUG:    Product{Code: MUG, Name: "Lana Coffee Mug", Price: Cents(750)},
 }
 
-// GetProduct - dummy function to simulate access to some product "persistance"
+// GetProduct - get a product from the catalog (zero Product if it does not exist)
 func GetProduct(prod string) Product {
+	productsLock.RLock()
+	defer productsLock.RUnlock()
 	return products[prod]
 }
 
-// IsValidProduct - dummy function to validate product existance
+// IsValidProduct - validate product existance
 func IsValidProduct(prod string) bool {
+	productsLock.RLock()
+	defer productsLock.RUnlock()
 	_, ok := products[prod]
 	return ok
 }
+
+// ListProducts - get every product in the catalog sorted by code
+func ListProducts() []Product {
+	productsLock.RLock()
+	defer productsLock.RUnlock()
+	list := make([]Product, 0, len(products))
+	for _, product := range products {
+		list = append(list, product)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+func validateProduct(product Product) error {
+	if product.Code == "" {
+		return fmt.Errorf("Product code is required")
+	}
+	if product.Name == "" {
+		return fmt.Errorf("Product name is required")
+	}
+	if !product.Price.IsPositive() {
+		return fmt.Errorf("Product price must be positive")
+	}
+	if product.Price.Amount > MaxPrice {
+		return fmt.Errorf("Product price must be at most %s", Cents(MaxPrice))
+	}
+	if product.Price.Currency != DefaultCurrency {
+		return fmt.Errorf("Product price must be in %s", DefaultCurrency)
+	}
+	seen := make(map[int64]bool, len(product.PriceTiers))
+	for _, tier := range product.PriceTiers {
+		if tier.MinQuantity <= 1 {
+			return fmt.Errorf("Price tier min_quantity must be greater than 1")
+		}
+		if seen[tier.MinQuantity] {
+			return fmt.Errorf("Price tier min_quantity %d is duplicated", tier.MinQuantity)
+		}
+		seen[tier.MinQuantity] = true
+		if !tier.Price.IsPositive() {
+			return fmt.Errorf("Price tier price must be positive")
+		}
+		if tier.Price.Amount > MaxPrice {
+			return fmt.Errorf("Price tier price must be at most %s", Cents(MaxPrice))
+		}
+		if tier.Price.Currency != DefaultCurrency {
+			return fmt.Errorf("Price tier price must be in %s", DefaultCurrency)
+		}
+	}
+	return nil
+}
+
+// copy of the product with its tiers sorted by quantity, the catalog doesn't share them with callers
+func withSortedTiers(product Product) Product {
+	if len(product.PriceTiers) == 0 {
+		product.PriceTiers = nil
+		return product
+	}
+	product.PriceTiers = append([]PriceTier(nil), product.PriceTiers...)
+	sort.Slice(product.PriceTiers, func(i, j int) bool {
+		return product.PriceTiers[i].MinQuantity < product.PriceTiers[j].MinQuantity
+	})
+	return product
+}
+
+// CreateProduct - add a new product to the catalog, code must be unique
+func CreateProduct(product Product) error {
+	if err := validateProduct(product); err != nil {
+		return err
+	}
+	productsLock.Lock()
+	defer productsLock.Unlock()
+	if _, ok := products[product.Code]; ok {
+		return fmt.Errorf("Product already exists")
+	}
+	products[product.Code] = withSortedTiers(product)
+	return nil
+}
+
+// UpdateProduct - replace name, price and price tiers of an existing product
+func UpdateProduct(product Product) error {
+	if err := validateProduct(product); err != nil {
+		return err
+	}
+	productsLock.Lock()
+	defer productsLock.Unlock()
+	if _, ok := products[product.Code]; !ok {
+		return fmt.Errorf("Product not found")
+	}
+	products[product.Code] = withSortedTiers(product)
+	return nil
+}
+
+// DeleteProduct - remove a product from the catalog
+func DeleteProduct(code string) error {
+	productsLock.Lock()
+	defer productsLock.Unlock()
+	if _, ok := products[code]; !ok {
+		return fmt.Errorf("Product not found")
+	}
+	delete(products, code)
+	return nil
+}
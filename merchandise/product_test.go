@@ -1,6 +1,9 @@
 package merchandise
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestProductMapIsInitialized(t *testing.T) {
 	if len(products) != 3 {
@@ -18,13 +21,13 @@ func TestProductMapIsInitialized(t *testing.T) {
 }
 
 func TestGetProduct(t *testing.T) {
-	if GetProduct(PEN) != products[PEN] {
+	if !reflect.DeepEqual(GetProduct(PEN), products[PEN]) {
 		t.Errorf("Lana Pen not found!")
 	}
-	if GetProduct(TSHIRT) != products[TSHIRT] {
+	if !reflect.DeepEqual(GetProduct(TSHIRT), products[TSHIRT]) {
 		t.Errorf("Lana T-Shirt not found ")
 	}
-	if GetProduct(MUG) != products[MUG] {
+	if !reflect.DeepEqual(GetProduct(MUG), products[MUG]) {
 		t.Errorf("Lana Coffee Mug not found")
 	}
 }
@@ -37,3 +40,144 @@ func TestIsValidProduct(t *testing.T) {
 		t.Errorf("Lana Merchandise don't have Rocket Fuel")
 	}
 }
+
+// restore catalog after tests that modify it
+func resetProducts() func() {
+	productsLock.Lock()
+	defer productsLock.Unlock()
+	saved := make(map[string]Product, len(products))
+	for code, product := range products {
+		saved[code] = product
+	}
+	return func() {
+		productsLock.Lock()
+		defer productsLock.Unlock()
+		products = saved
+	}
+}
+
+func TestListProducts(t *testing.T) {
+	list := ListProducts()
+	if len(list) != 3 {
+		t.Errorf("wrong number of products expected 3 got %d", len(list))
+		return
+	}
+	// sorted by code
+	if list[0].Code != MUG || list[1].Code != PEN || list[2].Code != TSHIRT {
+		t.Errorf("products are not sorted by code %v", list)
+	}
+}
+
+func TestCreateProduct(t *testing.T) {
+	defer resetProducts()()
+	lanaCap := Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200)}
+	if err := CreateProduct(lanaCap); err != nil {
+		t.Errorf("CreateProduct returned an error %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(GetProduct("CAP"), lanaCap) {
+		t.Errorf("Lana Cap not found")
+		return
+	}
+	err := CreateProduct(lanaCap)
+	if err == nil || err.Error() != "Product already exists" {
+		t.Errorf("CreateProduct should not allow duplicated codes")
+	}
+}
+
+func TestCreateProductValidation(t *testing.T) {
+	defer resetProducts()()
+	cases := []struct {
+		product  Product
+		expected string
+	}{
+		{Product{Name: "Lana Cap", Price: Cents(1200)}, "Product code is required"},
+		{Product{Code: "CAP", Price: Cents(1200)}, "Product name is required"},
+		{Product{Code: "CAP", Name: "Lana Cap"}, "Product price must be positive"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(-100)}, "Product price must be positive"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: NewMoney(100, "USD")}, "Product price must be in EUR"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1 << 62)}, "Product price must be at most 1000000.00 EUR"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200), PriceTiers: []PriceTier{{MinQuantity: 1, Price: Cents(1000)}}}, "Price tier min_quantity must be greater than 1"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200), PriceTiers: []PriceTier{{MinQuantity: 10, Price: Cents(1000)}, {MinQuantity: 10, Price: Cents(900)}}}, "Price tier min_quantity 10 is duplicated"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200), PriceTiers: []PriceTier{{MinQuantity: 10}}}, "Price tier price must be positive"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200), PriceTiers: []PriceTier{{MinQuantity: 10, Price: NewMoney(900, "USD")}}}, "Price tier price must be in EUR"},
+		{Product{Code: "CAP", Name: "Lana Cap", Price: Cents(1200), PriceTiers: []PriceTier{{MinQuantity: 10, Price: Cents(MaxPrice + 1)}}}, "Price tier price must be at most 1000000.00 EUR"},
+	}
+	for _, tc := range cases {
+		err := CreateProduct(tc.product)
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("Wrong error expected %s but got %v", tc.expected, err)
+		}
+	}
+	if IsValidProduct("CAP") {
+		t.Errorf("Invalid product should not be added to the catalog")
+	}
+}
+
+func TestUnitPrice(t *testing.T) {
+	defer resetProducts()()
+	// tiers can be given in any order
+	err := UpdateProduct(Product{Code: PEN, Name: "Lana Pen", Price: Cents(500), PriceTiers: []PriceTier{
+		{MinQuantity: 50, Price: Cents(350)},
+		{MinQuantity: 10, Price: Cents(450)},
+	}})
+	if err != nil {
+		t.Errorf("UpdateProduct returned an error %s", err.Error())
+		return
+	}
+	pen := GetProduct(PEN)
+	cases := []struct {
+		quantity int64
+		price    int64
+	}{
+		{1, 500},
+		{9, 500},
+		{10, 450},
+		{49, 450},
+		{50, 350},
+		{500, 350},
+	}
+	for _, tc := range cases {
+		if price := pen.UnitPrice(tc.quantity); price != Cents(tc.price) {
+			t.Errorf("unit price of %d pens expected %d got %s", tc.quantity, tc.price, price)
+		}
+	}
+	if GetProduct(MUG).UnitPrice(100) != Cents(750) {
+		t.Errorf("products without tiers should always cost their price")
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	defer resetProducts()()
+	err := UpdateProduct(Product{Code: PEN, Name: "Lana Pen v2", Price: Cents(600)})
+	if err != nil {
+		t.Errorf("UpdateProduct returned an error %s", err.Error())
+		return
+	}
+	if GetProduct(PEN).Price != Cents(600) {
+		t.Errorf("Lana Pen price was not updated")
+	}
+	err = UpdateProduct(Product{Code: "CAP", Name: "Lana Cap", Price: Cents(600)})
+	if err == nil || err.Error() != "Product not found" {
+		t.Errorf("UpdateProduct should have returned Product not found")
+	}
+	err = UpdateProduct(Product{Code: PEN, Name: "Lana Pen", Price: Cents(0)})
+	if err == nil || err.Error() != "Product price must be positive" {
+		t.Errorf("UpdateProduct should validate product")
+	}
+}
+
+func TestDeleteProduct(t *testing.T) {
+	defer resetProducts()()
+	if err := DeleteProduct(MUG); err != nil {
+		t.Errorf("DeleteProduct returned an error %s", err.Error())
+		return
+	}
+	if IsValidProduct(MUG) {
+		t.Errorf("Lana Coffee Mug should have been deleted")
+	}
+	err := DeleteProduct(MUG)
+	if err == nil || err.Error() != "Product not found" {
+		t.Errorf("DeleteProduct should have returned Product not found")
+	}
+}
@@ -0,0 +1,46 @@
+package merchandise
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// AddRoutes - add routes for catalog management, admin middlewares run before the routes
+// changing the catalog (i.e. to only allow admins)
+func AddRoutes(rg *gin.RouterGroup, admin ...gin.HandlerFunc) {
+
+	r := rg.Group("/products")
+	w := r.Group("", admin...)
+
+	r.GET("/", func(c *gin.Context) {
+		HandleGetAllProducts(c)
+	})
+
+	r.GET("/:code", func(c *gin.Context) {
+		HandleGetProduct(c, c.Params.ByName("code"))
+	})
+
+	w.POST("/", func(c *gin.Context) {
+		var product Product
+		if err := c.BindJSON(&product); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		HandleCreateProduct(c, product)
+	})
+
+	w.PUT("/:code", func(c *gin.Context) {
+		var product Product
+		if err := c.BindJSON(&product); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		// code can't be changed, it is taken from url
+		product.Code = c.Params.ByName("code")
+		HandleUpdateProduct(c, product)
+	})
+
+	w.DELETE("/:code", func(c *gin.Context) {
+		HandleDeleteProduct(c, c.Params.ByName("code"))
+	})
+}